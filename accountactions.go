@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	mastodon "github.com/mattn/go-mastodon"
+)
+
+/// Following, muting and blocking accounts, given as @user@instance or as the URL of their profile.
+/// Our instance resolves remote accounts via WebFinger when we search for them.
+/// Like status actions, they are undone when the matrix message that caused them is redacted.
+
+type MastodonAccountAction struct {
+	PrefixConfName string // name of the prefix in config section [matrix]
+	Endpoint       string // below /api/v1/accounts/:id/
+	Done           string // what we did with %s, to tell the user
+	Reverse        MsgStatusDataAction
+	Irreversible   bool // Reverse is meaningless
+}
+
+var mastodon_account_actions_ = map[MsgStatusDataAction]MastodonAccountAction{
+	actionFollow:         {PrefixConfName: "follow_prefix", Endpoint: "follow", Done: "followed %s", Reverse: actionUnfollow},
+	actionUnfollow:       {PrefixConfName: "unfollow_prefix", Endpoint: "unfollow", Done: "unfollowed %s", Reverse: actionFollow},
+	actionMuteAccount:    {PrefixConfName: "mute_prefix", Endpoint: "mute", Done: "muted %s", Reverse: actionUnmuteAccount},
+	actionUnmuteAccount:  {PrefixConfName: "unmute_prefix", Endpoint: "unmute", Done: "unmuted %s", Reverse: actionMuteAccount},
+	actionBlock:          {PrefixConfName: "block_prefix", Endpoint: "block", Done: "blocked %s", Reverse: actionUnblock},
+	actionUnblock:        {PrefixConfName: "unblock_prefix", Endpoint: "unblock", Done: "unblocked %s", Reverse: actionBlock},
+	actionRemoveFollower: {PrefixConfName: "removefollower_prefix", Endpoint: "remove_from_followers", Done: "removed %s from your followers", Irreversible: true},
+}
+
+var (
+	// profile URLs of Mastodon, Pleroma, Akkoma, Misskey, GoToSocial and Friendica
+	profile_url_re_        = regexp.MustCompile(`^https?://([^/\s]+)/(?:@|users/|u/|profile/)([\w.\-]+)/?$`)
+	account_handle_re_     = regexp.MustCompile(`^@?([\w.\-]+)(?:@([\w.\-]+\w))?$`)
+	mute_notifications_re_ = regexp.MustCompile(`(?i)^notifications:(\w+)$`)
+	account_duration_re_   = regexp.MustCompile(`^(?:(\d+)w)?(?:(\d+)d)?(?:(\d+)h)?(?:(\d+)m)?$`)
+)
+
+// the account action whose prefix post starts with
+func getMastodonAccountActionOfPost(post string) (MsgStatusDataAction, bool) {
+	for action, accountaction := range mastodon_account_actions_ {
+		if prefix := c["matrix"][accountaction.PrefixConfName]; len(prefix) > 0 && strings.HasPrefix(post, prefix) {
+			return action, true
+		}
+	}
+	return 0, false
+}
+
+// what to search for to find the account given by arg and its acct, user@instance or just user for local accounts
+func parseAccountArg(arg string) (query, acct string, err error) {
+	if m := profile_url_re_.FindStringSubmatch(arg); m != nil {
+		return arg, m[2] + "@" + m[1], nil
+	}
+	if m := account_handle_re_.FindStringSubmatch(arg); m != nil {
+		if len(m[2]) == 0 {
+			return m[1], m[1], nil
+		}
+		return "@" + m[1] + "@" + m[2], m[1] + "@" + m[2], nil
+	}
+	return "", "", fmt.Errorf("'%s' is neither @user@instance nor the URL of a profile", arg)
+}
+
+// acct with the domain of our instance added to local accounts
+func fullAcct(acct string) string {
+	if strings.Contains(acct, "@") {
+		return acct
+	}
+	if server, err := url.Parse(c["mastodon"]["server"]); err == nil && len(server.Host) > 0 {
+		return acct + "@" + server.Host
+	}
+	return acct
+}
+
+// does account have acct, with or without the domain of our instance
+func accountHasAcct(account *mastodon.Account, acct string) bool {
+	if !strings.Contains(acct, "@") {
+		return strings.EqualFold(account.Acct, acct)
+	}
+	return strings.EqualFold(fullAcct(account.Acct), acct)
+}
+
+// find the account given by arg on our instance
+func resolveMastodonAccount(client *mastodon.Client, arg string) (*mastodon.Account, error) {
+	query, acct, err := parseAccountArg(arg)
+	if err != nil {
+		return nil, err
+	}
+	results, err := client.Search(context.Background(), query, true)
+	if err != nil {
+		return nil, err
+	}
+	for _, account := range results.Accounts {
+		if accountHasAcct(account, acct) || account.URL == query {
+			return account, nil
+		}
+	}
+	return nil, fmt.Errorf("your instance could not find the account %s", arg)
+}
+
+// duration of a mute like 2w, 7d, 1d12h or 30m. "forever" is 0, which is what mastodon calls indefinitely
+func parseAccountActionDuration(s string) (time.Duration, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "forever" {
+		return 0, nil
+	}
+	m := account_duration_re_.FindStringSubmatch(s)
+	if m == nil || len(s) == 0 {
+		return 0, fmt.Errorf("could not understand duration '%s'. Try e.g. '7d', '12h' or 'forever'", s)
+	}
+	var duration time.Duration
+	for idx, unit := range []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute} {
+		if len(m[idx+1]) > 0 {
+			n, err := strconv.Atoi(m[idx+1])
+			if err != nil {
+				return 0, fmt.Errorf("could not understand duration '%s'", s)
+			}
+			duration += time.Duration(n) * unit
+		}
+	}
+	if duration <= 0 {
+		return 0, fmt.Errorf("duration '%s' is too short, say 'forever' to mute indefinitely", s)
+	}
+	return duration, nil
+}
+
+// optional duration like 7d or forever and notifications:no of the mute command
+func parseMuteOptions(args []string) (url.Values, error) {
+	params := url.Values{}
+	for _, arg := range args {
+		if m := mute_notifications_re_.FindStringSubmatch(arg); m != nil {
+			mutenotifications, err := parseYesNo(m[1])
+			if err != nil {
+				return nil, err
+			}
+			params.Set("notifications", strconv.FormatBool(mutenotifications))
+			continue
+		}
+		duration, err := parseAccountActionDuration(arg)
+		if err != nil {
+			return nil, err
+		}
+		params.Set("duration", strconv.Itoa(int(duration.Seconds())))
+	}
+	return params, nil
+}
+
+func formatRelationship(relationship *mastodon.Relationship) string {
+	var states []string
+	switch {
+	case relationship.Following:
+		states = append(states, "you follow them")
+	case relationship.Requested:
+		states = append(states, "you requested to follow them")
+	}
+	if relationship.FollowedBy {
+		states = append(states, "they follow you")
+	}
+	if relationship.Muting && relationship.MutingNotifications {
+		states = append(states, "muted including notifications")
+	} else if relationship.Muting {
+		states = append(states, "muted")
+	}
+	if relationship.Blocking {
+		states = append(states, "blocked")
+	}
+	if len(states) == 0 {
+		return "you do not follow each other"
+	}
+	return strings.Join(states, ", ")
+}
+
+func doMastodonAccountAction(client *mastodon.Client, action MsgStatusDataAction, accountid mastodon.ID, params url.Values) (*mastodon.Relationship, error) {
+	accountaction, ok := mastodon_account_actions_[action]
+	if !ok {
+		return nil, fmt.Errorf("unknown account action %d", action)
+	}
+	var relationship mastodon.Relationship
+	if err := mastodonAPIRequest(context.Background(), client, http.MethodPost, fmt.Sprintf("/api/v1/accounts/%s/%s", accountid, accountaction.Endpoint), params, &relationship); err != nil {
+		return nil, err
+	}
+	return &relationship, nil
+}
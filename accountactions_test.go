@@ -0,0 +1,108 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	mastodon "github.com/mattn/go-mastodon"
+)
+
+func TestMastodonAccountActionsReverseEachOther(t *testing.T) {
+	for action, accountaction := range mastodon_account_actions_ {
+		if accountaction.Irreversible {
+			continue
+		}
+		reverse, ok := mastodon_account_actions_[accountaction.Reverse]
+		if !ok || reverse.Reverse != action || accountaction.Reverse == action {
+			t.Errorf("%s is not undone by its reverse %s", accountaction.Endpoint, reverse.Endpoint)
+		}
+	}
+}
+
+func TestParseAccountArg(t *testing.T) {
+	for _, tc := range []struct {
+		arg   string
+		query string
+		acct  string
+	}{
+		{"@qbit@chaos.social", "@qbit@chaos.social", "qbit@chaos.social"},
+		{"qbit@chaos.social", "@qbit@chaos.social", "qbit@chaos.social"},
+		{"@qbit", "qbit", "qbit"},
+		{"https://chaos.social/@qbit", "https://chaos.social/@qbit", "qbit@chaos.social"},
+		{"https://pleroma.example.org/users/some.one/", "https://pleroma.example.org/users/some.one/", "some.one@pleroma.example.org"},
+	} {
+		query, acct, err := parseAccountArg(tc.arg)
+		if err != nil || query != tc.query || acct != tc.acct {
+			t.Errorf("parseAccountArg(%q) = %q, %q, %v; want %q, %q", tc.arg, query, acct, err, tc.query, tc.acct)
+		}
+	}
+	for _, arg := range []string{"https://chaos.social/@qbit/102133941111331502", "@qbit@", "not an account"} {
+		if _, _, err := parseAccountArg(arg); err == nil {
+			t.Errorf("%q is no account", arg)
+		}
+	}
+}
+
+func TestAccountHasAcct(t *testing.T) {
+	useTestMastodonServer(t)
+	local := &mastodon.Account{Acct: "qbit"}
+	remote := &mastodon.Account{Acct: "qbit@example.org"}
+	if !accountHasAcct(local, "qbit@chaos.social") || !accountHasAcct(local, "QBIT") {
+		t.Error("local account not matched")
+	}
+	if accountHasAcct(local, "qbit@example.org") || accountHasAcct(remote, "qbit") || !accountHasAcct(remote, "qbit@example.org") {
+		t.Error("accounts of different instances must not match")
+	}
+}
+
+func TestParseMuteOptions(t *testing.T) {
+	params, err := parseMuteOptions([]string{"7d", "notifications:no"})
+	if err != nil || params.Get("duration") != "604800" || params.Get("notifications") != "false" {
+		t.Errorf("unexpected %v %v", params, err)
+	}
+	if params, err := parseMuteOptions(nil); err != nil || len(params) != 0 {
+		t.Errorf("no options should mute forever with mastodon's defaults, got %v %v", params, err)
+	}
+	if params, err := parseMuteOptions([]string{"forever"}); err != nil || params.Get("duration") != "0" {
+		t.Errorf("forever should mute indefinitely, got %v %v", params, err)
+	}
+	if _, err := parseMuteOptions([]string{"sometimes"}); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestParseAccountActionDuration(t *testing.T) {
+	for s, want := range map[string]time.Duration{
+		"7d":      7 * 24 * time.Hour,
+		"2w":      14 * 24 * time.Hour,
+		"1d12h":   36 * time.Hour,
+		"30m":     30 * time.Minute,
+		"Forever": 0,
+	} {
+		if got, err := parseAccountActionDuration(s); err != nil || got != want {
+			t.Errorf("parseAccountActionDuration(%q) = %s, %v, want %s", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "0d", "soon", "7 days", "1h30s"} {
+		if _, err := parseAccountActionDuration(s); err == nil {
+			t.Errorf("%q is no duration", s)
+		}
+	}
+}
+
+func TestFormatRelationship(t *testing.T) {
+	for _, tc := range []struct {
+		relationship mastodon.Relationship
+		expected     string
+	}{
+		{mastodon.Relationship{}, "you do not follow each other"},
+		{mastodon.Relationship{Following: true, FollowedBy: true}, "you follow them, they follow you"},
+		{mastodon.Relationship{Requested: true}, "you requested to follow them"},
+		{mastodon.Relationship{Muting: true, MutingNotifications: true}, "muted including notifications"},
+		{mastodon.Relationship{FollowedBy: true, Blocking: true}, "they follow you, blocked"},
+	} {
+		if formatted := formatRelationship(&tc.relationship); formatted != tc.expected {
+			t.Errorf("got %q, want %q", formatted, tc.expected)
+		}
+	}
+}
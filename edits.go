@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/matrix-org/gomatrix"
+)
+
+/// Matrix edits of messages we already tooted are turned into edits of the toot.
+/// An edit is an m.text event with m.relates_to.rel_type m.replace, the new text being in m.new_content
+
+// returns the id of the event that ev edits and the edited content, if ev is an edit
+func getMatrixEditedEventAndContent(ev *gomatrix.Event) (replaced_event_id string, new_content map[string]interface{}, isedit bool) {
+	if rel_type, _ := getMapDeepString(ev.Content, "m.relates_to", "rel_type"); rel_type != "m.replace" {
+		return
+	}
+	replaced_event_id, _ = getMapDeepString(ev.Content, "m.relates_to", "event_id")
+	new_content, _ = getMapDeepValue(ev.Content, "m.new_content").(map[string]interface{})
+	isedit = len(replaced_event_id) > 0 && new_content != nil
+	return
+}
+
+// strip the prefix that made post a toot, as well as the status url a reply starts with.
+// ispoll tells if the post was a poll
+func stripPostingPrefix(post string) (text string, ispoll bool, isposting bool) {
+	for _, prefixname := range []string{"guard_prefix", "thread_prefix", "unlisted_prefix", "followersonly_prefix", "directtoot_prefix", "tootreply_prefix", "poll_prefix"} {
+		prefix := c["matrix"][prefixname]
+		if len(prefix) == 0 || !strings.HasPrefix(post, prefix) {
+			continue
+		}
+		text = strings.TrimSpace(post[len(prefix):])
+		if prefixname == "directtoot_prefix" || prefixname == "tootreply_prefix" {
+			// the status we replied to can not be changed by editing
+			if arglist := strings.SplitN(text, " ", 2); len(arglist) == 2 && isFediverseStatusURL(strings.TrimSpace(arglist[0])) {
+				text = strings.TrimSpace(arglist[1])
+			}
+		}
+		return text, prefixname == "poll_prefix", true
+	}
+	return post, false, false
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gokyle/goconfig"
+	"github.com/matrix-org/gomatrix"
+)
+
+func TestGetMatrixEditedEventAndContent(t *testing.T) {
+	ev := &gomatrix.Event{Content: map[string]interface{}{
+		"body":          "* t> fixed typo",
+		"m.new_content": map[string]interface{}{"msgtype": "m.text", "body": "t> fixed typo"},
+		"m.relates_to":  map[string]interface{}{"rel_type": "m.replace", "event_id": "$original"},
+	}}
+	replaced_event_id, new_content, isedit := getMatrixEditedEventAndContent(ev)
+	if !isedit || replaced_event_id != "$original" || new_content["body"] != "t> fixed typo" {
+		t.Errorf("edit not recognized: %q %v %v", replaced_event_id, new_content, isedit)
+	}
+	ev.Content["m.relates_to"] = map[string]interface{}{"m.in_reply_to": map[string]interface{}{"event_id": "$original"}}
+	if _, _, isedit = getMatrixEditedEventAndContent(ev); isedit {
+		t.Error("reply was taken for an edit")
+	}
+}
+
+func TestStripPostingPrefix(t *testing.T) {
+	useTestConfig(t, goconfig.ConfigMap{"matrix": {"guard_prefix": "t>", "tootreply_prefix": "public_reply2>", "poll_prefix": "poll>"}})
+	for post, want := range map[string]struct {
+		text              string
+		ispoll, isposting bool
+	}{
+		"t> hello": {"hello", false, true},
+		"public_reply2> https://example.org/@alice/1234 hi": {"hi", false, true},
+		"poll> which?\n- a\n- b":                            {"which?\n- a\n- b", true, true},
+		"just chatting":                                     {"just chatting", false, false},
+	} {
+		text, ispoll, isposting := stripPostingPrefix(post)
+		if text != want.text || ispoll != want.ispoll || isposting != want.isposting {
+			t.Errorf("stripPostingPrefix(%q) = %q %v %v", post, text, ispoll, isposting)
+		}
+	}
+}
@@ -26,13 +26,49 @@ func (frc *FeedRoomConnector) uploadImageLinkToMatrix(imgurl string) MxUploadedI
 func (frc *FeedRoomConnector) writeNotificationToRoom(notification *mastodon.Notification, mroom string) {
 	log.Println("writeNotificationToRoom:", mroom)
 	text, htmltext := formatNotificationForMatrix(notification)
-	frc.mxcli.SendMessageEvent(mroom, "m.room.message", gomatrix.HTMLMessage{MsgType: "m.notice", Format: "org.matrix.custom.html", Body: text, FormattedBody: htmltext})
+	resp, err := frc.mxcli.SendMessageEvent(mroom, "m.room.message", gomatrix.HTMLMessage{MsgType: "m.notice", Format: "org.matrix.custom.html", Body: text, FormattedBody: htmltext})
+	if notification.Status != nil {
+		frc.rememberNotice(mroom, resp, err, notification.Status.ID)
+		if err == nil {
+			rememberMirroredStatus(mroom, notification.Status, notification.Type == "mention")
+		}
+	}
+	if notification.Type == "follow_request" {
+		frc.rememberFollowRequestNotice(mroom, resp, err, notification.Account.ID)
+	}
+}
+
+// remember which status a notice we sent is about, so that replying to the notice replies to the status.
+// Commands are only accepted in the controlling room, so notices in other rooms are not remembered
+func (frc *FeedRoomConnector) rememberNotice(mroom string, resp *gomatrix.RespSendEvent, err error, statusid mastodon.ID) {
+	if err != nil {
+		log.Println("FeedRoomConnector: could not send notice:", err)
+		return
+	}
+	if mroom == c["matrix"]["room_id"] && len(statusid) > 0 {
+		rememberFeedNotice(resp.EventID, FeedNotice{TootID: statusid})
+	}
+}
+
+// remember which account a follow request notice we sent is about, so that replying to the notice answers the request
+func (frc *FeedRoomConnector) rememberFollowRequestNotice(mroom string, resp *gomatrix.RespSendEvent, err error, accountid mastodon.ID) {
+	if err != nil {
+		log.Println("FeedRoomConnector: could not send notice:", err)
+		return
+	}
+	if mroom == c["matrix"]["room_id"] && len(accountid) > 0 {
+		rememberFeedNotice(resp.EventID, FeedNotice{AccountID: accountid})
+	}
 }
 
 func (frc *FeedRoomConnector) writeStatusToRoom(status *mastodon.Status, mroom string) {
 	log.Println("writeStatusToRoom:", "status:", status.ID, "to room:", mroom)
 	text, htmltext := formatStatusForMatrix(status)
-	frc.mxcli.SendMessageEvent(mroom, "m.room.message", gomatrix.HTMLMessage{MsgType: "m.notice", Format: "org.matrix.custom.html", Body: text, FormattedBody: htmltext})
+	resp, err := frc.mxcli.SendMessageEvent(mroom, "m.room.message", gomatrix.HTMLMessage{MsgType: "m.notice", Format: "org.matrix.custom.html", Body: text, FormattedBody: htmltext})
+	frc.rememberNotice(mroom, resp, err, status.ID)
+	if err == nil {
+		rememberMirroredStatus(mroom, status, false)
+	}
 
 	if status.MediaAttachments != nil && len(status.MediaAttachments) > 0 && len(status.MediaAttachments) <= feed2matrx_image_count_limit_ {
 		for _, attachment := range status.MediaAttachments {
@@ -76,13 +112,14 @@ func (frc *FeedRoomConnector) writeStatusToRoom(status *mastodon.Status, mroom s
 							Size:     uint(thumbnail_content_data.contentlength),
 						}
 					}
-					frc.mxcli.SendMessageEvent(mroom, "m.room.message",
+					resp, err := frc.mxcli.SendMessageEvent(mroom, "m.room.message",
 						gomatrix.ImageMessage{
 							MsgType: "m.image",
 							Body:    bodytext,
 							URL:     content_data.mxcurl,
 							Info:    imginfo,
 						})
+					frc.rememberNotice(mroom, resp, err, status.ID)
 
 				} else {
 					log.Printf("writeStatusToRoom: Image not uploaded: attachment: %+v, imgurl: %s, Err: %s", attachment, imgurl, content_data.err)
@@ -142,7 +179,9 @@ func taskWriteMastodonBackIntoMatrixRooms(mclient *mastodon.Client, mxcli *gomat
 		tclient:        nil,
 		mxcli:          mxcli,
 		mxlinkupload_c: taskUploadImageLinksToMatrix(mxcli),
+		cursors:        loadFeedCursors(),
 	}
+	go frc.cursors.flushPeriodically()
 
 	//configuation for controlling room
 	show_mastodon_notifications := c.GetValueDefault("feed2matrix", "show_mastodon_notifications", "true") == "true"
@@ -232,24 +271,29 @@ func taskWriteMastodonBackIntoMatrixRooms(mclient *mastodon.Client, mxcli *gomat
 		filter_duplicates_and_selfsent_c, next_in_chain_)
 
 	//subscribe home stream
-	homestream, err := mclient.StreamingUser(context.Background())
-	if err != nil {
-		panic(err)
-	}
 	//--> homestream		--> filter_ownposts_c
 	//						\-> notification2myroom_c
-	go frc.runSplitMastodonEventStream(homestream, filter_ownposts_with_private_c, notification2myroom_c)
+	go frc.runSupervisedMastodonStream(MastodonStreamSource{
+		name:                    "home",
+		subscribe:               mclient.StreamingUser,
+		fetchStatusesSince:      mclient.GetTimelineHome,
+		fetchNotificationsSince: mclient.GetNotifications,
+	}, filter_ownposts_with_private_c, notification2myroom_c)
 
 	//subscribe tags in addition to home stream
 	for _, tag := range subscribe_tagstreams {
 		log.Println("taskWriteMastodonBackIntoMatrixRooms: subscribing tag", tag)
-		tagstream, err := mclient.StreamingHashtag(context.Background(), tag, false)
-		if err != nil {
-			panic(err)
-		}
 		//--> tagstream			--> next_in_chain_
 		//						\-> nil
-		go frc.runSplitMastodonEventStream(tagstream, next_in_chain_, nil)
+		go frc.runSupervisedMastodonStream(MastodonStreamSource{
+			name: "#" + tag,
+			subscribe: func(ctx context.Context) (chan mastodon.Event, error) {
+				return mclient.StreamingHashtag(ctx, tag, false)
+			},
+			fetchStatusesSince: func(ctx context.Context, pg *mastodon.Pagination) ([]*mastodon.Status, error) {
+				return mclient.GetTimelineHashtag(ctx, tag, false, pg)
+			},
+		}, next_in_chain_, nil)
 	}
 
 	//goroutine writing stuff to controlling room
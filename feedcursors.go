@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	mastodon "github.com/mattn/go-mastodon"
+)
+
+// Remembers the newest status and notification IDs we have seen on each stream,
+// so that after a reconnect or restart we can backfill what we missed.
+// Also remembers the IDs seen recently, so that what the stream and the backfill both deliver is forwarded once.
+// Statuses that federate late have smaller IDs than ones seen before, but must still be forwarded.
+
+const (
+	feed_cursors_filename_      = "feedcursors.json"
+	feed_cursors_seen_ids_size_ = 1000 // per stream
+	feed_cursors_save_interval_ = 10 * time.Second
+)
+
+type FeedCursors struct {
+	lock     sync.Mutex
+	filepath string
+	cursors  map[string]mastodon.ID
+	seen     map[string]*recentIDs
+	dirty    bool // cursors changed since they were last saved
+}
+
+// bounded set of IDs, forgetting the oldest one added first
+type recentIDs struct {
+	ring []mastodon.ID
+	next int
+	set  map[mastodon.ID]bool
+}
+
+// returns false if id is already in the set
+func (r *recentIDs) add(id mastodon.ID) bool {
+	if r.set[id] {
+		return false
+	}
+	if len(r.ring) < cap(r.ring) {
+		r.ring = append(r.ring, id)
+	} else {
+		delete(r.set, r.ring[r.next])
+		r.ring[r.next] = id
+		r.next = (r.next + 1) % len(r.ring)
+	}
+	r.set[id] = true
+	return true
+}
+
+// compare two status or notification IDs
+// Mastodon IDs are numeric, other implementations use sortable strings of constant length
+func compareMastodonIDs(a, b mastodon.ID) int {
+	ia, erra := strconv.ParseUint(string(a), 10, 64)
+	ib, errb := strconv.ParseUint(string(b), 10, 64)
+	switch {
+	case erra == nil && errb == nil && ia < ib:
+		return -1
+	case erra == nil && errb == nil && ia > ib:
+		return 1
+	case erra == nil && errb == nil:
+		return 0
+	case len(a) != len(b):
+		if len(a) < len(b) {
+			return -1
+		}
+		return 1
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// loads cursors from state_dir if persistence is enabled. Run flushPeriodically to save them there
+func loadFeedCursors() *FeedCursors {
+	fc := &FeedCursors{cursors: make(map[string]mastodon.ID), seen: make(map[string]*recentIDs)}
+	if len(persistence_state_dir_) == 0 {
+		return fc
+	}
+	fc.filepath = path.Join(persistence_state_dir_, feed_cursors_filename_)
+	contents, err := ioutil.ReadFile(fc.filepath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("loadFeedCursors:", err)
+		}
+		return fc
+	}
+	if err = json.Unmarshal(contents, &fc.cursors); err != nil {
+		log.Println("loadFeedCursors: ignoring corrupt file:", err)
+		fc.cursors = make(map[string]mastodon.ID)
+	}
+	return fc
+}
+
+func (fc *FeedCursors) Get(name string) mastodon.ID {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	return fc.cursors[name]
+}
+
+// remember id as seen on stream name and as its newest id, unless we have already seen a newer one.
+// returns false if id was seen recently, i.e. must not be forwarded again
+func (fc *FeedCursors) MarkSeen(name string, id mastodon.ID) bool {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	if len(id) == 0 {
+		return true
+	}
+	seen, inmap := fc.seen[name]
+	if !inmap {
+		seen = &recentIDs{ring: make([]mastodon.ID, 0, feed_cursors_seen_ids_size_), set: make(map[mastodon.ID]bool)}
+		fc.seen[name] = seen
+	}
+	if !seen.add(id) {
+		return false
+	}
+	if compareMastodonIDs(id, fc.cursors[name]) > 0 {
+		fc.cursors[name] = id
+		fc.dirty = true
+	}
+	return true
+}
+
+// saves changed cursors every feed_cursors_save_interval_, never returns
+func (fc *FeedCursors) flushPeriodically() {
+	for range time.Tick(feed_cursors_save_interval_) {
+		fc.Flush()
+	}
+}
+
+// save cursors if they changed since they were last saved
+func (fc *FeedCursors) Flush() {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	if !fc.dirty || len(fc.filepath) == 0 {
+		return
+	}
+	fc.dirty = false
+	contents, err := json.Marshal(fc.cursors)
+	if err != nil {
+		log.Println("FeedCursors.Flush:", err)
+		return
+	}
+	tmppath := fc.filepath + ".tmp"
+	if err = ioutil.WriteFile(tmppath, contents, 0600); err == nil {
+		err = os.Rename(tmppath, fc.filepath)
+	}
+	if err != nil {
+		log.Println("FeedCursors.Flush:", err)
+		fc.dirty = true
+	}
+}
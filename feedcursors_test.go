@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	mastodon "github.com/mattn/go-mastodon"
+)
+
+func TestCompareMastodonIDs(t *testing.T) {
+	for _, tc := range []struct {
+		a, b mastodon.ID
+		want int
+	}{
+		{"9", "10", -1},
+		{"108000000000000001", "108000000000000000", 1},
+		{"42", "42", 0},
+		{"", "1", -1},
+		{"9zzz", "a000", -1},
+		{"a0001", "a000", 1},
+	} {
+		if got := compareMastodonIDs(tc.a, tc.b); got != tc.want {
+			t.Errorf("compareMastodonIDs(%q, %q) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestFeedCursorsSurviveRestart(t *testing.T) {
+	persistence_state_dir_ = t.TempDir()
+	defer func() { persistence_state_dir_ = "" }()
+
+	fc := loadFeedCursors()
+	if !fc.MarkSeen("home", "100") || !fc.MarkSeen("home", "101") {
+		t.Fatal("new id was not accepted")
+	}
+	if fc.MarkSeen("home", "101") {
+		t.Error("duplicate id was accepted")
+	}
+	if !fc.MarkSeen("home", "99") {
+		t.Error("status that federated late was dropped")
+	}
+	if got := fc.Get("home"); got != "101" {
+		t.Errorf("cursor moved back to %q", got)
+	}
+
+	if fc = loadFeedCursors(); fc.Get("home") != "" {
+		t.Error("cursors were saved before being flushed")
+	}
+	fc.MarkSeen("home", "101")
+	fc.Flush()
+	fc = loadFeedCursors()
+	if got := fc.Get("home"); got != "101" {
+		t.Errorf("cursor after restart is %q, want 101", got)
+	}
+	if got := fc.Get("#unknown"); got != "" {
+		t.Errorf("cursor of unseen stream is %q", got)
+	}
+}
+
+func TestFeedCursorsForgetOldestSeenIDs(t *testing.T) {
+	fc := loadFeedCursors()
+	for i := 0; i < feed_cursors_seen_ids_size_+1; i++ {
+		fc.MarkSeen("home", mastodon.ID(fmt.Sprint(1000+i)))
+	}
+	if len(fc.seen["home"].set) != feed_cursors_seen_ids_size_ {
+		t.Errorf("remembering %d ids", len(fc.seen["home"].set))
+	}
+	if !fc.MarkSeen("home", "1000") {
+		t.Error("oldest id was not forgotten")
+	}
+	if fc.MarkSeen("home", mastodon.ID(fmt.Sprint(1000+feed_cursors_seen_ids_size_))) {
+		t.Error("newest id was forgotten")
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"path"
+	"sync"
+
+	mastodon "github.com/mattn/go-mastodon"
+)
+
+/// Notices the feed wrote into the controlling room, so that replying to a notice replies to the status it is about
+/// or answers the follow request it is about.
+/// They are kept apart from what users posted, so the many notices do not push users' posts out of memory.
+/// If persistence is enabled, they are appended to a log in state_dir, which is rewritten with only the
+/// remembered notices at startup and whenever it has grown to more than twice that.
+
+const (
+	feed_notices_size_         int = 1000
+	feed_notices_log_filename_     = "feednotices.log"
+)
+
+type FeedNotice struct {
+	TootID    mastodon.ID `json:",omitempty"`
+	AccountID mastodon.ID `json:",omitempty"` // asking to follow us, if this notice is about a follow request
+}
+
+// one line in the log of feed notices
+type feedNoticeLogEntry struct {
+	EventID string     `json:"e"`
+	Notice  FeedNotice `json:"n"`
+}
+
+var (
+	feed_notices_           = make(map[string]FeedNotice)
+	feed_notices_order_     []string // event IDs, oldest first
+	feed_notices_log_       *os.File
+	feed_notices_log_lines_ int
+	feed_notices_lock_      sync.Mutex
+)
+
+// read the notices remembered before a restart, if persistence is enabled
+func loadFeedNotices() {
+	if len(persistence_state_dir_) == 0 {
+		return
+	}
+	logpath := path.Join(persistence_state_dir_, feed_notices_log_filename_)
+	feed_notices_lock_.Lock()
+	defer feed_notices_lock_.Unlock()
+	if fh, err := os.Open(logpath); err == nil {
+		scanner := bufio.NewScanner(fh)
+		for scanner.Scan() {
+			var entry feedNoticeLogEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				log.Println("loadFeedNotices: skipping corrupt log line:", err)
+				continue
+			}
+			addFeedNotice(entry.EventID, entry.Notice)
+		}
+		fh.Close()
+	} else if !os.IsNotExist(err) {
+		log.Println("loadFeedNotices:", err)
+	}
+	compactFeedNoticesLog(logpath)
+}
+
+// must be called with feed_notices_lock_ held
+func compactFeedNoticesLog(logpath string) {
+	if feed_notices_log_ != nil {
+		feed_notices_log_.Close()
+		feed_notices_log_ = nil
+	}
+	tmplogpath := logpath + ".tmp"
+	fh, err := os.OpenFile(tmplogpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err == nil {
+		enc := json.NewEncoder(fh)
+		for _, eventid := range feed_notices_order_ {
+			if err = enc.Encode(feedNoticeLogEntry{EventID: eventid, Notice: feed_notices_[eventid]}); err != nil {
+				break
+			}
+		}
+		if closeerr := fh.Close(); err == nil {
+			err = closeerr
+		}
+		if err == nil {
+			err = os.Rename(tmplogpath, logpath)
+		}
+	}
+	if err != nil {
+		log.Println("compactFeedNoticesLog: could not compact log:", err)
+		os.Remove(tmplogpath)
+	} else {
+		feed_notices_log_lines_ = len(feed_notices_order_)
+	}
+	if feed_notices_log_, err = os.OpenFile(logpath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600); err != nil {
+		log.Println("compactFeedNoticesLog: can not append to log, continuing without persistence:", err)
+		feed_notices_log_ = nil
+	}
+}
+
+// must be called with feed_notices_lock_ held
+func addFeedNotice(eventid string, notice FeedNotice) {
+	if _, known := feed_notices_[eventid]; !known {
+		feed_notices_order_ = append(feed_notices_order_, eventid)
+	}
+	feed_notices_[eventid] = notice
+	if len(feed_notices_order_) > feed_notices_size_ {
+		num_forget := len(feed_notices_order_) - feed_notices_size_
+		for _, forget := range feed_notices_order_[:num_forget] {
+			delete(feed_notices_, forget)
+		}
+		feed_notices_order_ = append([]string(nil), feed_notices_order_[num_forget:]...)
+	}
+}
+
+func rememberFeedNotice(eventid string, notice FeedNotice) {
+	feed_notices_lock_.Lock()
+	defer feed_notices_lock_.Unlock()
+	addFeedNotice(eventid, notice)
+	if feed_notices_log_ == nil {
+		return
+	}
+	line, err := json.Marshal(feedNoticeLogEntry{EventID: eventid, Notice: notice})
+	if err == nil {
+		_, err = feed_notices_log_.Write(append(line, '\n'))
+	}
+	if err != nil {
+		log.Println("rememberFeedNotice:", err)
+		return
+	}
+	feed_notices_log_lines_++
+	if feed_notices_log_lines_ > 2*feed_notices_size_ {
+		compactFeedNoticesLog(feed_notices_log_.Name())
+	}
+}
+
+// the status or follow request a notice with the given event ID is about
+func lookupFeedNotice(eventid string) (FeedNotice, bool) {
+	feed_notices_lock_.Lock()
+	defer feed_notices_lock_.Unlock()
+	notice, ok := feed_notices_[eventid]
+	return notice, ok
+}
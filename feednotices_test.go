@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	mastodon "github.com/mattn/go-mastodon"
+)
+
+func forgetFeedNoticesInMemory() {
+	if feed_notices_log_ != nil {
+		feed_notices_log_.Close()
+		feed_notices_log_ = nil
+	}
+	feed_notices_ = make(map[string]FeedNotice)
+	feed_notices_order_ = nil
+	feed_notices_log_lines_ = 0
+}
+
+func TestFeedNoticesForgetOldestNotices(t *testing.T) {
+	t.Cleanup(forgetFeedNoticesInMemory)
+	rememberFeedNotice("$first", FeedNotice{TootID: "1"})
+	if notice, ok := lookupFeedNotice("$first"); !ok || notice.TootID != "1" {
+		t.Fatalf("notice not remembered: %+v %v", notice, ok)
+	}
+	for i := 0; i < feed_notices_size_; i++ {
+		rememberFeedNotice(fmt.Sprintf("$notice%d", i), FeedNotice{TootID: mastodon.ID(fmt.Sprint(i))})
+	}
+	if _, ok := lookupFeedNotice("$first"); ok {
+		t.Error("oldest notice was not forgotten")
+	}
+	if notice, ok := lookupFeedNotice("$notice0"); !ok || notice.TootID != "0" {
+		t.Errorf("newer notice was forgotten: %+v %v", notice, ok)
+	}
+}
+
+func TestFeedNoticesSurviveRestart(t *testing.T) {
+	persistence_state_dir_ = t.TempDir()
+	defer func() { persistence_state_dir_ = "" }()
+	t.Cleanup(forgetFeedNoticesInMemory)
+
+	loadFeedNotices()
+	rememberFeedNotice("$followrequest", FeedNotice{AccountID: "42"})
+	// enough to compact the log while running
+	for i := 0; i < 2*feed_notices_size_; i++ {
+		rememberFeedNotice(fmt.Sprintf("$notice%d", i), FeedNotice{TootID: mastodon.ID(fmt.Sprint(i))})
+	}
+	if feed_notices_log_lines_ > 2*feed_notices_size_ {
+		t.Errorf("log was not compacted, has %d lines", feed_notices_log_lines_)
+	}
+
+	rememberFeedNotice("$laterfollowrequest", FeedNotice{AccountID: "43"})
+
+	forgetFeedNoticesInMemory()
+	loadFeedNotices()
+	if notice, ok := lookupFeedNotice("$laterfollowrequest"); !ok || notice.AccountID != "43" {
+		t.Errorf("follow request notice not loaded after restart: %+v %v", notice, ok)
+	}
+	if _, ok := lookupFeedNotice("$followrequest"); ok {
+		t.Error("notice forgotten before the restart was loaded again")
+	}
+	last := 2*feed_notices_size_ - 1
+	if notice, ok := lookupFeedNotice(fmt.Sprintf("$notice%d", last)); !ok || notice.TootID != mastodon.ID(fmt.Sprint(last)) {
+		t.Errorf("notice not loaded after restart: %+v %v", notice, ok)
+	}
+	if len(feed_notices_order_) != feed_notices_size_ || feed_notices_log_lines_ != feed_notices_size_ {
+		t.Errorf("expected %d notices, got %d in memory and %d in the log", feed_notices_size_, len(feed_notices_order_), feed_notices_log_lines_)
+	}
+}
@@ -25,17 +25,11 @@ import (
 const uploadfile_type_media_ = "media" //doesn't really need to contain
 const uploadfile_type_desc_ = "txt"
 
-// check a size againt known limits, depending on which social media services are enabled
-func checkImageBytesizeLimit(size int64) error {
-	var max_image_bytes int64 = 10 * 1024 * 1024
-	if c["server"]["twitter"] == "true" && size > imgbytes_limit_twitter_ {
-		return fmt.Errorf("Image too large for Twitter. Please shrink to below %d bytes", imgbytes_limit_twitter_)
-	}
-	if c["server"]["mastodon"] == "true" && size > imgbytes_limit_mastodon_ {
-		return fmt.Errorf("Image too large for Mastodon. Please shrink to below %d bytes", imgbytes_limit_mastodon_)
-	}
-	if size > max_image_bytes {
-		return fmt.Errorf("Image is too large. Please shrink to below %d bytes", max_image_bytes)
+// check the size of an image before download. Images that are too large for the enabled
+// social media services are shrunk afterwards, see fitImageFileIntoLimits
+func checkImageDownloadBytesizeLimit(size int64) error {
+	if size > imgbytes_download_limit_ {
+		return fmt.Errorf("Image is too large. Please shrink to below %d bytes", imgbytes_download_limit_)
 	}
 	return nil
 }
@@ -68,25 +62,45 @@ func osGetLimitedNumElementsInDir(directory string) (int, error) {
 	return len(fileInfo), nil
 }
 
-// Return list of media files, currently uploaded and prepapred for posting, for a matrix-user
+// Return list of media files, currently uploaded and prepapred for posting, for a matrix-user.
+// Files are in the order of the staging manifest, files missing from it come last, oldest first.
 // returns at most (feed2matrx_image_count_limit_) list entries
 func getUserFileList(nick string) ([]string, error) {
 	usermediadir := path.Join(hashNickToUserDir(nick), uploadfile_type_media_)
-	f, err := os.Open(usermediadir)
+	files, err := ioutil.ReadDir(usermediadir)
 	if os.IsNotExist(err) {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, err
 	}
-	names, err := f.Readdirnames(feed2matrx_image_count_limit_)
-	f.Close()
-	if err != nil && err != io.EOF {
-		return nil, err
+	position := make(map[string]int)
+	if manifest, err := loadStagingManifestFile(getStagingManifestPath(nick)); err == nil {
+		for idx, entry := range manifest.Entries {
+			_, mediapath := hashNickAndTypeAndEventIdToPath(nick, uploadfile_type_media_, entry.EventID)
+			position[path.Base(mediapath)] = idx
+		}
 	}
-	fullnames := make([]string, len(names))
-	for idx, filename := range names {
-		fullnames[idx] = path.Join(usermediadir, filename)
+	sort.SliceStable(files, func(i, j int) bool {
+		posi, inmanifesti := position[files[i].Name()]
+		posj, inmanifestj := position[files[j].Name()]
+		if inmanifesti != inmanifestj {
+			return inmanifesti
+		}
+		if inmanifesti {
+			return posi < posj
+		}
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+	fullnames := make([]string, 0, len(files))
+	for _, fileinfo := range files {
+		if strings.HasSuffix(fileinfo.Name(), ".tmp") {
+			continue // still being written
+		}
+		if len(fullnames) >= feed2matrx_image_count_limit_ {
+			break
+		}
+		fullnames = append(fullnames, path.Join(usermediadir, fileinfo.Name()))
 	}
 	return fullnames, nil
 }
@@ -131,7 +145,9 @@ func getUserFilelistSortedByMtime(nick, filetype string) (sorted_filepaths []str
 	return sorted_filepaths, nil
 }
 
-func saveMatrixFile(cli *gomatrix.Client, nick, eventid, matrixurl string) error {
+// download media to the staging directory of nick. mimetype and duration are taken from the matrix event, if given.
+// checksize checks the size of the file against limits for its type of media
+func saveMatrixFile(cli *gomatrix.Client, nick, eventid, matrixurl, mimetype string, duration time.Duration, checksize func(int64) error) error {
 	if !strings.Contains(matrixurl, "mxc://") {
 		return fmt.Errorf("image url not a matrix content mxc://..  uri")
 	}
@@ -185,7 +201,7 @@ func saveMatrixFile(cli *gomatrix.Client, nick, eventid, matrixurl string) error
 	}
 
 	// Check Filesize (again)
-	if err = checkImageBytesizeLimit(resp.ContentLength); err != nil {
+	if err = checksize(resp.ContentLength); err != nil {
 		os.Remove(imgtmpfilepath) //remove before close will work on unix/bsd. Not sure about windows, but meh.
 		return err
 	}
@@ -198,7 +214,7 @@ func saveMatrixFile(cli *gomatrix.Client, nick, eventid, matrixurl string) error
 	}
 
 	// Check Filesize (again)
-	if err = checkImageBytesizeLimit(bytes_written); err != nil {
+	if err = checksize(bytes_written); err != nil {
 		if resp.ContentLength > 0 {
 			log.Printf("Content-Length lied to us != bytes_written: %d != %d", resp.ContentLength, bytes_written)
 		}
@@ -207,8 +223,13 @@ func saveMatrixFile(cli *gomatrix.Client, nick, eventid, matrixurl string) error
 	}
 
 	//NOTE: FIXME: close has not been called, at atomic rename time, file may not have been fully written. This is mostly fine, since fh can still be written too since only filename changed, but if we were to interact with other processes, which we don't, this would be a race condition
-	os.Rename(imgtmpfilepath, imgfilepath)
-	return nil
+	if err = os.Rename(imgtmpfilepath, imgfilepath); err != nil {
+		return err
+	}
+	if len(mimetype) == 0 {
+		mimetype = resp.Header.Get("Content-Type")
+	}
+	return addStagedMediaToManifest(nick, eventid, mimetype, duration)
 }
 
 func saveMediaFileDescription(nick, eventid_of_related_img, description string) error {
@@ -218,12 +239,9 @@ func saveMediaFileDescription(nick, eventid_of_related_img, description string)
 		return fmt.Errorf("corresponding media file does not exist")
 	}
 
-	filesdir, descfilepath := hashNickAndTypeAndEventIdToPath(nick, uploadfile_type_desc_, eventid_of_related_img)
-	os.MkdirAll(filesdir, 0700)
-	desctmpfilepath := descfilepath + ".tmp"
-
+	filesdir, _ := hashNickAndTypeAndEventIdToPath(nick, uploadfile_type_desc_, eventid_of_related_img)
 	numfiles, err := osGetLimitedNumElementsInDir(filesdir)
-	if err != nil {
+	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
 	/// limit number of files per user
@@ -231,8 +249,23 @@ func saveMediaFileDescription(nick, eventid_of_related_img, description string)
 		return fmt.Errorf("Too many files stored. %d is the limit.", feed2matrx_image_count_limit_)
 	}
 
+	if err = saveMediaFileDescriptionFile(nick, eventid_of_related_img, description); err != nil {
+		return err
+	}
+	if err = setStagedMediaDescriptionInManifest(nick, eventid_of_related_img, description); err != nil {
+		log.Println("saveMediaFileDescription: could not update manifest:", err)
+	}
+	return nil
+}
+
+// write description file, without any checks
+func saveMediaFileDescriptionFile(nick, eventid_of_related_img, description string) error {
+	filesdir, descfilepath := hashNickAndTypeAndEventIdToPath(nick, uploadfile_type_desc_, eventid_of_related_img)
+	os.MkdirAll(filesdir, 0700)
+	desctmpfilepath := descfilepath + ".tmp"
+
 	/// Create the file (implies truncate)
-	fh, err := os.OpenFile(desctmpfilepath, os.O_WRONLY|os.O_CREATE, 0400)
+	fh, err := os.OpenFile(desctmpfilepath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return err
 	}
@@ -241,19 +274,22 @@ func saveMediaFileDescription(nick, eventid_of_related_img, description string)
 	fh.Write([]byte(description))
 	fh.Close()
 
-	os.Rename(desctmpfilepath, descfilepath)
-	return nil
+	return os.Rename(desctmpfilepath, descfilepath)
 }
 
 func getDescriptionFilenameOfMediaFilename(imgfilepath string) (string, error) {
+	return getRelatedFilenameOfMediaFilename(imgfilepath, uploadfile_type_desc_)
+}
+
+// path of the file of filetype (description, focus) that belongs to a media file
+func getRelatedFilenameOfMediaFilename(imgfilepath, filetype string) (string, error) {
 	filename := path.Base(imgfilepath)
 	usermediadir := path.Dir(imgfilepath)          //Dir() returns directory without trailing '/'. Important for Split in next statement
 	userdir, mediatype := path.Split(usermediadir) //split returns ("dir1/dir2/","dir3"). Using multiple Split's to segment a path is a bad idea.
 	if mediatype != uploadfile_type_media_ {
 		return "", fmt.Errorf("unknown imgfilepath given")
 	}
-	descfile := path.Join(userdir, uploadfile_type_desc_, filename)
-	return descfile, nil
+	return path.Join(userdir, filetype, filename), nil
 }
 
 func readDescriptionOfMediaFile(imgfilepath string) (string, error) {
@@ -278,9 +314,13 @@ func addMediaFileDescriptionToLastMediaUpload(nick, description string) error {
 		return fmt.Errorf("no media files have been uploaded")
 	}
 	descfile, err := getDescriptionFilenameOfMediaFilename(sorted_media[0])
+	if err != nil {
+		return err
+	}
+	os.MkdirAll(path.Dir(descfile), 0700)
 
 	/// Create the file (implies truncate)
-	fh, err := os.OpenFile(descfile, os.O_WRONLY|os.O_CREATE, 0400)
+	fh, err := os.OpenFile(descfile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return err
 	}
@@ -288,6 +328,15 @@ func addMediaFileDescriptionToLastMediaUpload(nick, description string) error {
 	/// Save description
 	fh.Write([]byte(description))
 	fh.Close()
+
+	err = modifyStagingManifest(nick, func(m *StagedMediaManifest) {
+		if idx := m.findByMediaPath(sorted_media[0]); idx >= 0 {
+			m.Entries[idx].Description = description
+		}
+	})
+	if err != nil {
+		log.Println("addMediaFileDescriptionToLastMediaUpload: could not update manifest:", err)
+	}
 	return nil
 }
 
@@ -295,8 +344,14 @@ func rmFile(nick, eventid string) error {
 	// log.Println("removing file for", nick)
 	_, fpath := hashNickAndTypeAndEventIdToPath(nick, uploadfile_type_desc_, eventid)
 	os.Remove(fpath)
+	_, fpath = hashNickAndTypeAndEventIdToPath(nick, uploadfile_type_focus_, eventid)
+	os.Remove(fpath)
 	_, fpath = hashNickAndTypeAndEventIdToPath(nick, uploadfile_type_media_, eventid)
-	return os.Remove(fpath)
+	err := os.Remove(fpath)
+	if err == nil {
+		removeStagedMediaFromManifest(nick, eventid)
+	}
+	return err
 }
 
 func rmAllUserFiles(nick string) error {
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	mastodon "github.com/mattn/go-mastodon"
+)
+
+/// Follow requests to our locked account are answered by replying to or reacting on their notice in the matrix room,
+/// or with the followrequests command, which also lists the pending ones.
+
+// ends the notice about a follow request
+const follow_request_notice_text_ = "would like to follow you!"
+
+var (
+	follow_request_accept_answers_ = []string{"accept", "approve", "yes", "ok", "👍", "✅", "✔"}
+	follow_request_reject_answers_ = []string{"reject", "deny", "no", "👎", "❌", "✖"}
+)
+
+// whether answer, a reply or the key of a reaction, accepts or rejects a follow request
+func parseFollowRequestAnswer(answer string) (accept bool, isanswer bool) {
+	answer = strings.ToLower(strings.Trim(strings.Replace(answer, "\ufe0f", "", -1), " \t\n!."))
+	for _, word := range follow_request_accept_answers_ {
+		if answer == word {
+			return true, true
+		}
+	}
+	for _, word := range follow_request_reject_answers_ {
+		if answer == word {
+			return false, true
+		}
+	}
+	return false, false
+}
+
+// whether text is that of a notice about a follow request, even one we no longer remember
+func isFollowRequestNoticeText(text string) bool {
+	return strings.HasSuffix(text, follow_request_notice_text_)
+}
+
+func answerFollowRequest(client *mastodon.Client, accountid mastodon.ID, accept bool) error {
+	if accept {
+		return client.FollowRequestAuthorize(context.Background(), accountid)
+	}
+	return client.FollowRequestReject(context.Background(), accountid)
+}
+
+func formatFollowRequestAnswer(accept bool, who string) string {
+	if accept {
+		return fmt.Sprintf("Ok, %s may follow you now", who)
+	}
+	return fmt.Sprintf("Ok, I rejected the follow request of %s", who)
+}
+
+func getPendingFollowRequests(client *mastodon.Client) ([]*mastodon.Account, error) {
+	return client.GetFollowRequests(context.Background(), &mastodon.Pagination{Limit: 80})
+}
+
+func formatFollowRequestList(accounts []*mastodon.Account) string {
+	if len(accounts) == 0 {
+		return "no pending follow requests"
+	}
+	lines := make([]string, len(accounts))
+	for idx, account := range accounts {
+		lines[idx] = fmt.Sprintf("%d. %s (@%s) %s", idx+1, account.DisplayName, fullAcct(account.Acct), account.URL)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// the pending request given as position in the list or as @user@instance
+func findFollowRequest(accounts []*mastodon.Account, arg string) (*mastodon.Account, error) {
+	if idx, err := parseMediaPosition(arg, len(accounts)); err == nil {
+		return accounts[idx], nil
+	}
+	if _, acct, err := parseAccountArg(arg); err == nil {
+		for _, account := range accounts {
+			if accountHasAcct(account, acct) {
+				return account, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("'%s' is neither a position in the list of %d pending follow requests nor one of them", arg, len(accounts))
+}
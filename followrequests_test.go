@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+
+	mastodon "github.com/mattn/go-mastodon"
+)
+
+func TestParseFollowRequestAnswer(t *testing.T) {
+	for _, tc := range []struct {
+		answer   string
+		accept   bool
+		isanswer bool
+	}{
+		{"accept", true, true},
+		{" Yes!", true, true},
+		{"👍", true, true},
+		{"✔️", true, true},
+		{"reject", false, true},
+		{"No.", false, true},
+		{"👎", false, true},
+		{"❌", false, true},
+		{"maybe later", false, false},
+		{"😀", false, false},
+	} {
+		if accept, isanswer := parseFollowRequestAnswer(tc.answer); accept != tc.accept || isanswer != tc.isanswer {
+			t.Errorf("parseFollowRequestAnswer(%q) = %v, %v; want %v, %v", tc.answer, accept, isanswer, tc.accept, tc.isanswer)
+		}
+	}
+}
+
+func TestIsFollowRequestNoticeText(t *testing.T) {
+	account := mastodon.Account{Username: "alice", Acct: "alice@example.org"}
+	followrequest, _ := formatNotificationForMatrix(&mastodon.Notification{Type: "follow_request", Account: account})
+	if !isFollowRequestNoticeText(followrequest) {
+		t.Errorf("notice about a follow request not recognised: %q", followrequest)
+	}
+	follow, _ := formatNotificationForMatrix(&mastodon.Notification{Type: "follow", Account: account})
+	if isFollowRequestNoticeText(follow) {
+		t.Errorf("notice about a follow taken for one about a follow request: %q", follow)
+	}
+}
+
+func TestFindFollowRequest(t *testing.T) {
+	useTestMastodonServer(t)
+	accounts := []*mastodon.Account{{ID: "1", Acct: "alice@example.org"}, {ID: "2", Acct: "bob"}}
+	for _, tc := range []struct {
+		arg string
+		id  mastodon.ID
+	}{
+		{"1", "1"},
+		{"#2", "2"},
+		{"@alice@example.org", "1"},
+		{"bob@chaos.social", "2"},
+		{"@bob", "2"},
+	} {
+		if account, err := findFollowRequest(accounts, tc.arg); err != nil || account.ID != tc.id {
+			t.Errorf("findFollowRequest(%q) = %v, %v; want %s", tc.arg, account, err, tc.id)
+		}
+	}
+	for _, arg := range []string{"3", "@alice", "@carol@example.org"} {
+		if _, err := findFollowRequest(accounts, arg); err == nil {
+			t.Errorf("%q is not a pending follow request", arg)
+		}
+	}
+}
+
+func TestFormatFollowRequestList(t *testing.T) {
+	useTestMastodonServer(t)
+	if list := formatFollowRequestList(nil); list != "no pending follow requests" {
+		t.Errorf("unexpected %q", list)
+	}
+	list := formatFollowRequestList([]*mastodon.Account{{DisplayName: "Bob", Acct: "bob", URL: "https://chaos.social/@bob"}})
+	if list != "1. Bob (@bob@chaos.social) https://chaos.social/@bob" {
+		t.Errorf("unexpected %q", list)
+	}
+}
@@ -71,6 +71,26 @@ func formatStatusForMatrix(status *mastodon.Status) (body, htmlbody string) {
 	return
 }
 
+// list options of a poll together with their votes
+func formatPollResultsForMatrix(poll *mastodon.Poll) (body, htmlbody string) {
+	if poll == nil {
+		return
+	}
+	htmlbody = "<ul>"
+	for _, option := range poll.Options {
+		percent := 0.0
+		if poll.VotesCount > 0 {
+			percent = 100.0 * float64(option.VotesCount) / float64(poll.VotesCount)
+		}
+		body += fmt.Sprintf("\n- %s: %d votes (%.0f%%)", option.Title, option.VotesCount, percent)
+		htmlbody += fmt.Sprintf("<li>%s: <strong>%d</strong> votes (%.0f%%)</li>", html.EscapeString(option.Title), option.VotesCount, percent)
+	}
+	htmlbody += "</ul>"
+	body += fmt.Sprintf("\n%d votes by %d voters", poll.VotesCount, poll.VotersCount)
+	htmlbody += fmt.Sprintf("%d votes by %d voters", poll.VotesCount, poll.VotersCount)
+	return
+}
+
 func formatNotificationForMatrix(notification *mastodon.Notification) (body, htmlbody string) {
 	sender, handle := formatUserNameForMatrix(notification.Account)
 	var content_text string
@@ -98,11 +118,15 @@ func formatNotificationForMatrix(notification *mastodon.Notification) (body, htm
 		body = fmt.Sprintf("%s (%s) is following you now", sender, handle)
 		htmlbody = fmt.Sprintf("<strong>%s</strong> (%s) is following you now", sender, handle)
 	case "follow_request":
-		body = fmt.Sprintf("%s (%s) would like to follow you!", sender, handle)
-		htmlbody = fmt.Sprintf("<strong>%s</strong> (%s) would like to follow you!", sender, handle)
+		body = fmt.Sprintf("%s (%s) %s", sender, handle, follow_request_notice_text_)
+		htmlbody = fmt.Sprintf("<strong>%s</strong> (%s) %s", sender, handle, follow_request_notice_text_)
 	case "poll":
-		body = fmt.Sprintf("the result of %s's poll is in: %s%s", sender, url, foreignreplyhint_text)
-		htmlbody = fmt.Sprintf("the result of <strong>%s</strong>'s poll is in: <a href=\"%s\">%s</a>%s", sender, url, url, foreignreplyhint_html)
+		var results_text, results_html string
+		if notification.Status != nil {
+			results_text, results_html = formatPollResultsForMatrix(notification.Status.Poll)
+		}
+		body = fmt.Sprintf("the result of %s's poll is in: %s%s%s", sender, url, results_text, foreignreplyhint_text)
+		htmlbody = fmt.Sprintf("the result of <strong>%s</strong>'s poll is in: <a href=\"%s\">%s</a>%s%s", sender, url, url, results_html, foreignreplyhint_html)
 	default:
 		body = fmt.Sprintf("received unsupported notification of type %s from %s (%s)%s", notification.Type, sender, handle, foreignreplyhint_text)
 		htmlbody = fmt.Sprintf("received unsupported notification of type %s from %s (%s)%s", notification.Type, sender, handle, foreignreplyhint_html)
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+)
+
+/// Photos from phones carry EXIF, XMP and IPTC metadata, often including the location they were taken at.
+/// We remove it from staged images unless [images]keep_metadata=true.
+/// Since viewers rotate photos according to their EXIF orientation, the orientation is applied to the pixels first.
+
+const (
+	exif_tag_orientation_        uint16 = 0x0112
+	exif_tag_gps_ifd_            uint16 = 0x8825
+	image_reencode_jpeg_quality_        = 92
+)
+
+// what we found in the metadata of an image
+type ImageMetadataInfo struct {
+	Orientation int // 1 to 8 as in EXIF, 0 if not given
+	HasLocation bool
+}
+
+// parse the TIFF structure of an EXIF block for orientation and location
+func parseExif(tiff []byte, info *ImageMetadataInfo) {
+	if len(tiff) < 8 {
+		return
+	}
+	var order binary.ByteOrder
+	switch string(tiff[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return
+	}
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return
+	}
+	numentries := int(order.Uint16(tiff[ifd : ifd+2]))
+	for i := 0; i < numentries; i++ {
+		entry := ifd + 2 + 12*i
+		if entry+12 > len(tiff) {
+			return
+		}
+		switch order.Uint16(tiff[entry : entry+2]) {
+		case exif_tag_orientation_:
+			info.Orientation = int(order.Uint16(tiff[entry+8 : entry+10]))
+		case exif_tag_gps_ifd_:
+			info.HasLocation = true
+		}
+	}
+}
+
+// XMP is text and names location properties like exif:GPSLatitude
+func xmpHasLocation(xmp []byte) bool {
+	return bytes.Contains(xmp, []byte("GPSLatitude")) || bytes.Contains(xmp, []byte("GPSLongitude"))
+}
+
+// remove APP1 (EXIF, XMP) and APP13 (IPTC) segments from a JPEG. Other segments like the ICC color profile are kept
+func stripJpegMetadata(contents []byte) (result []byte, info ImageMetadataInfo, err error) {
+	if len(contents) < 4 || contents[0] != 0xFF || contents[1] != 0xD8 {
+		return nil, info, fmt.Errorf("not a jpeg")
+	}
+	result = append(make([]byte, 0, len(contents)), contents[0:2]...)
+	pos := 2
+	for pos+4 <= len(contents) {
+		if contents[pos] != 0xFF {
+			return nil, info, fmt.Errorf("broken jpeg")
+		}
+		marker := contents[pos+1]
+		if marker == 0xDA { // start of scan, image data follows
+			break
+		}
+		seglen := int(binary.BigEndian.Uint16(contents[pos+2 : pos+4]))
+		if seglen < 2 || pos+2+seglen > len(contents) {
+			return nil, info, fmt.Errorf("broken jpeg")
+		}
+		payload := contents[pos+4 : pos+2+seglen]
+		switch marker {
+		case 0xE1:
+			if bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+				parseExif(payload[6:], &info)
+			} else if xmpHasLocation(payload) {
+				info.HasLocation = true
+			}
+		case 0xED:
+		default:
+			result = append(result, contents[pos:pos+2+seglen]...)
+		}
+		pos += 2 + seglen
+	}
+	return append(result, contents[pos:]...), info, nil
+}
+
+// remove text, time and EXIF chunks from a PNG
+func stripPngMetadata(contents []byte) (result []byte, info ImageMetadataInfo, err error) {
+	const pngheader = "\x89PNG\r\n\x1a\n"
+	if !bytes.HasPrefix(contents, []byte(pngheader)) {
+		return nil, info, fmt.Errorf("not a png")
+	}
+	result = append(make([]byte, 0, len(contents)), pngheader...)
+	pos := len(pngheader)
+	for pos+12 <= len(contents) {
+		chunklen := int(binary.BigEndian.Uint32(contents[pos : pos+4]))
+		chunktype := string(contents[pos+4 : pos+8])
+		end := pos + 12 + chunklen
+		if chunklen < 0 || end > len(contents) {
+			return nil, info, fmt.Errorf("broken png")
+		}
+		data := contents[pos+8 : pos+8+chunklen]
+		switch chunktype {
+		case "eXIf":
+			parseExif(data, &info)
+		case "tEXt", "zTXt", "iTXt", "tIME":
+			if xmpHasLocation(data) {
+				info.HasLocation = true
+			}
+		default:
+			result = append(result, contents[pos:end]...)
+		}
+		pos = end
+	}
+	return result, info, nil
+}
+
+// remove EXIF and XMP chunks from a WebP and unset their flags in the extended header
+func stripWebPMetadata(contents []byte) (result []byte, info ImageMetadataInfo, err error) {
+	if !isWebP(contents) {
+		return nil, info, fmt.Errorf("not a webp")
+	}
+	result = append(make([]byte, 0, len(contents)), contents[0:12]...)
+	pos := 12
+	for pos+8 <= len(contents) {
+		chunktype := string(contents[pos : pos+4])
+		chunklen := int(binary.LittleEndian.Uint32(contents[pos+4 : pos+8]))
+		end := pos + 8 + chunklen + chunklen%2 // chunks are padded to even length
+		if chunklen < 0 || end > len(contents) {
+			return nil, info, fmt.Errorf("broken webp")
+		}
+		switch chunktype {
+		case "EXIF":
+			parseExif(contents[pos+8:pos+8+chunklen], &info)
+		case "XMP ":
+			if xmpHasLocation(contents[pos+8 : pos+8+chunklen]) {
+				info.HasLocation = true
+			}
+		case "VP8X":
+			chunk := append([]byte{}, contents[pos:end]...)
+			if len(chunk) > 8 {
+				chunk[8] &^= 0x08 | 0x04 // EXIF and XMP flags
+			}
+			result = append(result, chunk...)
+		default:
+			result = append(result, contents[pos:end]...)
+		}
+		pos = end
+	}
+	binary.LittleEndian.PutUint32(result[4:8], uint32(len(result)-8))
+	return result, info, nil
+}
+
+// turn img the way a viewer would for an EXIF orientation
+func applyExifOrientation(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	bounds := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+	w, h := bounds.Dx(), bounds.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // mirrored
+				sx, sy = w-1-x, y
+			case 3: // upside down
+				sx, sy = w-1-x, h-1-y
+			case 4: // mirrored upside down
+				sx, sy = x, h-1-y
+			case 5: // transposed
+				sx, sy = y, x
+			case 6: // needs to be turned clockwise
+				sx, sy = y, h-1-x
+			case 7: // transversed
+				sx, sy = w-1-y, h-1-x
+			case 8: // needs to be turned counterclockwise
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[y*dst.Stride+x*4:y*dst.Stride+x*4+4], src.Pix[sy*src.Stride+sx*4:sy*src.Stride+sx*4+4])
+		}
+	}
+	return dst
+}
+
+// remove metadata from an image. If it had to be rotated according to its orientation, it is re-encoded.
+// Formats we do not know are returned unchanged.
+func stripImageMetadata(contents []byte) (result []byte, info ImageMetadataInfo, err error) {
+	switch {
+	case bytes.HasPrefix(contents, []byte{0xFF, 0xD8}):
+		result, info, err = stripJpegMetadata(contents)
+	case bytes.HasPrefix(contents, []byte("\x89PNG")):
+		result, info, err = stripPngMetadata(contents)
+	case isWebP(contents):
+		// we can not decode webp, so its orientation can not be applied and is dropped with the rest
+		result, info, err = stripWebPMetadata(contents)
+		return
+	default:
+		return contents, info, nil
+	}
+	if err != nil || info.Orientation < 2 {
+		return
+	}
+	img, format, err := image.Decode(bytes.NewReader(contents))
+	if err != nil {
+		return nil, info, err
+	}
+	var buf bytes.Buffer
+	if format == "png" {
+		err = png.Encode(&buf, applyExifOrientation(img, info.Orientation))
+	} else {
+		err = jpeg.Encode(&buf, applyExifOrientation(img, info.Orientation), &jpeg.Options{Quality: image_reencode_jpeg_quality_})
+	}
+	return buf.Bytes(), info, err
+}
+
+// strip metadata of a staged image file in place
+func stripImageFileMetadata(imgfilepath string) (ImageMetadataInfo, error) {
+	contents, err := ioutil.ReadFile(imgfilepath)
+	if err != nil {
+		return ImageMetadataInfo{}, err
+	}
+	result, info, err := stripImageMetadata(contents)
+	if err != nil || bytes.Equal(result, contents) {
+		return info, err
+	}
+	return info, replaceFileContents(imgfilepath, result)
+}
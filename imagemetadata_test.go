@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// little endian TIFF with orientation and a GPS IFD pointer in IFD0
+func makeTestExif(orientation uint16) []byte {
+	tiff := []byte("II*\x00\x08\x00\x00\x00")
+	tiff = binary.LittleEndian.AppendUint16(tiff, 2)
+	for _, tag := range []struct {
+		tag, typ uint16
+		value    uint32
+	}{{exif_tag_orientation_, 3, uint32(orientation)}, {exif_tag_gps_ifd_, 4, 0}} {
+		tiff = binary.LittleEndian.AppendUint16(tiff, tag.tag)
+		tiff = binary.LittleEndian.AppendUint16(tiff, tag.typ)
+		tiff = binary.LittleEndian.AppendUint32(tiff, 1)
+		tiff = binary.LittleEndian.AppendUint32(tiff, tag.value)
+	}
+	return binary.LittleEndian.AppendUint32(tiff, 0)
+}
+
+func makeTestJpegWithExif(t *testing.T, orientation uint16) []byte {
+	// left column red, rest white
+	img := image.NewRGBA(image.Rect(0, 0, 32, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 32; x++ {
+			img.Set(x, y, color.White)
+			if x < 8 {
+				img.Set(x, y, color.RGBA{255, 0, 0, 255})
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	app1 := append([]byte("Exif\x00\x00"), makeTestExif(orientation)...)
+	segment := append([]byte{0xFF, 0xE1}, binary.BigEndian.AppendUint16(nil, uint16(len(app1)+2))...)
+	contents := append([]byte{0xFF, 0xD8}, segment...)
+	contents = append(contents, app1...)
+	return append(contents, buf.Bytes()[2:]...)
+}
+
+func TestStripJpegMetadata(t *testing.T) {
+	result, info, err := stripImageMetadata(makeTestJpegWithExif(t, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.HasLocation || info.Orientation != 1 {
+		t.Errorf("expected location and orientation 1, got %+v", info)
+	}
+	if bytes.Contains(result, []byte("Exif")) {
+		t.Error("exif was not removed")
+	}
+	if config, err := jpeg.DecodeConfig(bytes.NewReader(result)); err != nil || config.Width != 32 || config.Height != 16 {
+		t.Errorf("stripped jpeg broken: %v %+v", err, config)
+	}
+}
+
+func TestStripJpegMetadataAppliesOrientation(t *testing.T) {
+	result, info, err := stripImageMetadata(makeTestJpegWithExif(t, 6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Orientation != 6 || bytes.Contains(result, []byte("Exif")) {
+		t.Errorf("expected exif with orientation 6 to be removed, got %+v", info)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(result))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != 16 || img.Bounds().Dy() != 32 {
+		t.Fatalf("expected image turned to 16x32, got %v", img.Bounds())
+	}
+	// turned clockwise, the red left column is now on top
+	if r, g, _, _ := img.At(8, 2).RGBA(); r < 0xc000 || g > 0x4000 {
+		t.Error("expected red on top after turning clockwise")
+	}
+	if _, g, _, _ := img.At(8, 30).RGBA(); g < 0xc000 {
+		t.Error("expected white at bottom after turning clockwise")
+	}
+}
+
+func appendTestPngChunk(contents []byte, chunktype string, data []byte) []byte {
+	contents = binary.BigEndian.AppendUint32(contents, uint32(len(data)))
+	chunk := append([]byte(chunktype), data...)
+	contents = append(contents, chunk...)
+	return binary.BigEndian.AppendUint32(contents, crc32.ChecksumIEEE(chunk))
+}
+
+func TestStripPngAndWebPMetadata(t *testing.T) {
+	png := encodeTestPNG(t, 4, 4, false)
+	iend := len(png) - 12
+	withtext := appendTestPngChunk(append([]byte{}, png[:iend]...), "iTXt", []byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00<exif:GPSLatitude>52,30N</exif:GPSLatitude>"))
+	withtext = append(withtext, png[iend:]...)
+	result, info, err := stripImageMetadata(withtext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.HasLocation || !bytes.Equal(result, png) {
+		t.Errorf("expected xmp with location to be removed from png, got %+v", info)
+	}
+
+	exif := makeTestExif(1)
+	webp := []byte("RIFF\x00\x00\x00\x00WEBPVP8X\x0a\x00\x00\x00\x08\x00\x00\x00\x00\x00\x00\x00\x00\x00")
+	webp = append(webp, "EXIF"...)
+	webp = binary.LittleEndian.AppendUint32(webp, uint32(len(exif)))
+	webp = append(webp, exif...)
+	binary.LittleEndian.PutUint32(webp[4:8], uint32(len(webp)-8))
+	result, info, err = stripImageMetadata(webp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.HasLocation || bytes.Contains(result, []byte("EXIF")) || len(result) != 30 {
+		t.Errorf("expected exif to be removed from webp, got %+v %q", info, result)
+	}
+	if result[20] != 0 || binary.LittleEndian.Uint32(result[4:8]) != 22 {
+		t.Errorf("expected flags and riff size to be updated, got %q", result)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"math"
+	"os"
+)
+
+/// Staged images that exceed the limits of enabled networks are downscaled and recompressed,
+/// images that already fit are left untouched
+
+const (
+	imgbytes_download_limit_      int64 = 50 * 1024 * 1024
+	imgdimension_limit_twitter_   int   = 8192
+	image_shrink_max_rounds_      int   = 8
+	image_shrink_scale_per_round_       = 0.75
+)
+
+// qualities to try, best first, before the image is scaled down further
+var image_jpeg_qualities_ = []int{90, 82, 75, 65}
+
+// a single image we will not decode because it is too large
+const imgpixels_decode_limit_ int64 = 100 * 1000 * 1000
+
+// limits of all enabled networks an image has to fit into
+type ImageLimits struct {
+	Bytes     int64
+	Pixels    int64 // width * height
+	Dimension int   // width and height each
+}
+
+func getImageLimits(mastodon_pixel_limit int64) ImageLimits {
+	limits := ImageLimits{Bytes: 10 * 1024 * 1024, Pixels: imgpixels_decode_limit_, Dimension: math.MaxInt32}
+	if c["server"]["twitter"] == "true" {
+		limits.Bytes = imgbytes_limit_twitter_
+		limits.Dimension = imgdimension_limit_twitter_
+	}
+	if c["server"]["mastodon"] == "true" {
+		if imgbytes_limit_mastodon_ < limits.Bytes {
+			limits.Bytes = imgbytes_limit_mastodon_
+		}
+		if mastodon_pixel_limit < limits.Pixels {
+			limits.Pixels = mastodon_pixel_limit
+		}
+	}
+	return limits
+}
+
+func (limits ImageLimits) fitsDimensions(width, height int) bool {
+	return int64(width)*int64(height) <= limits.Pixels && width <= limits.Dimension && height <= limits.Dimension
+}
+
+// largest size with the aspect ratio of width x height that fits into limits
+func (limits ImageLimits) fitDimensions(width, height int) (int, int) {
+	scale := 1.0
+	if pixels := float64(width) * float64(height); pixels > float64(limits.Pixels) {
+		scale = math.Sqrt(float64(limits.Pixels) / pixels)
+	}
+	if maxside := float64(width) * scale; maxside > float64(limits.Dimension) {
+		scale = float64(limits.Dimension) / float64(width)
+	}
+	if maxside := float64(height) * scale; maxside > float64(limits.Dimension) {
+		scale = float64(limits.Dimension) / float64(height)
+	}
+	return scaleDimensions(width, height, scale)
+}
+
+func scaleDimensions(width, height int, scale float64) (int, int) {
+	w, h := int(float64(width)*scale), int(float64(height)*scale)
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	return w, h
+}
+
+func isWebP(contents []byte) bool {
+	return len(contents) >= 12 && string(contents[0:4]) == "RIFF" && string(contents[8:12]) == "WEBP"
+}
+
+// downscale src to width x height by averaging all source pixels that make up a destination pixel
+func downscaleImage(src image.Image, width, height int) *image.RGBA {
+	bounds := src.Bounds()
+	rgba, ok := src.(*image.RGBA)
+	if !ok {
+		rgba = image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+	}
+	srcw, srch := rgba.Bounds().Dx(), rgba.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := y*srch/height, (y+1)*srch/height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0, x1 := x*srcw/width, (x+1)*srcw/width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				row := rgba.Pix[sy*rgba.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r, g, b, a = r+uint64(p[0]), g+uint64(p[1]), b+uint64(p[2]), a+uint64(p[3])
+					n++
+				}
+			}
+			d := dst.Pix[y*dst.Stride+x*4:]
+			d[0], d[1], d[2], d[3] = uint8(r/n), uint8(g/n), uint8(b/n), uint8(a/n)
+		}
+	}
+	return dst
+}
+
+func imageIsOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
+
+// jpeg knows no transparency, so we put transparent images on white
+func flattenImage(img image.Image) image.Image {
+	if imageIsOpaque(img) {
+		return img
+	}
+	flat := image.NewRGBA(img.Bounds())
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+	return flat
+}
+
+// encode img as small as needed: png if it keeps transparency or was png and fits, otherwise jpeg of decreasing quality
+func encodeImageWithinLimit(img image.Image, preferpng bool, bytelimit int64) (contents []byte, mimetype string, ok bool) {
+	var buf bytes.Buffer
+	if preferpng || !imageIsOpaque(img) {
+		if err := png.Encode(&buf, img); err == nil && int64(buf.Len()) <= bytelimit {
+			return buf.Bytes(), "image/png", true
+		}
+	}
+	flat := flattenImage(img)
+	for _, quality := range image_jpeg_qualities_ {
+		buf.Reset()
+		if err := jpeg.Encode(&buf, flat, &jpeg.Options{Quality: quality}); err == nil && int64(buf.Len()) <= bytelimit {
+			return buf.Bytes(), "image/jpeg", true
+		}
+	}
+	return nil, "", false
+}
+
+// shrink contents of an image until it fits into limits.
+// Returns the original contents and an empty report if it already fits.
+func fitImageIntoLimits(contents []byte, limits ImageLimits) (result []byte, mimetype string, report string, err error) {
+	if isWebP(contents) {
+		// we can not decode webp, but mastodon and twitter take it as it is
+		if int64(len(contents)) > limits.Bytes {
+			return nil, "", "", fmt.Errorf("WebP image is larger than %d bytes and can not be shrunk. Please convert it to JPEG or PNG", limits.Bytes)
+		}
+		return contents, "image/webp", "", nil
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(contents))
+	if err != nil {
+		return nil, "", "", fmt.Errorf("unsupported image format, please use JPEG, PNG, GIF or WebP")
+	}
+	mimetype = "image/" + format
+	if int64(len(contents)) <= limits.Bytes && limits.fitsDimensions(config.Width, config.Height) {
+		return contents, mimetype, "", nil
+	}
+	if int64(config.Width)*int64(config.Height) > imgpixels_decode_limit_ {
+		return nil, "", "", fmt.Errorf("image of %dx%d pixels is too large to be shrunk", config.Width, config.Height)
+	}
+	if format == "gif" {
+		if anim, err := gif.DecodeAll(bytes.NewReader(contents)); err == nil && len(anim.Image) > 1 {
+			return nil, "", "", fmt.Errorf("animated GIF is larger than %d bytes and can not be shrunk", limits.Bytes)
+		}
+	}
+	img, _, err := image.Decode(bytes.NewReader(contents))
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	width, height := limits.fitDimensions(config.Width, config.Height)
+	for round := 0; round < image_shrink_max_rounds_; round++ {
+		scaled := img
+		if width != config.Width || height != config.Height {
+			scaled = downscaleImage(img, width, height)
+		}
+		if encoded, encodedtype, ok := encodeImageWithinLimit(scaled, format == "png", limits.Bytes); ok {
+			report = fmt.Sprintf("shrunk image from %dx%d pixels and %d KiB %s to %dx%d pixels and %d KiB %s to fit the limits",
+				config.Width, config.Height, len(contents)/1024, format, width, height, len(encoded)/1024, encodedtype[len("image/"):])
+			return encoded, encodedtype, report, nil
+		}
+		width, height = scaleDimensions(width, height, image_shrink_scale_per_round_)
+	}
+	return nil, "", "", fmt.Errorf("could not shrink image to below %d bytes", limits.Bytes)
+}
+
+// shrink a staged image file in place, if needed
+func fitImageFileIntoLimits(imgfilepath string, limits ImageLimits) (mimetype string, report string, err error) {
+	contents, err := ioutil.ReadFile(imgfilepath)
+	if err != nil {
+		return "", "", err
+	}
+	result, mimetype, report, err := fitImageIntoLimits(contents, limits)
+	if err != nil || len(report) == 0 {
+		return mimetype, report, err
+	}
+	return mimetype, report, replaceFileContents(imgfilepath, result)
+}
+
+// atomically replace the contents of a staged file
+func replaceFileContents(filepath string, contents []byte) error {
+	tmppath := filepath + ".tmp"
+	if err := ioutil.WriteFile(tmppath, contents, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmppath, filepath)
+}
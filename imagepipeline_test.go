@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+	"testing"
+)
+
+func encodeTestPNG(t *testing.T, width, height int, noise bool) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	rnd := rand.New(rand.NewSource(1))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.RGBA{uint8(x), uint8(y), 128, 255}
+			if noise {
+				c = color.RGBA{uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestImageLimitsFitDimensions(t *testing.T) {
+	for _, tc := range []struct {
+		limits        ImageLimits
+		width, height int
+		w, h          int
+	}{
+		{ImageLimits{Pixels: 1000000, Dimension: 8192}, 800, 600, 800, 600},
+		{ImageLimits{Pixels: 1000000, Dimension: 8192}, 4000, 1000, 2000, 500},
+		{ImageLimits{Pixels: 100000000, Dimension: 1000}, 4000, 1000, 1000, 250},
+		{ImageLimits{Pixels: 100000000, Dimension: 1000}, 500, 2000, 250, 1000},
+	} {
+		if w, h := tc.limits.fitDimensions(tc.width, tc.height); w != tc.w || h != tc.h {
+			t.Errorf("fitDimensions(%d, %d) = %dx%d, want %dx%d", tc.width, tc.height, w, h, tc.w, tc.h)
+		}
+	}
+}
+
+func TestFitImageIntoLimitsKeepsImagesThatFit(t *testing.T) {
+	contents := encodeTestPNG(t, 64, 48, false)
+	result, mimetype, report, err := fitImageIntoLimits(contents, ImageLimits{Bytes: 1024 * 1024, Pixels: 1000000, Dimension: 8192})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report) > 0 || mimetype != "image/png" || !bytes.Equal(result, contents) {
+		t.Errorf("image that fits was changed: %s %s", mimetype, report)
+	}
+}
+
+func TestFitImageIntoLimitsShrinksLargeImages(t *testing.T) {
+	contents := encodeTestPNG(t, 400, 300, true)
+	limits := ImageLimits{Bytes: 20 * 1024, Pixels: 1000000, Dimension: 8192}
+	result, mimetype, report, err := fitImageIntoLimits(contents, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report) == 0 || int64(len(result)) > limits.Bytes {
+		t.Fatalf("image was not shrunk to %d bytes: %d bytes, report '%s'", limits.Bytes, len(result), report)
+	}
+	if mimetype != "image/jpeg" {
+		t.Errorf("expected noisy image to be converted to jpeg, got %s", mimetype)
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(result)); err != nil {
+		t.Error("shrunk image does not decode:", err)
+	}
+
+	// too many pixels, but few bytes
+	contents = encodeTestPNG(t, 400, 300, false)
+	result, _, _, err = fitImageIntoLimits(contents, ImageLimits{Bytes: 1024 * 1024, Pixels: 30000, Dimension: 8192})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config, _, err := image.DecodeConfig(bytes.NewReader(result)); err != nil || config.Width != 200 || config.Height != 150 {
+		t.Errorf("expected image to be scaled to 200x150, got %dx%d %v", config.Width, config.Height, err)
+	}
+}
+
+func TestFitImageIntoLimitsWebPAndUnsupported(t *testing.T) {
+	webp := append([]byte("RIFF\x10\x00\x00\x00WEBPVP8 "), make([]byte, 100)...)
+	if result, mimetype, _, err := fitImageIntoLimits(webp, ImageLimits{Bytes: 1024, Pixels: 1000000, Dimension: 8192}); err != nil || mimetype != "image/webp" || !bytes.Equal(result, webp) {
+		t.Errorf("small webp should be kept, got %s %v", mimetype, err)
+	}
+	if _, _, _, err := fitImageIntoLimits(webp, ImageLimits{Bytes: 10, Pixels: 1000000, Dimension: 8192}); err == nil {
+		t.Error("large webp can not be shrunk and should be rejected")
+	}
+	if _, _, _, err := fitImageIntoLimits([]byte("BM this is not an image we know"), ImageLimits{Bytes: 1024, Pixels: 1000000, Dimension: 8192}); err == nil {
+		t.Error("unsupported format should be rejected")
+	}
+}
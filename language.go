@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+/// Language of toots, given as ISO 639 code by a "lang: de" line, detected from the text
+/// or taken from the defaults of the [language] section
+
+var (
+	default_language_      string
+	user_default_language_ map[string]string = make(map[string]string)
+	room_default_language_ map[string]string = make(map[string]string)
+	detect_language_       bool
+)
+
+var language_code_re_ = regexp.MustCompile(`^[a-z]{2,3}$`)
+
+// detection needs at least that many common words and clearly more of them than of any other language
+const (
+	language_detection_min_hits_ int     = 3
+	language_detection_margin_   float64 = 1.5
+)
+
+// most frequent short words of languages we can detect. Words shared by several languages are left out
+var language_stopwords_ = map[string][]string{
+	"en": {"the", "and", "is", "are", "was", "of", "to", "it", "that", "this", "with", "for", "you", "have", "not", "be", "on", "what", "my", "we", "they", "but", "at", "from", "just"},
+	"de": {"der", "die", "das", "und", "ist", "nicht", "ein", "eine", "ich", "zu", "mit", "sich", "auf", "für", "auch", "es", "wir", "sind", "von", "dem", "den", "aber", "noch", "wie", "heute"},
+	"fr": {"le", "la", "les", "et", "est", "un", "une", "des", "du", "je", "pas", "que", "qui", "pour", "dans", "sur", "avec", "ce", "nous", "vous", "mais", "au", "aux", "sont", "très"},
+	"es": {"el", "los", "las", "y", "es", "un", "una", "del", "que", "por", "para", "con", "no", "se", "lo", "su", "pero", "como", "más", "yo", "muy", "está", "hay", "al", "son"},
+	"it": {"il", "lo", "gli", "e", "è", "di", "che", "non", "per", "un", "una", "sono", "con", "del", "della", "ma", "anche", "questo", "ho", "mi", "si", "nel", "alla", "come", "più"},
+	"nl": {"de", "het", "een", "en", "is", "van", "niet", "dat", "ik", "op", "te", "zijn", "met", "voor", "ook", "maar", "wat", "er", "je", "we", "hij", "ze", "dit", "nog", "naar"},
+	"pt": {"o", "os", "as", "e", "é", "um", "uma", "do", "da", "dos", "não", "que", "com", "para", "em", "no", "na", "mas", "se", "eu", "você", "muito", "isso", "está", "são"},
+}
+
+// maps each word that tells languages apart to its language
+var language_stopword_index_ map[string]string
+
+func init() {
+	// words in several lists do not tell languages apart
+	counts := make(map[string]int)
+	for _, words := range language_stopwords_ {
+		for _, word := range words {
+			counts[word]++
+		}
+	}
+	language_stopword_index_ = make(map[string]string)
+	for lang, words := range language_stopwords_ {
+		for _, word := range words {
+			if counts[word] == 1 {
+				language_stopword_index_[word] = lang
+			}
+		}
+	}
+}
+
+func parseLanguage(l string) (string, error) {
+	l = strings.ToLower(strings.TrimSpace(l))
+	if !language_code_re_.MatchString(l) {
+		return "", fmt.Errorf("unknown language '%s', use a code like en or de", l)
+	}
+	return l, nil
+}
+
+// parse default languages from [language] section. Per user and room defaults are given as
+// space separated lists like users=@alice:example.org=de @bob:example.org=en
+func loadLanguageConfig() {
+	var err error
+	if default_language_ = c.GetValueDefault("language", "default", ""); len(default_language_) > 0 {
+		if default_language_, err = parseLanguage(default_language_); err != nil {
+			panic(fmt.Sprintf("ERROR: config value [language]default: %s", err))
+		}
+	}
+	detect_language_ = c.GetValueDefault("language", "detect", "false") == "true"
+	for confname, defaults := range map[string]map[string]string{"users": user_default_language_, "rooms": room_default_language_} {
+		for _, assignment := range strings.Fields(c.GetValueDefault("language", confname, "")) {
+			eqidx := strings.LastIndex(assignment, "=")
+			if eqidx <= 0 {
+				panic(fmt.Sprintf("ERROR: config value [language]%s: expected <id>=<language>, got '%s'", confname, assignment))
+			}
+			if defaults[assignment[:eqidx]], err = parseLanguage(assignment[eqidx+1:]); err != nil {
+				panic(fmt.Sprintf("ERROR: config value [language]%s: %s", confname, err))
+			}
+		}
+	}
+}
+
+// guess language of text by counting common words. Returns "" if unsure
+func detectLanguage(text string) string {
+	hits := make(map[string]int)
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) && r != '\'' }) {
+		if lang, inmap := language_stopword_index_[word]; inmap {
+			hits[lang]++
+		}
+	}
+	best, besthits, secondhits := "", 0, 0
+	for lang, n := range hits {
+		if n > besthits {
+			best, besthits, secondhits = lang, n, besthits
+		} else if n > secondhits {
+			secondhits = n
+		}
+	}
+	if besthits < language_detection_min_hits_ || float64(besthits) < language_detection_margin_*float64(secondhits) {
+		return ""
+	}
+	return best
+}
+
+// set language unless it was given in the post.
+// Order of precedence: detected language, default of user, default of room, global default
+func (opts *PostOptions) applyDefaultLanguage(post, sender, roomid string) {
+	if len(opts.Language) > 0 {
+		return
+	}
+	detected := ""
+	if detect_language_ {
+		detected = detectLanguage(post)
+	}
+	if len(detected) > 0 {
+		opts.Language = detected
+	} else if l, inmap := user_default_language_[sender]; inmap {
+		opts.Language = l
+	} else if l, inmap := room_default_language_[roomid]; inmap {
+		opts.Language = l
+	} else {
+		opts.Language = default_language_
+	}
+}
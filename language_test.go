@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+func TestDetectLanguage(t *testing.T) {
+	for text, want := range map[string]string{
+		"The weather is nice today and we are going to the lake with the kids": "en",
+		"Heute ist das Wetter schön und wir fahren mit den Kindern an den See": "de",
+		"Aujourd'hui il fait beau et nous allons au lac avec les enfants":      "fr",
+		"Hoy hace buen tiempo y vamos al lago con los niños":                   "es",
+		"Vandaag is het mooi weer en we gaan met de kinderen naar het meer":    "nl",
+		"#fediverse @alice 🎉": "",
+	} {
+		if got := detectLanguage(text); got != want {
+			t.Errorf("detectLanguage(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestApplyDefaultLanguage(t *testing.T) {
+	default_language_, detect_language_ = "en", true
+	user_default_language_ = map[string]string{"@alice:example.org": "de"}
+	defer func() {
+		default_language_, detect_language_, user_default_language_ = "", false, make(map[string]string)
+	}()
+
+	opts := PostOptions{Language: "fr"}
+	opts.applyDefaultLanguage("Heute ist das Wetter schön und wir fahren an den See", "@alice:example.org", "")
+	if opts.Language != "fr" {
+		t.Errorf("given language was replaced by %s", opts.Language)
+	}
+	opts = PostOptions{}
+	opts.applyDefaultLanguage("The weather is nice and we are going to the lake", "@alice:example.org", "")
+	if opts.Language != "en" {
+		t.Errorf("detected language was not used, got %s", opts.Language)
+	}
+	opts = PostOptions{}
+	opts.applyDefaultLanguage("👍", "@alice:example.org", "")
+	if opts.Language != "de" {
+		t.Errorf("default of user was not used, got %s", opts.Language)
+	}
+	opts = PostOptions{}
+	opts.applyDefaultLanguage("👍", "@bob:example.org", "")
+	if opts.Language != "en" {
+		t.Errorf("global default was not used, got %s", opts.Language)
+	}
+}
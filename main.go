@@ -18,6 +18,7 @@ import (
 // / Configuration Globals
 var (
 	c                              goconfig.ConfigMap
+	persistence_state_dir_         string
 	temp_image_files_dir_          string
 	feed2matrx_image_bytes_limit_  int64
 	feed2matrx_image_count_limit_  int
@@ -56,6 +57,27 @@ func configSanityChecksAndDefaults() {
 		ConfigValueDescriptor{"matrix", "favourite_prefix", "+1>"},
 		ConfigValueDescriptor{"matrix", "help_prefix", "!help"},
 		ConfigValueDescriptor{"matrix", "mediadesc_prefix", "desc>"},
+		ConfigValueDescriptor{"matrix", "thread_prefix", "thread>"},
+		ConfigValueDescriptor{"matrix", "unlisted_prefix", "unlisted>"},
+		ConfigValueDescriptor{"matrix", "followersonly_prefix", "followers>"},
+		ConfigValueDescriptor{"matrix", "poll_prefix", "poll>"},
+		ConfigValueDescriptor{"matrix", "schedule_prefix", "schedule>"},
+		ConfigValueDescriptor{"matrix", "focus_prefix", "focus>"},
+		ConfigValueDescriptor{"matrix", "media_prefix", "media>"},
+		ConfigValueDescriptor{"matrix", "bookmark_prefix", "bookmark>"},
+		ConfigValueDescriptor{"matrix", "unbookmark_prefix", "unbookmark>"},
+		ConfigValueDescriptor{"matrix", "pin_prefix", "pin>"},
+		ConfigValueDescriptor{"matrix", "unpin_prefix", "unpin>"},
+		ConfigValueDescriptor{"matrix", "mutethread_prefix", "mutethread>"},
+		ConfigValueDescriptor{"matrix", "unmutethread_prefix", "unmutethread>"},
+		ConfigValueDescriptor{"matrix", "follow_prefix", "follow>"},
+		ConfigValueDescriptor{"matrix", "unfollow_prefix", "unfollow>"},
+		ConfigValueDescriptor{"matrix", "mute_prefix", "mute>"},
+		ConfigValueDescriptor{"matrix", "unmute_prefix", "unmute>"},
+		ConfigValueDescriptor{"matrix", "block_prefix", "block>"},
+		ConfigValueDescriptor{"matrix", "unblock_prefix", "unblock>"},
+		ConfigValueDescriptor{"matrix", "removefollower_prefix", "removefollower>"},
+		ConfigValueDescriptor{"matrix", "followrequests_prefix", "followrequests>"},
 	}
 
 	for _, cfgval := range must_be_unique_and_present_configvalues {
@@ -84,16 +106,35 @@ func configSanityChecksAndDefaults() {
 
 func mainWithDefers() {
 	var err error
-	//// Create image temp dir if needed
-	if c.GetValueDefault("images", "enabled", "false") == "true" {
-		temp_image_files_dir_, err = ioutil.TempDir(c.GetValueDefault("images", "temp_dir", "/tmp"), "mycete")
-		if err != nil {
+	//// Create directory for state that needs to survive restarts
+	if len(persistence_state_dir_) > 0 {
+		if err = os.MkdirAll(persistence_state_dir_, 0700); err != nil {
 			panic(err)
 		}
-		if err = os.Chmod(temp_image_files_dir_, 0700); err != nil {
-			panic(err)
+	}
+
+	//// Create image temp dir if needed
+	if c.GetValueDefault("images", "enabled", "false") == "true" {
+		if staging_dir := strings.TrimSpace(c.GetValueDefault("images", "staging_dir", "")); len(staging_dir) > 0 {
+			//// persistent staging dir, survives restarts
+			temp_image_files_dir_ = staging_dir
+			if err = os.MkdirAll(temp_image_files_dir_, 0700); err != nil {
+				panic(err)
+			}
+			if err = os.Chmod(temp_image_files_dir_, 0700); err != nil {
+				panic(err)
+			}
+			reloadAndCleanStagingDir(matrix_image_timeout_)
+		} else {
+			temp_image_files_dir_, err = ioutil.TempDir(c.GetValueDefault("images", "temp_dir", "/tmp"), "mycete")
+			if err != nil {
+				panic(err)
+			}
+			if err = os.Chmod(temp_image_files_dir_, 0700); err != nil {
+				panic(err)
+			}
+			defer os.RemoveAll(temp_image_files_dir_)
 		}
-		defer os.RemoveAll(temp_image_files_dir_)
 	}
 
 	///////////////////////////////////////////////////////////
@@ -152,6 +193,10 @@ func main() {
 	}
 
 	configSanityChecksAndDefaults()
+	loadVisibilityConfig()
+	loadLanguageConfig()
+
+	persistence_state_dir_ = strings.TrimSpace(c.GetValueDefault("persistence", "state_dir", ""))
 
 	if poststuffreminder_timeout_str_ := c.GetValueDefault("matrix", "poststuffreminder_timeout", ""); len(poststuffreminder_timeout_str_) > 0 {
 		if poststuffreminder_timeout_, err = time.ParseDuration(poststuffreminder_timeout_str_); err != nil {
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gokyle/goconfig"
+)
+
+// use cfg as the configuration until the test finished
+func useTestConfig(t *testing.T, cfg goconfig.ConfigMap) {
+	saved := c
+	c = cfg
+	t.Cleanup(func() { c = saved })
+}
+
+// configuration of a bot tooting on chaos.social
+func useTestMastodonServer(t *testing.T) {
+	useTestConfig(t, goconfig.ConfigMap{"mastodon": {"server": "https://chaos.social"}})
+}
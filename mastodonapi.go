@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	mastodon "github.com/mattn/go-mastodon"
+)
+
+/// go-mastodon does not cover all of the API we need, so we do some requests ourselves
+
+// send request to the instance of client and decode the response into res, if res is not nil
+func mastodonAPIRequest(ctx context.Context, client *mastodon.Client, method, uri string, params url.Values, res interface{}) error {
+	_, err := mastodonAPIRequestWithStatusCode(ctx, client, method, uri, params, res)
+	return err
+}
+
+// like mastodonAPIRequest, but also returns the status code of the response, as some successful ones have special meanings
+func mastodonAPIRequestWithStatusCode(ctx context.Context, client *mastodon.Client, method, uri string, params url.Values, res interface{}) (int, error) {
+	u, err := url.Parse(client.Config.Server)
+	if err != nil {
+		return 0, err
+	}
+	u.Path = path.Join(u.Path, uri)
+
+	var body io.Reader
+	if method == http.MethodGet {
+		u.RawQuery = params.Encode()
+	} else if params != nil {
+		body = strings.NewReader(params.Encode())
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+client.Config.AccessToken)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		errmsg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return resp.StatusCode, fmt.Errorf("bad request: %s: %s", resp.Status, strings.TrimSpace(string(errmsg)))
+	}
+	if res == nil {
+		return resp.StatusCode, nil
+	}
+	return resp.StatusCode, json.NewDecoder(resp.Body).Decode(res)
+}
+
+// whether err of a go-mastodon call says the requested thing does not exist (anymore)
+func isMastodonNotFoundError(err error) bool {
+	var apierr *mastodon.APIError
+	return errors.As(err, &apierr) && apierr.StatusCode == http.StatusNotFound
+}
@@ -2,7 +2,11 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/btittelbach/anaconda"
 	"github.com/btittelbach/cachetable"
@@ -15,6 +19,10 @@ type FeedRoomConnector struct {
 	tclient        *anaconda.TwitterApi
 	mxcli          *gomatrix.Client
 	mxlinkupload_c chan<- MxContentUrlFuture
+	cursors        *FeedCursors
+
+	stream_state_lock sync.Mutex
+	streams_down      map[string]bool
 }
 
 type StatusFilterConfig struct {
@@ -31,19 +39,42 @@ type StatusFilterConfig struct {
 	must_not_be_sensitive      bool
 }
 
-func (frc *FeedRoomConnector) runSplitMastodonEventStream(evChan <-chan mastodon.Event, statusOutChan chan<- *mastodon.Status, notificationOutChan chan<- *mastodon.Notification) {
+const (
+	mastodon_stream_backoff_min_  time.Duration = 5 * time.Second
+	mastodon_stream_backoff_max_  time.Duration = 10 * time.Minute
+	mastodon_stream_stable_after_ time.Duration = 30 * time.Second
+)
+
+const (
+	mastodon_backfill_pagesize_ int64 = 40
+	mastodon_backfill_maxpages_ int   = 10
+)
+
+// describes a Mastodon stream and how to fetch what we missed of it while not being connected
+type MastodonStreamSource struct {
+	name                    string
+	subscribe               func(ctx context.Context) (chan mastodon.Event, error)
+	fetchStatusesSince      func(ctx context.Context, pg *mastodon.Pagination) ([]*mastodon.Status, error)
+	fetchNotificationsSince func(ctx context.Context, pg *mastodon.Pagination) ([]*mastodon.Notification, error)
+}
+
+func (source MastodonStreamSource) notificationCursorName() string {
+	return source.name + "/notifications"
+}
+
+// forwards events of evChan to the given channels until an error event arrives or evChan is closed.
+// statuses and notifications that we already forwarded recently, e.g. during the backfill, are dropped.
+// returns the error or nil if evChan was closed
+func (frc *FeedRoomConnector) runSplitMastodonEventStream(source MastodonStreamSource, evChan <-chan mastodon.Event, statusOutChan chan<- *mastodon.Status, notificationOutChan chan<- *mastodon.Notification) error {
 	for eventi := range evChan {
 		switch event := eventi.(type) {
 		case *mastodon.ErrorEvent:
 			log.Println("runSplitMastodonEventStream:", "Error event:", event.Error())
 			//in case of error like a network error
-			//we really probably don't want fancy error handling only to fail at a later stage
-			//when network outage continues...
-			//thus we panic and exit early, leaving it to the service handler to restart us later
-			//for now
-			panic(event.Error())
+			//we return and leave it to the supervisor to re-subscribe the stream
+			return event
 		case *mastodon.UpdateEvent:
-			if statusOutChan != nil {
+			if statusOutChan != nil && frc.cursors.MarkSeen(source.name, event.Status.ID) {
 				statusOutChan <- event.Status
 			}
 			// log.Println("runSplitMastodonEventStream: new Status", event.Status)
@@ -51,7 +82,7 @@ func (frc *FeedRoomConnector) runSplitMastodonEventStream(evChan <-chan mastodon
 			log.Println("runSplitMastodonEventStream: UpdateEditEvent", event.Status)
 			continue
 		case *mastodon.NotificationEvent:
-			if notificationOutChan != nil {
+			if notificationOutChan != nil && frc.cursors.MarkSeen(source.notificationCursorName(), event.Notification.ID) {
 				notificationOutChan <- event.Notification
 			}
 			// log.Println("runSplitMastodonEventStream: new Notification", event.Notification)
@@ -61,6 +92,136 @@ func (frc *FeedRoomConnector) runSplitMastodonEventStream(evChan <-chan mastodon
 			log.Printf("runSplitMastodonEventStream: Unhandled event: %+v", eventi)
 		}
 	}
+	return nil
+}
+
+// page through everything newer than the last status and notification we have seen
+// and forward it, oldest first, into the same channels the stream uses.
+// does nothing for streams we have never seen anything of
+func (frc *FeedRoomConnector) backfillMastodonStream(ctx context.Context, source MastodonStreamSource, statusOutChan chan<- *mastodon.Status, notificationOutChan chan<- *mastodon.Notification) error {
+	num_backfilled := 0
+	if source.fetchStatusesSince != nil && statusOutChan != nil {
+		for page := 0; page < mastodon_backfill_maxpages_; page++ {
+			since := frc.cursors.Get(source.name)
+			if len(since) == 0 {
+				break
+			}
+			// min_id returns the page immediately newer than since, so we can page forward
+			statuses, err := source.fetchStatusesSince(ctx, &mastodon.Pagination{MinID: since, Limit: mastodon_backfill_pagesize_})
+			if err != nil {
+				return err
+			}
+			sort.Slice(statuses, func(i, j int) bool { return compareMastodonIDs(statuses[i].ID, statuses[j].ID) < 0 })
+			for _, status := range statuses {
+				if frc.cursors.MarkSeen(source.name, status.ID) {
+					statusOutChan <- status
+					num_backfilled++
+				}
+			}
+			if int64(len(statuses)) < mastodon_backfill_pagesize_ {
+				break
+			}
+		}
+	}
+	if source.fetchNotificationsSince != nil && notificationOutChan != nil {
+		for page := 0; page < mastodon_backfill_maxpages_; page++ {
+			since := frc.cursors.Get(source.notificationCursorName())
+			if len(since) == 0 {
+				break
+			}
+			notifications, err := source.fetchNotificationsSince(ctx, &mastodon.Pagination{MinID: since, Limit: mastodon_backfill_pagesize_})
+			if err != nil {
+				return err
+			}
+			sort.Slice(notifications, func(i, j int) bool {
+				return compareMastodonIDs(notifications[i].ID, notifications[j].ID) < 0
+			})
+			for _, notification := range notifications {
+				if frc.cursors.MarkSeen(source.notificationCursorName(), notification.ID) {
+					notificationOutChan <- notification
+					num_backfilled++
+				}
+			}
+			if int64(len(notifications)) < mastodon_backfill_pagesize_ {
+				break
+			}
+		}
+	}
+	if num_backfilled > 0 {
+		log.Printf("backfillMastodonStream: caught up on %d statuses and notifications of stream %s", num_backfilled, source.name)
+	}
+	return nil
+}
+
+// subscribes a stream and re-subscribes it with exponential backoff whenever it fails.
+// after each (re)subscription, whatever was missed in between is backfilled before live events are forwarded.
+// the output channels and thus the filter chain behind them stay the same across reconnects.
+// changes of the stream's state are reported to the controlling room
+func (frc *FeedRoomConnector) runSupervisedMastodonStream(source MastodonStreamSource, statusOutChan chan<- *mastodon.Status, notificationOutChan chan<- *mastodon.Notification) {
+	backoff := mastodon_stream_backoff_min_
+	reported_down := false
+	for {
+		ctx, cancel := context.WithCancel(context.Background())
+		evChan, err := source.subscribe(ctx)
+		if err == nil {
+			log.Println("runSupervisedMastodonStream: subscribed", source.name)
+			// the stream waits for us while we backfill, so nothing gets lost in between
+			if err := frc.backfillMastodonStream(ctx, source, statusOutChan, notificationOutChan); err != nil {
+				log.Println("runSupervisedMastodonStream: backfill of", source.name, "failed:", err)
+			}
+			// consider the stream to be up again, once it did not fail for a while
+			stable_timer := time.AfterFunc(mastodon_stream_stable_after_, func() {
+				frc.mastodonStreamIsUp(source.name)
+			})
+			err = frc.runSplitMastodonEventStream(source, evChan, statusOutChan, notificationOutChan)
+			if !stable_timer.Stop() {
+				// stream was up for a while, so start over with short backoff
+				backoff = mastodon_stream_backoff_min_
+				reported_down = false
+			}
+			if err == nil {
+				err = fmt.Errorf("stream closed")
+			}
+		}
+		cancel()
+		if evChan != nil {
+			// drain, so the streaming goroutine of go-mastodon is not stuck on its send and can notice the cancellation
+			go func(evChan <-chan mastodon.Event) {
+				for range evChan {
+				}
+			}(evChan)
+		}
+
+		log.Printf("runSupervisedMastodonStream: stream %s failed: %s. Reconnecting in %s", source.name, err, backoff)
+		if !reported_down {
+			frc.mastodonStreamIsDown(source.name, err, backoff)
+			reported_down = true
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > mastodon_stream_backoff_max_ {
+			backoff = mastodon_stream_backoff_max_
+		}
+	}
+}
+
+func (frc *FeedRoomConnector) mastodonStreamIsDown(streamname string, err error, retry_in time.Duration) {
+	frc.stream_state_lock.Lock()
+	defer frc.stream_state_lock.Unlock()
+	if frc.streams_down == nil {
+		frc.streams_down = make(map[string]bool)
+	}
+	frc.streams_down[streamname] = true
+	mxNotify(frc.mxcli, "mastodonstream", "", fmt.Sprintf("Lost connection to Mastodon %s stream (%s). Will keep reconnecting, first retry in %s.", streamname, err, retry_in))
+}
+
+func (frc *FeedRoomConnector) mastodonStreamIsUp(streamname string) {
+	frc.stream_state_lock.Lock()
+	defer frc.stream_state_lock.Unlock()
+	if frc.streams_down[streamname] {
+		delete(frc.streams_down, streamname)
+		mxNotify(frc.mxcli, "mastodonstream", "", fmt.Sprintf("Mastodon %s stream is connected again.", streamname))
+	}
 }
 
 func (frc *FeedRoomConnector) taskJoinStatusStreams(statusOutChan chan<- *mastodon.Status) (statusOutChan1 <-chan *mastodon.Status, statusOutChan2 <-chan *mastodon.Status) {
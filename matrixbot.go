@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path"
 	"regexp"
 	"strings"
 	"time"
@@ -63,7 +64,62 @@ func getMapDeepString(m map[string]any, keys ...string) (rs string, conversionok
 // Ignore messages from ourselves
 // Ignore messages from rooms we are not interessted in
 func mxIgnoreEvent(ev *gomatrix.Event) bool {
-	return ev.Sender == c["matrix"]["user"] || ev.RoomID != c["matrix"]["room_id"]
+	if ev.Sender == c["matrix"]["user"] || ev.RoomID != c["matrix"]["room_id"] {
+		return true
+	}
+	// ignore events we already processed before a restart
+	if matrix_store_ != nil && matrix_store_.IsEventProcessed(ev.ID) {
+		log.Println("mxIgnoreEvent: already processed", ev.ID)
+		return true
+	}
+	return false
+}
+
+// The text of a notice we sent, e.g. about the feed
+func mxGetOurNotice(mxcli *gomatrix.Client, roomid, eventid string) (text string, isours bool) {
+	var ev gomatrix.Event
+	if err := mxcli.MakeRequest("GET", mxcli.BuildURL("rooms", roomid, "event", eventid), nil, &ev); err != nil {
+		log.Println("mxGetOurNotice: could not get event", eventid, err)
+		return
+	}
+	if msgtype, _ := ev.MessageType(); ev.Sender != c["matrix"]["user"] || msgtype != "m.notice" {
+		return
+	}
+	text, _ = ev.Body()
+	return text, true
+}
+
+// The goroutines handling an event. The event counts as processed once all of them finished,
+// so an event we were handling when we crashed is handled again after a restart
+type MxEventHandling struct {
+	ev *gomatrix.Event
+	wg sync.WaitGroup
+}
+
+func mxStartHandlingEvent(ev *gomatrix.Event) *MxEventHandling {
+	if matrix_store_ != nil {
+		matrix_store_.StartProcessingEvent()
+	}
+	return &MxEventHandling{ev: ev}
+}
+
+// run f in its own goroutine as part of handling the event
+func (h *MxEventHandling) Go(f func()) {
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		f()
+	}()
+}
+
+// call once all goroutines handling the event were started
+func (h *MxEventHandling) Done() {
+	go func() {
+		h.wg.Wait()
+		if matrix_store_ != nil {
+			matrix_store_.MarkEventProcessed(h.ev.ID)
+		}
+	}()
 }
 
 type mastodon_action_cmd func(string) error
@@ -92,12 +148,15 @@ func runMatrixBotTimeRelatedTasks(mxcli *gomatrix.Client) {
 
 func runMatrixPublishBot() {
 	mxcli, _ := gomatrix.NewClient(c["matrix"]["url"], "", "")
-	resp, err := mxcli.Login(&gomatrix.ReqLogin{
-		Type:     "m.login.password",
-		User:     c["matrix"]["user"],
-		Password: c["matrix"]["password"],
-	})
+	if len(persistence_state_dir_) > 0 {
+		// keep sync token, session and processed events across restarts
+		matrix_store_ = loadMatrixFileStore(path.Join(persistence_state_dir_, matrix_state_filename_))
+		go matrix_store_.flushPeriodically()
+		mxcli.Store = matrix_store_
+		mxcli.Syncer.(*gomatrix.DefaultSyncer).Store = matrix_store_
+	}
 
+	err := loginToMatrix(mxcli, matrix_store_)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
@@ -106,16 +165,20 @@ func runMatrixPublishBot() {
 	mclient := initMastodonClient()
 	tclient := initTwitterClient()
 
-	mxcli.SetCredentials(resp.UserID, resp.AccessToken)
-
 	rums_store_chan, rums_retrieve_chan := runRememberUsersMessageToStatus()
 
+	post_scheduler_ = loadPostScheduler()
+	go post_scheduler_.runPostScheduler(mclient, tclient, mxcli, rums_store_chan, rums_retrieve_chan)
+	poll_result_notifier_ = loadPollResultNotifier()
+	go poll_result_notifier_.runPollResultNotifier(mclient, mxcli)
+
 	if _, err := mxcli.JoinRoom(c["matrix"]["room_id"], "", nil); err != nil {
 		panic(err)
 	}
 
 	var markseen_c chan<- mastodon.ID = nil
 	if c.SectionInConfig("feed2matrix") {
+		loadFeedNotices()
 		markseen_c = taskWriteMastodonBackIntoMatrixRooms(mclient, mxcli)
 	}
 
@@ -126,6 +189,8 @@ func runMatrixPublishBot() {
 		if mxIgnoreEvent(ev) { //ignore messages from ourselves or from other rooms in case of dual-login
 			return
 		}
+		handling := mxStartHandlingEvent(ev)
+		defer handling.Done()
 
 		if mtype, ok := ev.MessageType(); ok {
 			switch mtype {
@@ -133,63 +198,120 @@ func runMatrixPublishBot() {
 				if post, ok := ev.Body(); ok {
 					log.Printf("Message: '%s'", post)
 
-					if rel_to_i, rel_to_inmap := ev.Content["m.relates_to"]; rel_to_inmap {
-						if in_reply_to, in_reply_to_inmap := rel_to_i.(map[string]interface{})["m.in_reply_to"]; in_reply_to_inmap {
-							if reply_to_event_id_i, reply_to_event_id_inmap := in_reply_to.(map[string]interface{})["event_id"]; reply_to_event_id_inmap {
-
-								post = RemoveQuoteTextFromMatrixElementReplyMsg(post)
-
-								go func() {
-									// check the type of message the reply-to event_id was
-									reply_to_event_id := reply_to_event_id_i.(string)
-									futuremsg := make(chan *MsgStatusData)
-									rums_retrieve_chan <- RUMSRetrieveMsg{key: reply_to_event_id, future: futuremsg}
-									reply_to_msg_data := <- futuremsg
-									if nil != reply_to_msg_data {
-										if ev.Sender != reply_to_msg_data.MatrixUser {
-											log.Println("Reply to Message: User", ev.Sender, "is not", reply_to_msg_data.MatrixUser)
-											return										
-										}
+					if replaced_event_id, new_content, isedit := getMatrixEditedEventAndContent(ev); isedit {
+						/// Edit of an earlier message, which we try to apply to the toot that resulted from it
+						handling.Go(func() { BotCmdEdit(mclient, rums_retrieve_chan, mxcli, ev, replaced_event_id, new_content) })
+						return
+					}
 
-										//our action depend on what kind of event that was
-										switch reply_to_msg_data.Action {
-											case actionPost:
-												// do nothing in case of our own post
-											case actionReblog:
-												// do nothing if we reblogged
-											case actionFav:
-												// do nothing if we fav'ed
-											case actionMedia:
-												// add description to media
-												err = saveMediaFileDescription(ev.Sender, reply_to_event_id, strings.TrimSpace(post))
-												if err != nil {
-													errmsg := fmt.Sprintf("Error saving description: %s", err)
-													mxNotify(mxcli, "imgdesc", ev.Sender, errmsg)
-													log.Println(errmsg)
-												} else {
-													mxNotify(mxcli, "imgdesc", ev.Sender, fmt.Sprintf("I attached your description to the image"))	
-												}
-											case actionMediaDesc:
-												//do nothing
-											default:
-												//do nothing
-										}
-									}
-								}()
+					// toot we reply to, if this is a reply to a notice about it
+					var reply_to_status_id mastodon.ID
+					// our own earlier post, if this is a reply to it
+					var reply_to_own_post *MsgStatusData
+					// whether this is a reply to staged media
+					var reply_to_media bool
+
+					if reply_to_event_id, is_reply := getMapDeepString(ev.Content, "m.relates_to", "m.in_reply_to", "event_id"); is_reply {
+						post = RemoveQuoteTextFromMatrixElementReplyMsg(post)
+
+						// check the type of message the reply-to event_id was
+						futuremsg := make(chan *MsgStatusData, 1)
+						rums_retrieve_chan <- RUMSRetrieveMsg{key: reply_to_event_id, future: futuremsg}
+						reply_to_msg_data := <- futuremsg
+						notice, isnotice := lookupFeedNotice(reply_to_event_id)
+						if isnotice && len(notice.AccountID) > 0 {
+							// answer to a follow request
+							handling.Go(func() { BotCmdAnswerFollowRequest(mclient, mxcli, ev, notice.AccountID, post) })
+							return
+						}
+						if !isnotice && nil == reply_to_msg_data {
+							_, isanswer := parseFollowRequestAnswer(post)
+							_, _, isposting := stripPostingPrefix(post)
+							if noticetext, isours := "", false; isanswer || isposting {
+								noticetext, isours = mxGetOurNotice(mxcli, ev.RoomID, reply_to_event_id)
+								if isours && isanswer && isFollowRequestNoticeText(noticetext) {
+									mxNotify(mxcli, "followrequest", ev.Sender, "I do not remember that follow request anymore. Use "+c["matrix"]["followrequests_prefix"]+" to list and answer pending follow requests.")
+									return
+								} else if isours && isposting {
+									// probably a notice we forgot, posting this on its own would not be what the user wanted
+									mxNotify(mxcli, "tootreply", ev.Sender, "I do not remember which status the notice you replied to is about. Not posting this. Use "+c["matrix"]["tootreply_prefix"]+" with the URL of the status instead.")
+									return
+								}
 							}
 						}
+						if isnotice {
+							// notices were written by us for everybody
+							reply_to_status_id = notice.TootID
+						} else if nil != reply_to_msg_data && ev.Sender != reply_to_msg_data.MatrixUser {
+							log.Println("Reply to Message: User", ev.Sender, "is not", reply_to_msg_data.MatrixUser)
+						} else if nil != reply_to_msg_data && reply_to_msg_data.Action == actionPost {
+							// posting continues the thread of the earlier post
+							reply_to_own_post = reply_to_msg_data
+						} else if nil != reply_to_msg_data {
+							reply_to_media = reply_to_msg_data.Action == actionMedia
+							description := strings.TrimSpace(strings.TrimPrefix(post, c["matrix"]["mediadesc_prefix"]))
+							handling.Go(func() {
+								//our action depend on what kind of event that was
+								switch reply_to_msg_data.Action {
+									case actionPost:
+										// handled above
+									case actionReblog:
+										// do nothing if we reblogged
+									case actionFav:
+										// do nothing if we fav'ed
+									case actionMedia:
+										if strings.HasPrefix(description, c["matrix"]["focus_prefix"]) {
+											// set focal point of image
+											BotCmdSetMediaFocus(mxcli, ev, reply_to_event_id, description[len(c["matrix"]["focus_prefix"]):])
+											break
+										}
+										// add description to media
+										lock := getPerUserLock(ev.Sender)
+										lock.Lock()
+										err := saveMediaFileDescription(ev.Sender, reply_to_event_id, description)
+										lock.Unlock()
+										if err != nil {
+											errmsg := fmt.Sprintf("Error saving description: %s", err)
+											mxNotify(mxcli, "imgdesc", ev.Sender, errmsg)
+											log.Println(errmsg)
+										} else {
+											mxNotify(mxcli, "imgdesc", ev.Sender, fmt.Sprintf("I attached your description to the image"))	
+										}
+									case actionMediaDesc:
+										//do nothing
+									default:
+										//do nothing
+								}
+							})
+						}
+					}
+
+					// text hidden as spoiler may be missing from the plain body
+					spoiler_reason := ""
+					if spoilertext, reason, hasspoiler := getMatrixSpoilerTextAndReason(ev); hasspoiler {
+						post, spoiler_reason = spoilertext, reason
 					}
 
 					if strings.HasPrefix(post, c["matrix"]["reblog_prefix"]) {
 						/// CMD Reblogging
 
-						go BotCmdReblog(mclient, tclient, rums_store_chan, rums_retrieve_chan, mxcli, ev, post)
+						handling.Go(func() { BotCmdReblog(mclient, tclient, rums_store_chan, rums_retrieve_chan, mxcli, ev, post) })
 						
 					} else if strings.HasPrefix(post, c["matrix"]["favourite_prefix"]) {
 						/// CMD Favourite
 
-						go BotCmdFavorite(mclient, tclient, rums_store_chan, rums_retrieve_chan, mxcli, ev, post)
+						handling.Go(func() { BotCmdFavorite(mclient, tclient, rums_store_chan, rums_retrieve_chan, mxcli, ev, post) })
 						
+					} else if action, isstatusaction := getMastodonStatusActionOfPost(post); isstatusaction {
+						/// CMD Bookmark, Pin, Mute Conversation and their reverse
+
+						handling.Go(func() { BotCmdStatusAction(mclient, rums_store_chan, mxcli, ev, post, action) })
+
+					} else if action, isaccountaction := getMastodonAccountActionOfPost(post); isaccountaction {
+						/// CMD Follow, Mute, Block, Remove Follower and their reverse
+
+						handling.Go(func() { BotCmdAccountAction(mclient, rums_store_chan, mxcli, ev, post, action) })
+
 					} else if strings.HasPrefix(post, c["matrix"]["directtweet_prefix"]) {
 						/// CMD Twitter Direct Message
 
@@ -199,7 +321,7 @@ func runMatrixPublishBot() {
 
 						post = strings.TrimSpace(post[len(c["matrix"]["directtweet_prefix"]):])
 
-						if len(post) > character_limit_twitter_ {
+						if calcTweetLength(post) > character_limit_twitter_ {
 							log.Println("Direct Tweet too long")
 							mxNotify(mxcli, "directtweet", ev.Sender, fmt.Sprintf("Not direct-tweeting this! Too long"))
 							return
@@ -211,14 +333,14 @@ func runMatrixPublishBot() {
 							return
 						}
 
-						go func() {
+						handling.Go(func() {
 							for _, rcpt := range m[1:] {
 								err := sendTwitterDirectMessage(tclient, post, rcpt)
 								if err != nil {
 									mxNotify(mxcli, "directtweet", ev.Sender, fmt.Sprintf("Error Twitter-direct-messaging %s: %s", rcpt, err.Error()))
 								}
 							}
-						}()
+						})
 
 					} else if strings.HasPrefix(post, c["matrix"]["directtoot_prefix"]) || strings.HasPrefix(post, c["matrix"]["tootreply_prefix"]) {
 						/// CMD Mastodon Direct Toot
@@ -230,29 +352,54 @@ func runMatrixPublishBot() {
 						}
 
 						var inreplyto string
-						var private bool
+						// status url given to reply to, resolved to inreplyto before tooting
+						var inreplyto_url string
+						var prefix_visibility string
 
 						if strings.HasPrefix(post, c["matrix"]["directtoot_prefix"]) {
 							post = strings.TrimSpace(post[len(c["matrix"]["directtoot_prefix"]):])
-							private = true
+							prefix_visibility = visibilityDirect
 						} else {
 							post = strings.TrimSpace(post[len(c["matrix"]["tootreply_prefix"]):])
-							private = false
 							updateLastStatusPostedTime() // public reply counts as posting
 						}
 
 						arglist := strings.SplitN(post, " ", 2)
 						if arglist != nil && len(arglist) == 2 {
-							matchlist := mastodon_status_uri_re_.FindStringSubmatch(strings.TrimSpace(arglist[0]))
-							if len(matchlist) >= 2 {
-								inreplyto = matchlist[1]
+							if isFediverseStatusURL(strings.TrimSpace(arglist[0])) {
+								inreplyto_url = strings.TrimSpace(arglist[0])
 								post = strings.TrimSpace(arglist[1])
 							}
 						}
+						if statusid, rest, isref, err := parseStatusReference(post, ev.RoomID); isref && len(inreplyto_url) == 0 {
+							// reply to a status shown in matrix, like >last or >^
+							if err != nil {
+								mxNotify(mxcli, "directtoot", ev.Sender, fmt.Sprintf("Not tooting this! %s", err.Error()))
+								return
+							}
+							reply_to_status_id, post = statusid, strings.TrimSpace(rest)
+						}
+
+						post, opts, err := parsePostOptionsWithSpoiler(post, spoiler_reason)
+						if err != nil {
+							mxNotify(mxcli, "directtoot", ev.Sender, fmt.Sprintf("Not tooting this! %s", err.Error()))
+							return
+						}
+						if prefix_visibility == visibilityDirect {
+							// a direct message stays direct
+							opts.Visibility = visibilityDirect
+						}
+						if len(inreplyto_url) == 0 && len(reply_to_status_id) > 0 {
+							// reply to the toot whose notice we replied to
+							handling.Go(func() { BotCmdReplyToStatus(mclient, rums_store_chan, mxcli, ev, post, opts, prefix_visibility, reply_to_status_id, markseen_c) })
+							return
+						}
+						opts.applyDefaultVisibility(prefix_visibility, ev.Sender, ev.RoomID)
+						opts.applyDefaultLanguage(post, ev.Sender, ev.RoomID)
 
-						if len(post) > character_limit_mastodon_ {
+						if err = checkMastodonCharacterLimit(mclient, post, opts.ContentWarning); err != nil {
 							log.Println("Direct Toot too long")
-							mxNotify(mxcli, "directtoot", ev.Sender, "Not tooting this! Too long")
+							mxNotify(mxcli, "directtoot", ev.Sender, fmt.Sprintf("Not tooting this! %s", err.Error()))
 							return
 						}
 
@@ -261,14 +408,23 @@ func runMatrixPublishBot() {
 							return
 						}
 
-						go func() {
+						handling.Go(func() {
 							lock := getPerUserLock(ev.Sender)
 							lock.Lock()
 							defer lock.Unlock()
 							var reviewurl string
 							var mastodonid mastodon.ID
 
-							reviewurl, mastodonid, err = sendToot(mclient, post, ev.Sender, private, inreplyto)
+							if len(inreplyto_url) > 0 {
+								statusid, err := resolveMastodonStatusURL(mclient, inreplyto_url)
+								if err != nil {
+									mxNotify(mxcli, "directtoot", ev.Sender, fmt.Sprintf("Not tooting this! Could not find the status you reply to: %s", err.Error()))
+									return
+								}
+								inreplyto = string(statusid)
+							}
+
+							reviewurl, mastodonid, err = sendToot(mclient, post, ev.Sender, opts, inreplyto, true)
 							if markseen_c != nil {
 								markseen_c <- mastodonid
 							}
@@ -276,61 +432,131 @@ func runMatrixPublishBot() {
 								log.Println("MastodonTootERROR:", err)
 								mxNotify(mxcli, "mastodon", ev.Sender, "ERROR while tooting!")
 							} else {
-								mxNotify(mxcli, "mastodon", ev.Sender, fmt.Sprintf("sent toot! %s", reviewurl))
+								mxNotify(mxcli, "mastodon", ev.Sender, fmt.Sprintf("sent toot!%s %s", opts.describe(), reviewurl))
 							}
 
 							//remember posted status IDs
-							rums_store_chan <- RUMSStoreMsg{key: ev.ID, data: MsgStatusData{ev.Sender, mastodonid, 0, actionPost}}
+							rums_store_chan <- RUMSStoreMsg{key: ev.ID, data: MsgStatusData{MatrixUser: ev.Sender, TootID: mastodonid, Action: actionPost}}
 
 							//remove saved image file if present. We only attach an image once.
 							if c.GetValueDefault("images", "enabled", "false") == "true" {
 								rmAllUserFiles(ev.Sender)
 							}
 
-						}()
+						})
 
-					} else if strings.HasPrefix(post, c["matrix"]["guard_prefix"]) {
+					} else if strings.HasPrefix(post, c["matrix"]["guard_prefix"]) || strings.HasPrefix(post, c["matrix"]["unlisted_prefix"]) || strings.HasPrefix(post, c["matrix"]["followersonly_prefix"]) {
 						/// CMD Posting
 
-						post = strings.TrimSpace(post[len(c["matrix"]["guard_prefix"]):])
+						var prefix_visibility string
+						switch {
+						case strings.HasPrefix(post, c["matrix"]["unlisted_prefix"]):
+							post = strings.TrimSpace(post[len(c["matrix"]["unlisted_prefix"]):])
+							prefix_visibility = visibilityUnlisted
+						case strings.HasPrefix(post, c["matrix"]["followersonly_prefix"]):
+							post = strings.TrimSpace(post[len(c["matrix"]["followersonly_prefix"]):])
+							prefix_visibility = visibilityPrivate
+						default:
+							post = strings.TrimSpace(post[len(c["matrix"]["guard_prefix"]):])
+						}
+						post, opts, err := parsePostOptionsWithSpoiler(post, spoiler_reason)
+						if err != nil {
+							mxNotify(mxcli, "postoptions", ev.Sender, fmt.Sprintf("Not tweeting/tooting this! %s", err.Error()))
+							return
+						}
+						if len(reply_to_status_id) > 0 {
+							// reply to the toot whose notice we replied to
+							handling.Go(func() { BotCmdReplyToStatus(mclient, rums_store_chan, mxcli, ev, post, opts, prefix_visibility, reply_to_status_id, markseen_c) })
+							updateLastStatusPostedTime()
+							return
+						}
+						opts.applyDefaultVisibility(prefix_visibility, ev.Sender, ev.RoomID)
+						opts.applyDefaultLanguage(post, ev.Sender, ev.RoomID)
 
-						if err = checkCharacterLimit(post); err != nil {
+						if err = checkCharacterLimit(mclient, post, opts); err != nil && c.GetValueDefault("matrix", "split_long_posts", "false") != "true" {
 							log.Println(err)
-							mxNotify(mxcli, "limitcheck", ev.Sender, fmt.Sprintf("Not tweeting/tooting this! %s", err.Error()))
+							mxNotify(mxcli, "limitcheck", ev.Sender, fmt.Sprintf("Not tweeting/tooting this! %s. Use %s to post it as a thread.", err.Error(), c["matrix"]["thread_prefix"]))
+							return
+						}
+
+						handling.Go(func() { BotCmdBlogToWorld(mclient, tclient, rums_store_chan, rums_retrieve_chan, mxcli, ev, post, opts, reply_to_own_post, markseen_c) })
+						updateLastStatusPostedTime()
+
+					} else if strings.HasPrefix(post, c["matrix"]["schedule_prefix"]) {
+						/// CMD Schedule posts for later
+
+						post = strings.TrimSpace(post[len(c["matrix"]["schedule_prefix"]):])
+
+						handling.Go(func() { BotCmdSchedule(mclient, tclient, rums_store_chan, mxcli, ev, post, spoiler_reason) })
+
+					} else if strings.HasPrefix(post, c["matrix"]["poll_prefix"]) {
+						/// CMD Poll
+
+						if c["server"]["mastodon"] != "true" {
+							mxNotify(mxcli, "poll", ev.Sender, "Polls need mastodon to be enabled")
+							return
+						}
+
+						post = strings.TrimSpace(post[len(c["matrix"]["poll_prefix"]):])
+						post, opts, err := parsePostOptionsWithSpoiler(post, spoiler_reason)
+						if err != nil {
+							mxNotify(mxcli, "postoptions", ev.Sender, fmt.Sprintf("Not tooting this poll! %s", err.Error()))
+							return
+						}
+						opts.applyDefaultVisibility("", ev.Sender, ev.RoomID)
+						opts.applyDefaultLanguage(post, ev.Sender, ev.RoomID)
+
+						handling.Go(func() { BotCmdPoll(mclient, rums_store_chan, mxcli, ev, post, opts, markseen_c) })
+						updateLastStatusPostedTime()
+
+					} else if strings.HasPrefix(post, c["matrix"]["thread_prefix"]) {
+						/// CMD Posting, split into thread if too long
+
+						post = strings.TrimSpace(post[len(c["matrix"]["thread_prefix"]):])
+						post, opts, err := parsePostOptionsWithSpoiler(post, spoiler_reason)
+						if err != nil {
+							mxNotify(mxcli, "postoptions", ev.Sender, fmt.Sprintf("Not tweeting/tooting this! %s", err.Error()))
 							return
 						}
+						opts.applyDefaultVisibility("", ev.Sender, ev.RoomID)
+						opts.applyDefaultLanguage(post, ev.Sender, ev.RoomID)
 
-						go BotCmdBlogToWorld(mclient, tclient, rums_store_chan, rums_retrieve_chan, mxcli, ev, post, markseen_c)
+						handling.Go(func() { BotCmdBlogToWorld(mclient, tclient, rums_store_chan, rums_retrieve_chan, mxcli, ev, post, opts, reply_to_own_post, markseen_c) })
 						updateLastStatusPostedTime()
 
 
-					// } else if strings.HasPrefix(post, c["matrix"]["mediadesc_prefix"]) {
-					// 	/// CMD Posting
+					} else if strings.HasPrefix(post, c["matrix"]["mediadesc_prefix"]) {
+						/// CMD describe staged media
+
+						if c.GetValueDefault("images", "enabled", "false") != "true" {
+							mxNotify(mxcli, "error", ev.Sender, "image support is disabled. Set [images]enabled=true")
+							return
+						}
+						if reply_to_media {
+							return // handled above
+						}
+						handling.Go(func() { BotCmdDescribeMedia(mxcli, ev, post[len(c["matrix"]["mediadesc_prefix"]):]) })
+
+					} else if strings.HasPrefix(post, c["matrix"]["media_prefix"]) {
+						/// CMD list, reorder or remove staged media
 
-					// 	post = strings.TrimSpace(post[len(c["matrix"]["mediadesc_prefix"]):])
+						if c.GetValueDefault("images", "enabled", "false") != "true" {
+							mxNotify(mxcli, "error", ev.Sender, "image support is disabled. Set [images]enabled=true")
+							return
+						}
+						handling.Go(func() { BotCmdMediaQueue(mxcli, ev, post[len(c["matrix"]["media_prefix"]):]) })
 
-					// 	if c.GetValueDefault("images", "enabled", "false") != "true" {
-					// 		mxNotify(mxcli, "error", ev.Sender, "image support is disabled. Set [images]enabled=true")
-					// 		return							
-					// 	}
+					} else if strings.HasPrefix(post, c["matrix"]["followrequests_prefix"]) {
+						/// CMD list and answer follow requests
 
-					// 	if err = checkCharacterLimit(post); err != nil {
-					// 		log.Println(err)
-					// 		mxNotify(mxcli, "limitcheck", ev.Sender, fmt.Sprintf("Media description too long! %s", err.Error()))
-					// 		return
-					// 	}
+						handling.Go(func() { BotCmdFollowRequests(mclient, mxcli, ev, post[len(c["matrix"]["followrequests_prefix"]):]) })
 
-					// 	go func() {
-					// 		lock := getPerUserLock(ev.Sender)
-					// 		lock.Lock()
-					// 		defer lock.Unlock()
-					// 		var reviewurl string
-					// 		var twitterid int64
-					// 		var mastodonid mastodon.ID
+					} else if strings.HasPrefix(post, c["matrix"]["focus_prefix"]) {
+						/// CMD focal point, handled above if it replies to an image
 
-					// 		//// TODO: add description to last queued image
-					//		//// use func addMediaFileDescriptionToLastMediaUpload(nick, description string) error
-					// 	}()
+						if !reply_to_media {
+							mxNotify(mxcli, "focus", ev.Sender, fmt.Sprintf("Reply to an image with %s <x>,<y> or %s top-left to set its focal point", c["matrix"]["focus_prefix"], c["matrix"]["focus_prefix"]))
+						}
 
 					} else if strings.HasPrefix(post, c["matrix"]["help_prefix"]) {
 						/// CMD Help
@@ -338,73 +564,80 @@ func runMatrixPublishBot() {
 						mxNotify(mxcli, "helptext", "", strings.Join([]string{
 							"List of available command prefixes:",
 							c["matrix"]["guard_prefix"] + " This text following the prefix at start of this line would be tweeted and tooted",
+							c["matrix"]["thread_prefix"] + " Like " + c["matrix"]["guard_prefix"] + " but text too long for a single status is split into a numbered thread",
+							c["matrix"]["unlisted_prefix"] + " Like " + c["matrix"]["guard_prefix"] + " but tooted unlisted and not tweeted",
+							c["matrix"]["followersonly_prefix"] + " Like " + c["matrix"]["guard_prefix"] + " but tooted to followers only and not tweeted",
 							c["matrix"]["directtoot_prefix"] + " [toot url] This text following would be tooted privately @user if at least one @user is contained in this line. Optionally in reply to a [toot url] given at the start.",
-							c["matrix"]["tootreply_prefix"] + " <toot url> This will publicly reply to a given toot. Only works in-instance for now.",
+							c["matrix"]["tootreply_prefix"] + " <toot url | >last | >last <n> | >last mention | >last from @user | >^> This will publicly reply to a given toot, on any instance, or to one shown in matrix: >^ is the newest in this room.",
 							c["matrix"]["directtweet_prefix"] + " Buggy and does not work",
-							c["matrix"]["reblog_prefix"] + " <toot url | twitter url> will be reblogged or retweeted",
-							c["matrix"]["favourite_prefix"] + " <toot url | twitter url> will be favourited",
+							c["matrix"]["reblog_prefix"] + " <toot url | twitter url | last | last <n> | last mention | last from @user | ^> will be reblogged or retweeted",
+							c["matrix"]["favourite_prefix"] + " <toot url | twitter url | last | last <n> | last mention | last from @user | ^> will be favourited",
+							c["matrix"]["bookmark_prefix"] + " <toot url | last | last <n> | last mention | last from @user | ^> will be bookmarked",
+							c["matrix"]["unbookmark_prefix"] + " <toot url | last | last <n> | last mention | last from @user | ^> will be unbookmarked",
+							c["matrix"]["pin_prefix"] + " <toot url | last | last <n> | last mention | last from @user | ^> (one of yours) will be pinned to your profile",
+							c["matrix"]["unpin_prefix"] + " <toot url | last | last <n> | last mention | last from @user | ^> will be unpinned from your profile",
+							c["matrix"]["mutethread_prefix"] + " <toot url | last | last <n> | last mention | last from @user | ^> mutes notifications about its conversation",
+							c["matrix"]["unmutethread_prefix"] + " <toot url | last | last <n> | last mention | last from @user | ^> unmutes its conversation",
+							c["matrix"]["follow_prefix"] + " <@user@instance | profile url> will be followed",
+							c["matrix"]["unfollow_prefix"] + " <@user@instance | profile url> will be unfollowed",
+							c["matrix"]["mute_prefix"] + " <@user@instance | profile url> [<duration>] [notifications:no] will be muted, for the given duration like 7d or forever",
+							c["matrix"]["unmute_prefix"] + " <@user@instance | profile url> will be unmuted",
+							c["matrix"]["block_prefix"] + " <@user@instance | profile url> will be blocked",
+							c["matrix"]["unblock_prefix"] + " <@user@instance | profile url> will be unblocked",
+							c["matrix"]["removefollower_prefix"] + " <@user@instance | profile url> will no longer follow you",
+							c["matrix"]["followrequests_prefix"] + " [accept <n | @user@instance> | reject <n | @user@instance>] lists pending follow requests or answers one. Replying accept or reject to a follow request, or reacting with 👍 or 👎, answers it as well",
+							c["matrix"]["poll_prefix"] + " <question> followed by one '- <option>' per line and optionally lines 'duration: 3d', 'multiple: yes', 'hidetotals: yes' will be tooted as poll",
+							c["matrix"]["schedule_prefix"] + " <time> followed by your post on the next line will publish it later. Time may be e.g. 'in 2h', '15:30', 'tomorrow 9:00' or '2006-01-02 15:04'",
+							c["matrix"]["schedule_prefix"] + " list | cancel <n> | reschedule <n> <time> will list or change scheduled posts",
+							"Start a post with a line 'cw: <warning>' or mark text as spoiler to publish it behind a content warning",
+							"Start a post with a line 'visibility: public|unlisted|followers|direct' to choose who can see it",
+							"Start a post with a line 'lang: <code>' like 'lang: de' to set the language of the toot",
+							"Start a post with lines 'desc: <description>' to describe attached media in order",
+							c["matrix"]["mediadesc_prefix"] + " [#<n>] <description> describes the media you uploaded last, or the n-th one",
+							c["matrix"]["media_prefix"] + " list | clear | remove <n> | order <n> <m> ... shows, removes or reorders the media attached to your next post",
+							"Reply to an image with " + c["matrix"]["focus_prefix"] + " <x>,<y> between -1.0 and 1.0, or with " + c["matrix"]["focus_prefix"] + " top, bottom-left, etc, to set the focal point Mastodon crops its preview around",
+							"Edit a message you tooted to edit the toot as well",
+							"Reply to your own earlier post with " + c["matrix"]["guard_prefix"] + " or " + c["matrix"]["thread_prefix"] + " to continue its thread",
+							"Reply to a mirrored toot with " + c["matrix"]["guard_prefix"] + ", " + c["matrix"]["tootreply_prefix"] + " or " + c["matrix"]["directtoot_prefix"] + " to reply to it on mastodon",
 						}, "\n"))
 					}
 				}
-			case "m.image":
+			case "m.image", "m.video", "m.audio":
 				if c.GetValueDefault("images", "enabled", "false") != "true" {
 					mxNotify(mxcli, "error", ev.Sender, "image support is disabled. Set [images]enabled=true")
 					fmt.Println("ignoring image since support not enabled in config file")
 					return
 				}
-				if infomapi, inmap := ev.Content["info"]; inmap {
-					if infomap, ok := infomapi.(map[string]interface{}); ok {
-						if imgsizei, insubmap := infomap["size"]; insubmap {
-							if imgsize, ok2 := imgsizei.(int64); ok2 {
-								if err = checkImageBytesizeLimit(imgsize); err != nil {
-									mxNotify(mxcli, "imagesaver", ev.Sender, err.Error())
-									return
-								}
-							}
-						}
+				size, mimetype, duration := getMatrixMediaInfo(ev)
+				kind := strings.TrimPrefix(mtype, "m.")
+				// images are shrunk after download if needed
+				checksize := checkImageDownloadBytesizeLimit
+				if mtype != "m.image" && len(mimetype) == 0 {
+					// info.mimetype is optional, the type of the file is checked once it is downloaded
+					checksize = checkVideoAudioBytesizeLimit
+				} else if mtype != "m.image" {
+					warning, err := checkVideoAudioLimits(mclient, mimetype, size, duration)
+					if err != nil {
+						mxNotify(mxcli, "mediasaver", ev.Sender, err.Error())
+						return
+					}
+					if len(warning) > 0 {
+						mxNotify(mxcli, "mediasaver", ev.Sender, warning)
+					}
+					checksize = func(size int64) error {
+						_, err := checkVideoAudioLimits(mclient, mimetype, size, duration)
+						return err
+					}
+				} else if size > 0 {
+					if err = checkImageDownloadBytesizeLimit(size); err != nil {
+						mxNotify(mxcli, "imagesaver", ev.Sender, err.Error())
+						return
 					}
 				}
 
-				if urli, inmap := ev.Content["url"]; inmap {
-					if url, ok := urli.(string); ok {
-						go func() {
-							lock := getPerUserLock(ev.Sender)
-							lock.Lock()
-							defer lock.Unlock()
-							if err := saveMatrixFile(mxcli, ev.Sender, ev.ID, url); err != nil {
-								mxNotify(mxcli, "error", ev.Sender, "Could not get your image! "+err.Error())
-								fmt.Println("ERROR downloading image:", err)
-								return
-							}
-							// save event id of saved image, so we know where to attach description in case of reply
-							rums_store_chan <- RUMSStoreMsg{key: ev.ID, data: MsgStatusData{MatrixUser: ev.Sender, Action: actionMedia}}
-							// notify user
-							mxNotify(mxcli, "imagesaver", ev.Sender, fmt.Sprintf("image saved. Will tweet/toot with %s's next message", ev.Sender))
-
-							// check for media caption
-							img_filename, inmap_filename := ev.Content["filename"]
-							if img_body, inmap_body := ev.Content["body"]; inmap_body && inmap_filename {
-								// https://spec.matrix.org/v1.14/client-server-api/#media-captions
-								img_body_s, ok1 := img_body.(string)
-								img_filename_s, ok2 := img_filename.(string)
-								if ok1 && ok2 && img_body_s != img_filename_s {
-									// yes, body is a media caption
-									err = saveMediaFileDescription(ev.Sender, ev.ID, strings.TrimSpace(img_body_s))
-									if err != nil {
-										errmsg := fmt.Sprintf("Error saving media caption: %s", err)
-										log.Println(errmsg)
-									} else {
-										mxNotify(mxcli, "imgdesc", ev.Sender, fmt.Sprintf("media caption was saved as image description"))
-									}
-								}
-							}
-
-						}()
-					}
+				if url, ok := getMapDeepString(ev.Content, "url"); ok {
+					handling.Go(func() { BotCmdSaveMedia(mclient, rums_store_chan, mxcli, ev, url, kind, mimetype, duration, checksize) })
 				}
-			case "m.video", "m.audio":
-				fmt.Printf("%s messages are currently not supported", mtype)
-				mxNotify(mxcli, "runMatrixPublishBot", ev.Sender, "Ahh. Audio/Video files are not supported directly. Please just include it's URL in your Toot/Tweet and Mastodon/Twitter will do the rest.")
 			default:
 				fmt.Printf("%s messages are currently not supported", mtype)
 			}
@@ -416,8 +649,10 @@ func runMatrixPublishBot() {
 		if mxIgnoreEvent(ev) { //ignore messages from ourselves or from other rooms in case of dual-login
 			return
 		}
+		handling := mxStartHandlingEvent(ev)
+		defer handling.Done()
 		if c.GetValueDefault("images", "enabled", "false") == "true" {
-			go func() {
+			handling.Go(func() {
 				lock := getPerUserLock(ev.Sender)
 				lock.Lock()
 				defer lock.Unlock()
@@ -429,11 +664,37 @@ func runMatrixPublishBot() {
 					log.Println("ERROR deleting image:", err)
 				}
 
-			}()
+			})
 		}
 		
-		go BotCmdRedactStuff(mclient, tclient, rums_store_chan, rums_retrieve_chan, mxcli, ev)
+		handling.Go(func() { BotCmdRedactStuff(mclient, tclient, rums_store_chan, rums_retrieve_chan, mxcli, ev) })
+
+	})
 
+	/// Support reactions to answer follow requests
+	syncer.OnEventType("m.reaction", func(ev *gomatrix.Event) {
+		if mxIgnoreEvent(ev) { //ignore reactions from ourselves or from other rooms in case of dual-login
+			return
+		}
+		handling := mxStartHandlingEvent(ev)
+		defer handling.Done()
+		reacted_to_event_id, ok1 := getMapDeepString(ev.Content, "m.relates_to", "event_id")
+		key, ok2 := getMapDeepString(ev.Content, "m.relates_to", "key")
+		if !ok1 || !ok2 {
+			return
+		}
+		if _, isanswer := parseFollowRequestAnswer(key); !isanswer {
+			return
+		}
+		if notice, isnotice := lookupFeedNotice(reacted_to_event_id); isnotice && len(notice.AccountID) > 0 {
+			handling.Go(func() { BotCmdAnswerFollowRequest(mclient, mxcli, ev, notice.AccountID, key) })
+		} else if !isnotice {
+			handling.Go(func() {
+				if noticetext, isours := mxGetOurNotice(mxcli, ev.RoomID, reacted_to_event_id); isours && isFollowRequestNoticeText(noticetext) {
+					mxNotify(mxcli, "followrequest", ev.Sender, "I do not remember that follow request anymore. Use "+c["matrix"]["followrequests_prefix"]+" to list and answer pending follow requests.")
+				}
+			})
+		}
 	})
 
 	/// Send a warning or welcome text to newly joined users
@@ -442,6 +703,8 @@ func runMatrixPublishBot() {
 			if mxIgnoreEvent(ev) { //ignore messages from ourselves or from other rooms in case of dual-login
 				return
 			}
+			handling := mxStartHandlingEvent(ev)
+			defer handling.Done()
 
 			if membership, inmap := ev.Content["membership"]; inmap && membership == "join" {
 				if v, found := getMapDeepString(ev.Unsigned, "prev_content","membership"); found && v == "join" {
@@ -483,6 +746,17 @@ func runMatrixPublishBot() {
 		})
 	}
 
+	/// Remove media from a persistent staging dir once it is older than the timeout
+	if c.GetValueDefault("images", "enabled", "false") == "true" && len(strings.TrimSpace(c.GetValueDefault("images", "staging_dir", ""))) > 0 {
+		go func() {
+			for range time.Tick(time.Minute) {
+				for nick, count := range removeOutdatedStagedMedia(matrix_image_timeout_) {
+					mxNotify(mxcli, "mediatimeout", nick, fmt.Sprintf("I removed %d of your staged media files, which you did not post within %s", count, matrix_image_timeout_))
+				}
+			}
+		}()
+	}
+
 	go func () {
 		for {
 			runMatrixBotTimeRelatedTasks(mxcli)
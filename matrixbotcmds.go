@@ -4,9 +4,11 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net/url"
 	"strconv"
 	"strings"
 	"bufio"
+	"time"
 
 	mastodon "github.com/mattn/go-mastodon"
 	"github.com/matrix-org/gomatrix"
@@ -52,13 +54,21 @@ func RemoveQuoteTextFromMatrixElementReplyMsg(inputbody string) (outputbody stri
 // ✓ "status <ID>" --> mastdon
 // ✓ "tweet <ID>" --> twitter
 // ✓ "birdsite <ID>" --> twitter
-// - last --> favourite the last received toot or tweet
-func parseReblogFavouriteArgs(prefix, line string, mxcli *gomatrix.Client, mcmd mastodon_action_cmd, tcmd twitter_action_cmd) error {
+// ✓ "last" or ">last" --> favourite the last received toot
+func parseReblogFavouriteArgs(prefix, line, roomid string, mclient *mastodon.Client, mxcli *gomatrix.Client, mcmd mastodon_action_cmd, tcmd twitter_action_cmd) error {
 	tort := ""
 	statusidstr := ""
-	args := strings.SplitN(strings.ToLower(strings.TrimSpace(line[len(prefix):])), " ", 3)
+	if statusid, isref, err := parseStatusReferenceArg(strings.TrimSpace(line[len(prefix):]), roomid); isref {
+		// last, last 3, last mention, last from @user or ^, with or without leading >
+		if err != nil {
+			return err
+		}
+		return mcmd(string(statusid))
+	}
+	// not lowercased, as IDs in URLs of some fediverse software are case sensitive
+	args := strings.SplitN(strings.TrimSpace(line[len(prefix):]), " ", 3)
 	if len(args) > 1 {
-		switch args[0] {
+		switch strings.ToLower(args[0]) {
 		case "toot", "status":
 			tort = mastodon_net
 			statusidstr = args[1]
@@ -67,15 +77,16 @@ func parseReblogFavouriteArgs(prefix, line string, mxcli *gomatrix.Client, mcmd
 			statusidstr = args[1]
 		}
 	} else if len(args) == 1 {
-		if args[0] == "last" {
-			///TODO
-			return fmt.Errorf("Sorry, 'last' not implemented yet")
-		} else if matchlist := mastodon_status_uri_re_.FindStringSubmatch(args[0]); len(matchlist) >= 2 {
-			tort = mastodon_net
-			statusidstr = matchlist[1]
-		} else if matchlist := twitter_status_uri_re_.FindStringSubmatch(args[0]); len(matchlist) >= 2 {
+		if matchlist := twitter_status_uri_re_.FindStringSubmatch(args[0]); len(matchlist) >= 2 {
 			tort = twitter_net
 			statusidstr = matchlist[1]
+		} else if isFediverseStatusURL(args[0]) {
+			statusid, err := resolveMastodonStatusURL(mclient, args[0])
+			if err != nil {
+				return err
+			}
+			tort = mastodon_net
+			statusidstr = string(statusid)
 		}
 	}
 	/// now execute
@@ -85,7 +96,7 @@ func parseReblogFavouriteArgs(prefix, line string, mxcli *gomatrix.Client, mcmd
 	case mastodon_net:
 		return mcmd(statusidstr)
 	default:
-		return fmt.Errorf("Please say " + prefix + " followed by 'last', <status URL> or 'toot'/'tweet' <ID>")
+		return fmt.Errorf("Please say " + prefix + " followed by 'last', 'last <n>', 'last mention', 'last from @user', '^', <status URL> or 'toot'/'tweet' <ID>")
 	}
 }
 
@@ -95,7 +106,7 @@ func parseReblogFavouriteArgs(prefix, line string, mxcli *gomatrix.Client, mcmd
 
 func BotCmdReblog(mclient *mastodon.Client, tclient *anaconda.TwitterApi, rums_store_chan chan<- RUMSStoreMsg, rums_retrieve_chan chan<- RUMSRetrieveMsg, mxcli *gomatrix.Client, ev *gomatrix.Event, post string) {
 
-	if err := parseReblogFavouriteArgs(c["matrix"]["reblog_prefix"], post, mxcli,
+	if err := parseReblogFavouriteArgs(c["matrix"]["reblog_prefix"], post, ev.RoomID, mclient, mxcli,
 		func(statusid string) error {
 			_, err := mclient.Reblog(context.Background(), mastodon.ID(statusid))
 			if err == nil {
@@ -127,7 +138,7 @@ func BotCmdReblog(mclient *mastodon.Client, tclient *anaconda.TwitterApi, rums_s
 }
 
 func BotCmdFavorite(mclient *mastodon.Client, tclient *anaconda.TwitterApi, rums_store_chan chan<- RUMSStoreMsg, rums_retrieve_chan chan<- RUMSRetrieveMsg, mxcli *gomatrix.Client, ev *gomatrix.Event, post string) {
-	err := parseReblogFavouriteArgs(c["matrix"]["favourite_prefix"], post, mxcli,
+	err := parseReblogFavouriteArgs(c["matrix"]["favourite_prefix"], post, ev.RoomID, mclient, mxcli,
 		func(statusid string) error {
 			_, err := mclient.Favourite(context.Background(), mastodon.ID(statusid))
 			if err == nil {
@@ -158,40 +169,174 @@ func BotCmdFavorite(mclient *mastodon.Client, tclient *anaconda.TwitterApi, rums
 	}
 }
 
-func BotCmdBlogToWorld(mclient *mastodon.Client, tclient *anaconda.TwitterApi, rums_store_chan chan<- RUMSStoreMsg, rums_retrieve_chan chan<- RUMSRetrieveMsg, mxcli *gomatrix.Client, ev *gomatrix.Event, post string, markseen_c chan<- mastodon.ID) {
+// bookmark, pin or mute the conversation of a status, or undo that
+func BotCmdStatusAction(mclient *mastodon.Client, rums_store_chan chan<- RUMSStoreMsg, mxcli *gomatrix.Client, ev *gomatrix.Event, post string, action MsgStatusDataAction) {
+	statusaction := mastodon_status_actions_[action]
+	err := parseReblogFavouriteArgs(c["matrix"][statusaction.PrefixConfName], post, ev.RoomID, mclient, mxcli,
+		func(statusid string) error {
+			err := doMastodonStatusAction(mclient, action, mastodon.ID(statusid))
+			if err == nil {
+				rums_store_chan <- RUMSStoreMsg{key: ev.ID, data: MsgStatusData{MatrixUser: ev.Sender, TootID: mastodon.ID(statusid), Action: action}}
+			}
+			return err
+		},
+		func(postidstr string) error {
+			return fmt.Errorf("Sorry, this only works for toots")
+		},
+	)
+	if err == nil {
+		mxNotify(mxcli, statusaction.Endpoint, ev.Sender, "Ok, I "+fmt.Sprintf(statusaction.Done, "that status"))
+	} else {
+		mxNotify(mxcli, statusaction.Endpoint, ev.Sender, fmt.Sprintf("error with %s: %s", c["matrix"][statusaction.PrefixConfName], err.Error()))
+	}
+}
+
+// accept or reject the follow request of accountid, according to answer
+func BotCmdAnswerFollowRequest(mclient *mastodon.Client, mxcli *gomatrix.Client, ev *gomatrix.Event, accountid mastodon.ID, answer string) {
+	accept, isanswer := parseFollowRequestAnswer(answer)
+	if !isanswer {
+		mxNotify(mxcli, "followrequest", ev.Sender, "Reply to a follow request with accept or reject, or react with 👍 or 👎")
+		return
+	}
+	if err := answerFollowRequest(mclient, accountid, accept); err != nil {
+		mxNotify(mxcli, "followrequest", ev.Sender, fmt.Sprintf("error answering the follow request: %s", err.Error()))
+		return
+	}
+	who := "they"
+	if account, err := mclient.GetAccount(context.Background(), accountid); err == nil {
+		who = "@" + fullAcct(account.Acct)
+	}
+	mxNotify(mxcli, "followrequest", ev.Sender, formatFollowRequestAnswer(accept, who))
+}
+
+// list pending follow requests, or accept or reject one of them
+func BotCmdFollowRequests(mclient *mastodon.Client, mxcli *gomatrix.Client, ev *gomatrix.Event, args string) {
+	accounts, err := getPendingFollowRequests(mclient)
+	if err != nil {
+		mxNotify(mxcli, "followrequest", ev.Sender, fmt.Sprintf("error getting follow requests: %s", err.Error()))
+		return
+	}
+	arglist := strings.Fields(args)
+	if len(arglist) == 0 || arglist[0] == "list" {
+		mxNotify(mxcli, "followrequest", ev.Sender, formatFollowRequestList(accounts))
+		return
+	}
+	accept, isanswer := parseFollowRequestAnswer(arglist[0])
+	if !isanswer || len(arglist) != 2 {
+		mxNotify(mxcli, "followrequest", ev.Sender, fmt.Sprintf("Please say %s followed by nothing, accept <n | @user@instance> or reject <n | @user@instance>", c["matrix"]["followrequests_prefix"]))
+		return
+	}
+	account, err := findFollowRequest(accounts, arglist[1])
+	if err == nil {
+		err = answerFollowRequest(mclient, account.ID, accept)
+	}
+	if err != nil {
+		mxNotify(mxcli, "followrequest", ev.Sender, fmt.Sprintf("error answering the follow request: %s", err.Error()))
+		return
+	}
+	mxNotify(mxcli, "followrequest", ev.Sender, formatFollowRequestAnswer(accept, "@"+fullAcct(account.Acct)))
+}
+
+// follow, mute, block or remove from followers an account, or undo that
+func BotCmdAccountAction(mclient *mastodon.Client, rums_store_chan chan<- RUMSStoreMsg, mxcli *gomatrix.Client, ev *gomatrix.Event, post string, action MsgStatusDataAction) {
+	accountaction := mastodon_account_actions_[action]
+	prefix := c["matrix"][accountaction.PrefixConfName]
+	args := strings.Fields(post[len(prefix):])
+	if len(args) == 0 || (len(args) > 1 && action != actionMuteAccount) {
+		usage := fmt.Sprintf("Please say %s followed by @user@instance or the URL of their profile", prefix)
+		if action == actionMuteAccount {
+			usage += ", optionally followed by a duration like 7d and notifications:no to still get their notifications"
+		}
+		mxNotify(mxcli, accountaction.Endpoint, ev.Sender, usage)
+		return
+	}
+	var params url.Values
+	if action == actionMuteAccount {
+		var err error
+		if params, err = parseMuteOptions(args[1:]); err != nil {
+			mxNotify(mxcli, accountaction.Endpoint, ev.Sender, fmt.Sprintf("error with %s: %s", prefix, err.Error()))
+			return
+		}
+	}
+	account, err := resolveMastodonAccount(mclient, args[0])
+	if err != nil {
+		mxNotify(mxcli, accountaction.Endpoint, ev.Sender, fmt.Sprintf("error with %s: %s", prefix, err.Error()))
+		return
+	}
+	relationship, err := doMastodonAccountAction(mclient, action, account.ID, params)
+	if err != nil {
+		mxNotify(mxcli, accountaction.Endpoint, ev.Sender, fmt.Sprintf("error with %s: %s", prefix, err.Error()))
+		return
+	}
+	rums_store_chan <- RUMSStoreMsg{key: ev.ID, data: MsgStatusData{MatrixUser: ev.Sender, AccountID: account.ID, Action: action}}
+	mxNotify(mxcli, accountaction.Endpoint, ev.Sender, fmt.Sprintf("Ok, I %s. Now %s", fmt.Sprintf(accountaction.Done, "@"+fullAcct(account.Acct)), formatRelationship(relationship)))
+}
+
+func BotCmdBlogToWorld(mclient *mastodon.Client, tclient *anaconda.TwitterApi, rums_store_chan chan<- RUMSStoreMsg, rums_retrieve_chan chan<- RUMSRetrieveMsg, mxcli *gomatrix.Client, ev *gomatrix.Event, post string, opts PostOptions, reply_to_post *MsgStatusData, markseen_c chan<- mastodon.ID) {
 	lock := getPerUserLock(ev.Sender)
 	lock.Lock()
 	defer lock.Unlock()
 	var reviewurl string
-	var twitterid int64
-	var mastodonid mastodon.ID
 	var err error
+	var parts []string
+	// continue the thread of our own earlier post, on each network it went to
+	var inreplyto_toot mastodon.ID
+	var inreplyto_tweet int64
+	if reply_to_post != nil {
+		inreplyto_toot, inreplyto_tweet = reply_to_post.lastTootID(), reply_to_post.lastTweetID()
+	}
+	rums := MsgStatusData{MatrixUser: ev.Sender, Action: actionPost}
 
+	// posts that are too long are split into a thread, separately for each network's limit.
+	// each part repeats the content warning, which counts towards the limit
 	if c["server"]["mastodon"] == "true" {
-		reviewurl, mastodonid, err = sendToot(mclient, post, ev.Sender, false, "")
+		var mastodonids []mastodon.ID
+		limits := getMastodonTextLimits(mclient)
+		if parts, err = splitPostIntoThread(post, limits.MaxCharacters-limits.statusLength(opts.ContentWarning), limits.statusLength); err == nil {
+			reviewurl, mastodonids, err = sendTootThread(mclient, parts, ev.Sender, opts, inreplyto_toot)
+		}
 		if markseen_c != nil {
-			markseen_c <- mastodonid
+			for _, mastodonid := range mastodonids {
+				markseen_c <- mastodonid
+			}
+		}
+		if len(mastodonids) > 0 {
+			rums.TootID = mastodonids[0]
+			rums.ThreadTootIDs = mastodonids[1:]
 		}
 		if err != nil {
 			log.Println("MastodonTootERROR:", err)
 			mxNotify(mxcli, "mastodon", ev.Sender, "ERROR while tooting!")
+		} else if len(parts) > 1 {
+			mxNotify(mxcli, "mastodon", ev.Sender, fmt.Sprintf("sent thread of %d toots!%s %s", len(parts), opts.describe(), reviewurl))
 		} else {
-			mxNotify(mxcli, "mastodon", ev.Sender, fmt.Sprintf("sent toot! %s", reviewurl))
+			mxNotify(mxcli, "mastodon", ev.Sender, fmt.Sprintf("sent toot!%s %s", opts.describe(), reviewurl))
 		}
 	}
 
-	if c["server"]["twitter"] == "true" {
-		reviewurl, twitterid, err = sendTweet(tclient, post, ev.Sender)
+	if c["server"]["twitter"] == "true" && !opts.isTweetable() {
+		mxNotify(mxcli, "twitter", ev.Sender, "not tweeting this, as it is not public")
+	} else if c["server"]["twitter"] == "true" {
+		var twitterids []int64
+		if parts, err = splitPostIntoThread(post, character_limit_twitter_-calcTweetLength(opts.contentWarningPrefix()), calcTweetLength); err == nil {
+			reviewurl, twitterids, err = sendTweetThread(tclient, parts, ev.Sender, opts, inreplyto_tweet)
+		}
+		if len(twitterids) > 0 {
+			rums.TweetID = twitterids[0]
+			rums.ThreadTweetIDs = twitterids[1:]
+		}
 		if err != nil {
 			log.Println("TwitterTweetERROR:", err)
 			mxNotify(mxcli, "twitter", ev.Sender, "ERROR while tweeting!")
+		} else if len(parts) > 1 {
+			mxNotify(mxcli, "twitter", ev.Sender, fmt.Sprintf("sent thread of %d tweets!%s %s", len(parts), opts.describe(), reviewurl))
 		} else {
-			mxNotify(mxcli, "twitter", ev.Sender, fmt.Sprintf("sent tweet! %s", reviewurl))
+			mxNotify(mxcli, "twitter", ev.Sender, fmt.Sprintf("sent tweet!%s %s", opts.describe(), reviewurl))
 		}
 	}
 
-	//remember posted status IDs
-	rums_store_chan <- RUMSStoreMsg{key: ev.ID, data: MsgStatusData{ev.Sender, mastodonid, twitterid, actionPost}}
+	//remember posted status IDs, including all parts of a thread
+	rums_store_chan <- RUMSStoreMsg{key: ev.ID, data: rums}
 
 	//remove saved image file if present. We only attach an image once.
 	if c.GetValueDefault("images", "enabled", "false") == "true" {
@@ -199,32 +344,550 @@ func BotCmdBlogToWorld(mclient *mastodon.Client, tclient *anaconda.TwitterApi, r
 	}
 }
 
+func BotCmdPoll(mclient *mastodon.Client, rums_store_chan chan<- RUMSStoreMsg, mxcli *gomatrix.Client, ev *gomatrix.Event, post string, opts PostOptions, markseen_c chan<- mastodon.ID) {
+	var question string
+	var err error
+	question, opts.Poll, err = parsePoll(post, getMastodonPollLimits(mclient))
+	if err != nil {
+		mxNotify(mxcli, "poll", ev.Sender, fmt.Sprintf("Not tooting this poll! %s", err.Error()))
+		return
+	}
+	if err = checkMastodonCharacterLimit(mclient, question, opts.ContentWarning); err != nil {
+		mxNotify(mxcli, "poll", ev.Sender, fmt.Sprintf("Not tooting this poll! Question %s", err.Error()))
+		return
+	}
+
+	lock := getPerUserLock(ev.Sender)
+	lock.Lock()
+	defer lock.Unlock()
+	// Mastodon does not allow media in polls, so staged media stays for the next post
+	reviewurl, mastodonid, err := sendToot(mclient, question, ev.Sender, opts, "", false)
+	if markseen_c != nil {
+		markseen_c <- mastodonid
+	}
+	if err != nil {
+		log.Println("MastodonPollERROR:", err)
+		mxNotify(mxcli, "poll", ev.Sender, "ERROR while tooting poll!")
+		return
+	}
+	duration := time.Duration(opts.Poll.ExpiresInSeconds) * time.Second
+	mxNotify(mxcli, "poll", ev.Sender, fmt.Sprintf("sent poll!%s %s Results will be shown when it closes in %s", opts.describe(), reviewurl, duration))
+
+	//remember posted status ID, so the poll can be deleted by redaction
+	rums_store_chan <- RUMSStoreMsg{key: ev.ID, data: MsgStatusData{MatrixUser: ev.Sender, TootID: mastodonid, Action: actionPost}}
+
+	// without notifications in the control room, nobody would tell us about the results
+	if !mastodonNotificationsShownInControlRoom() {
+		poll_result_notifier_.Add(mastodonid, ev.Sender, reviewurl, time.Now().Add(duration))
+	}
+}
+
+// stage image, video or audio file of ev for the next post of its sender
+func BotCmdSaveMedia(mclient *mastodon.Client, rums_store_chan chan<- RUMSStoreMsg, mxcli *gomatrix.Client, ev *gomatrix.Event, url, kind, mimetype string, duration time.Duration, checksize func(int64) error) {
+	lock := getPerUserLock(ev.Sender)
+	lock.Lock()
+	defer lock.Unlock()
+	if err := checkStagedMediaMix(ev.Sender, ev.ID, mimetype); err != nil {
+		mxNotify(mxcli, "mediasaver", ev.Sender, fmt.Sprintf("Not saving your %s! %s", kind, err.Error()))
+		return
+	}
+	if err := saveMatrixFile(mxcli, ev.Sender, ev.ID, url, mimetype, duration, checksize); err != nil {
+		mxNotify(mxcli, "error", ev.Sender, fmt.Sprintf("Could not get your %s! %s", kind, err.Error()))
+		fmt.Println("ERROR downloading media:", err)
+		return
+	}
+	if kind != "image" && len(mimetype) == 0 {
+		// the event did not tell the type, so only now we can check the file against the limits
+		warning, err := checkDownloadedVideoAudio(mclient, ev.Sender, ev.ID, duration)
+		if err != nil {
+			rmFile(ev.Sender, ev.ID)
+			mxNotify(mxcli, "mediasaver", ev.Sender, fmt.Sprintf("Not saving your %s! %s", kind, err.Error()))
+			return
+		}
+		if len(warning) > 0 {
+			mxNotify(mxcli, "mediasaver", ev.Sender, warning)
+		}
+	}
+	if kind == "image" {
+		// remove metadata and shrink images that are too large for the enabled networks
+		_, imgfilepath := hashNickAndTypeAndEventIdToPath(ev.Sender, uploadfile_type_media_, ev.ID)
+		if c.GetValueDefault("images", "keep_metadata", "false") != "true" {
+			info, err := stripImageFileMetadata(imgfilepath)
+			if err != nil {
+				rmFile(ev.Sender, ev.ID)
+				mxNotify(mxcli, "imagesaver", ev.Sender, fmt.Sprintf("Not saving your image! Could not remove its metadata: %s", err.Error()))
+				return
+			}
+			if info.HasLocation {
+				mxNotify(mxcli, "imagesaver", ev.Sender, "removed location data from your image")
+			}
+		}
+		newmimetype, report, err := fitImageFileIntoLimits(imgfilepath, getImageLimits(getMastodonMediaLimits(mclient).ImageMatrixLimit))
+		if err != nil {
+			rmFile(ev.Sender, ev.ID)
+			mxNotify(mxcli, "imagesaver", ev.Sender, fmt.Sprintf("Not saving your image! %s", err.Error()))
+			return
+		}
+		if len(report) > 0 {
+			if err = addStagedMediaToManifest(ev.Sender, ev.ID, newmimetype, 0); err != nil {
+				log.Println("BotCmdSaveMedia:", err)
+			}
+			mxNotify(mxcli, "imagesaver", ev.Sender, report)
+		}
+	}
+	// save event id of saved media, so we know where to attach description in case of reply
+	rums_store_chan <- RUMSStoreMsg{key: ev.ID, data: MsgStatusData{MatrixUser: ev.Sender, Action: actionMedia}}
+	// notify user
+	mxNotify(mxcli, "imagesaver", ev.Sender, fmt.Sprintf("%s saved. Will tweet/toot with %s's next message", kind, ev.Sender))
+
+	// check for media caption
+	img_filename, inmap_filename := ev.Content["filename"]
+	if img_body, inmap_body := ev.Content["body"]; inmap_body && inmap_filename {
+		// https://spec.matrix.org/v1.14/client-server-api/#media-captions
+		img_body_s, ok1 := img_body.(string)
+		img_filename_s, ok2 := img_filename.(string)
+		if ok1 && ok2 && img_body_s != img_filename_s {
+			// yes, body is a media caption
+			if err := saveMediaFileDescription(ev.Sender, ev.ID, strings.TrimSpace(img_body_s)); err != nil {
+				errmsg := fmt.Sprintf("Error saving media caption: %s", err)
+				log.Println(errmsg)
+			} else {
+				mxNotify(mxcli, "imgdesc", ev.Sender, fmt.Sprintf("media caption was saved as %s description", kind))
+			}
+		}
+	}
+}
+
+// set the focal point of the staged image of event eventid
+func BotCmdSetMediaFocus(mxcli *gomatrix.Client, ev *gomatrix.Event, eventid, focusarg string) {
+	focus, err := parseMediaFocus(focusarg)
+	if err != nil {
+		mxNotify(mxcli, "focus", ev.Sender, fmt.Sprintf("Not setting focal point! %s", err.Error()))
+		return
+	}
+	lock := getPerUserLock(ev.Sender)
+	lock.Lock()
+	err = saveMediaFileFocus(ev.Sender, eventid, focus)
+	lock.Unlock()
+	if err != nil {
+		errmsg := fmt.Sprintf("Error saving focal point: %s", err)
+		mxNotify(mxcli, "focus", ev.Sender, errmsg)
+		log.Println(errmsg)
+		return
+	}
+	mxNotify(mxcli, "focus", ev.Sender, fmt.Sprintf("set focal point of the image to %s", focus))
+}
+
+// list, reorder or remove the media ev.Sender staged for the next post
+func BotCmdMediaQueue(mxcli *gomatrix.Client, ev *gomatrix.Event, args string) {
+	lock := getPerUserLock(ev.Sender)
+	lock.Lock()
+	defer lock.Unlock()
+	entries, err := getStagedMedia(ev.Sender)
+	if err != nil {
+		mxNotify(mxcli, "media", ev.Sender, fmt.Sprintf("Could not read your staged media: %s", err.Error()))
+		return
+	}
+	fields := strings.Fields(args)
+	cmd := "list"
+	if len(fields) > 0 {
+		cmd = strings.ToLower(fields[0])
+	}
+	switch {
+	case cmd == "list":
+		mxNotify(mxcli, "media", ev.Sender, formatStagedMediaList(entries, time.Now()))
+	case cmd == "clear":
+		if err = rmAllUserFiles(ev.Sender); err != nil {
+			mxNotify(mxcli, "media", ev.Sender, fmt.Sprintf("Could not remove your staged media: %s", err.Error()))
+			return
+		}
+		mxNotify(mxcli, "media", ev.Sender, fmt.Sprintf("removed all %d staged media", len(entries)))
+	case cmd == "remove" && len(fields) == 2:
+		pos, err := parseMediaPosition(fields[1], len(entries))
+		if err != nil {
+			mxNotify(mxcli, "media", ev.Sender, err.Error())
+			return
+		}
+		if err = rmFile(ev.Sender, entries[pos].EventID); err != nil {
+			mxNotify(mxcli, "media", ev.Sender, fmt.Sprintf("Could not remove media #%d: %s", pos+1, err.Error()))
+			return
+		}
+		mxNotify(mxcli, "media", ev.Sender, fmt.Sprintf("removed media #%d", pos+1))
+	case cmd == "order" && len(fields) > 1:
+		positions := make([]int, len(fields)-1)
+		for idx, field := range fields[1:] {
+			if positions[idx], err = parseMediaPosition(field, len(entries)); err != nil {
+				mxNotify(mxcli, "media", ev.Sender, err.Error())
+				return
+			}
+		}
+		if err = reorderStagedMedia(ev.Sender, entries, positions); err != nil {
+			mxNotify(mxcli, "media", ev.Sender, fmt.Sprintf("Could not reorder your media: %s", err.Error()))
+			return
+		}
+		entries, _ = getStagedMedia(ev.Sender)
+		mxNotify(mxcli, "media", ev.Sender, "new order:\n"+formatStagedMediaList(entries, time.Now()))
+	default:
+		mxNotify(mxcli, "media", ev.Sender, fmt.Sprintf("Usage: %s list | clear | remove <n> | order <n> <m> ...", c["matrix"]["media_prefix"]))
+	}
+}
+
+// describe the most recently uploaded staged media, or the Nth one with "#N <description>"
+func BotCmdDescribeMedia(mxcli *gomatrix.Client, ev *gomatrix.Event, args string) {
+	lock := getPerUserLock(ev.Sender)
+	lock.Lock()
+	defer lock.Unlock()
+	args = strings.TrimSpace(args)
+	var err error
+	what := "last uploaded media"
+	if strings.HasPrefix(args, "#") {
+		arglist := strings.SplitN(args, " ", 2)
+		var entries []StagedMediaEntry
+		if entries, err = getStagedMedia(ev.Sender); err != nil {
+			mxNotify(mxcli, "imgdesc", ev.Sender, fmt.Sprintf("Could not read your staged media: %s", err.Error()))
+			return
+		}
+		var pos int
+		if pos, err = parseMediaPosition(arglist[0], len(entries)); err != nil || len(arglist) < 2 || len(strings.TrimSpace(arglist[1])) == 0 {
+			mxNotify(mxcli, "imgdesc", ev.Sender, fmt.Sprintf("Usage: %s [#<n>] <description>", c["matrix"]["mediadesc_prefix"]))
+			return
+		}
+		what = fmt.Sprintf("media #%d", pos+1)
+		err = saveMediaFileDescription(ev.Sender, entries[pos].EventID, strings.TrimSpace(arglist[1]))
+	} else if len(args) > 0 {
+		err = addMediaFileDescriptionToLastMediaUpload(ev.Sender, args)
+	} else {
+		mxNotify(mxcli, "imgdesc", ev.Sender, fmt.Sprintf("Usage: %s [#<n>] <description>", c["matrix"]["mediadesc_prefix"]))
+		return
+	}
+	if err != nil {
+		errmsg := fmt.Sprintf("Error saving description: %s", err)
+		mxNotify(mxcli, "imgdesc", ev.Sender, errmsg)
+		log.Println(errmsg)
+		return
+	}
+	mxNotify(mxcli, "imgdesc", ev.Sender, fmt.Sprintf("I attached your description to the %s", what))
+}
+
+// toot post in reply to the status inreplyto, mentioning its author and everyone it mentions. Replies are not tweeted.
+func BotCmdReplyToStatus(mclient *mastodon.Client, rums_store_chan chan<- RUMSStoreMsg, mxcli *gomatrix.Client, ev *gomatrix.Event, post string, opts PostOptions, prefix_visibility string, inreplyto mastodon.ID, markseen_c chan<- mastodon.ID) {
+	if c["server"]["mastodon"] != "true" {
+		mxNotify(mxcli, "reply", ev.Sender, "Replies need mastodon to be enabled")
+		return
+	}
+	parent, err := mclient.GetStatus(context.Background(), inreplyto)
+	if err != nil {
+		mxNotify(mxcli, "reply", ev.Sender, fmt.Sprintf("Not replying! Could not get the status you replied to: %s", err.Error()))
+		return
+	}
+	var myaccountid mastodon.ID
+	if myaccount, err := getMyMastodonAccount(mclient); err == nil {
+		myaccountid = myaccount.ID
+	} else {
+		log.Println("BotCmdReplyToStatus:", err)
+	}
+	post = formatReplyMentions(parent, myaccountid, post) + post
+	opts.Visibility = replyVisibility(opts, prefix_visibility, parent)
+	opts.applyDefaultLanguage(post, ev.Sender, ev.RoomID)
+
+	if err = checkMastodonCharacterLimit(mclient, post, opts.ContentWarning); err != nil {
+		mxNotify(mxcli, "reply", ev.Sender, fmt.Sprintf("Not replying! %s", err.Error()))
+		return
+	}
+
+	lock := getPerUserLock(ev.Sender)
+	lock.Lock()
+	defer lock.Unlock()
+	reviewurl, mastodonid, err := sendToot(mclient, post, ev.Sender, opts, string(inreplyto), true)
+	if markseen_c != nil {
+		markseen_c <- mastodonid
+	}
+	if err != nil {
+		log.Println("MastodonTootERROR:", err)
+		mxNotify(mxcli, "reply", ev.Sender, "ERROR while replying!")
+		return
+	}
+	mxNotify(mxcli, "reply", ev.Sender, fmt.Sprintf("sent reply!%s %s", opts.describe(), reviewurl))
+
+	//remember posted status ID, so the reply can be deleted by redaction
+	rums_store_chan <- RUMSStoreMsg{key: ev.ID, data: MsgStatusData{MatrixUser: ev.Sender, TootID: mastodonid, Action: actionPost}}
+
+	//remove saved image file if present. We only attach an image once.
+	if c.GetValueDefault("images", "enabled", "false") == "true" {
+		rmAllUserFiles(ev.Sender)
+	}
+}
+
+// edit the toot(s) that resulted from the matrix event that ev replaces. Tweets can not be edited.
+func BotCmdEdit(mclient *mastodon.Client, rums_retrieve_chan chan<- RUMSRetrieveMsg, mxcli *gomatrix.Client, ev *gomatrix.Event, replaced_event_id string, new_content map[string]interface{}) {
+	post, _ := getMapDeepString(new_content, "body")
+	spoiler_reason := ""
+	if spoilertext, reason, hasspoiler := getMatrixSpoilerTextAndReason(&gomatrix.Event{Content: new_content}); hasspoiler {
+		post, spoiler_reason = spoilertext, reason
+	}
+	post, ispoll, isposting := stripPostingPrefix(post)
+	if !isposting {
+		// editing some other message is none of our business
+		return
+	}
+
+	future_chan := make(chan *MsgStatusData, 1)
+	rums_retrieve_chan <- RUMSRetrieveMsg{key: replaced_event_id, future: future_chan}
+	rums_ptr := <-future_chan
+	if rums_ptr == nil || rums_ptr.MatrixUser != ev.Sender || (rums_ptr.Action != actionPost && rums_ptr.Action != actionSchedule) {
+		mxNotify(mxcli, "edit", ev.Sender, "Not editing anything. I don't know a toot of yours that resulted from the message you edited.")
+		return
+	}
+	if rums_ptr.Action == actionSchedule {
+		mxNotify(mxcli, "edit", ev.Sender, fmt.Sprintf("Scheduled posts can not be edited. Please redact it and use %s again.", c["matrix"]["schedule_prefix"]))
+		return
+	}
+	if len(rums_ptr.TootID) == 0 {
+		mxNotify(mxcli, "edit", ev.Sender, "Not editing anything. Tweets can not be edited.")
+		return
+	}
+
+	post, opts, err := parsePostOptionsWithSpoiler(post, spoiler_reason)
+	if err == nil && ispoll {
+		post, opts.Poll, err = parsePoll(post, getMastodonPollLimits(mclient))
+	}
+	if err != nil {
+		mxNotify(mxcli, "edit", ev.Sender, fmt.Sprintf("Not editing toot! %s", err.Error()))
+		return
+	}
+	if len(opts.Visibility) > 0 {
+		mxNotify(mxcli, "edit", ev.Sender, "The visibility of a toot can not be changed by editing it, ignoring it.")
+		opts.Visibility = ""
+	}
+	opts.applyDefaultLanguage(post, ev.Sender, ev.RoomID)
+
+	// a thread keeps its number of parts, as we can not add or remove parts of it
+	tootids := append([]mastodon.ID{rums_ptr.TootID}, rums_ptr.ThreadTootIDs...)
+	limits := getMastodonTextLimits(mclient)
+	parts, err := splitPostIntoThread(post, limits.MaxCharacters-limits.statusLength(opts.ContentWarning), limits.statusLength)
+	if err == nil && len(parts) != len(tootids) {
+		err = fmt.Errorf("the edited text would need %d toots instead of %d", len(parts), len(tootids))
+	}
+	if err != nil {
+		mxNotify(mxcli, "edit", ev.Sender, fmt.Sprintf("Not editing toot! %s", err.Error()))
+		return
+	}
+
+	lock := getPerUserLock(ev.Sender)
+	lock.Lock()
+	defer lock.Unlock()
+	var reviewurl string
+	for idx, part := range parts {
+		partopts := opts
+		if idx > 0 {
+			// media and polls belong to the first part only
+			partopts.MediaDescriptions, partopts.Poll = nil, nil
+		}
+		var partreviewurl string
+		if partreviewurl, err = updateToot(mclient, tootids[idx], part, partopts); err != nil {
+			log.Println("MastodonEditERROR:", err)
+			mxNotify(mxcli, "edit", ev.Sender, fmt.Sprintf("Could not edit your toot: %s", err.Error()))
+			return
+		}
+		if idx == 0 {
+			reviewurl = partreviewurl
+		}
+	}
+	if rums_ptr.TweetID > 0 {
+		mxNotify(mxcli, "edit", ev.Sender, fmt.Sprintf("edited toot!%s %s Tweets can not be edited, so your tweet stays as it is.", opts.describe(), reviewurl))
+	} else {
+		mxNotify(mxcli, "edit", ev.Sender, fmt.Sprintf("edited toot!%s %s", opts.describe(), reviewurl))
+	}
+}
+
+// schedule a post, or list, cancel or reschedule scheduled posts
+func BotCmdSchedule(mclient *mastodon.Client, tclient *anaconda.TwitterApi, rums_store_chan chan<- RUMSStoreMsg, mxcli *gomatrix.Client, ev *gomatrix.Event, post string, spoiler_reason string) {
+	now := time.Now()
+	lines := strings.SplitN(post, "\n", 2)
+	args := strings.Fields(lines[0])
+
+	// posts are referred to by their number in the list
+	getScheduledPost := func(numberstr string) (*ScheduledPost, error) {
+		list := post_scheduler_.List()
+		number, err := strconv.Atoi(strings.TrimPrefix(numberstr, "#"))
+		if err != nil || number < 1 || number > len(list) {
+			return nil, fmt.Errorf("there is no scheduled post number %s, see '%s list'", numberstr, c["matrix"]["schedule_prefix"])
+		}
+		sp := &list[number-1]
+		if sp.MatrixUser != ev.Sender && c.GetValueDefault("matrix", "admins_can_redact_user_status", "false") != "true" {
+			return nil, fmt.Errorf("won't change other users scheduled posts for you")
+		}
+		return sp, nil
+	}
+
+	switch {
+	case len(lines) == 1 && len(args) == 1 && strings.ToLower(args[0]) == "list":
+		list := post_scheduler_.List()
+		if len(list) == 0 {
+			mxNotify(mxcli, "schedule", ev.Sender, "there are no scheduled posts")
+			return
+		}
+		text := "scheduled posts:"
+		for idx := range list {
+			text += fmt.Sprintf("\n%d. %s", idx+1, list[idx].describe(now))
+		}
+		mxNotify(mxcli, "schedule", "", text)
+
+	case len(lines) == 1 && len(args) == 2 && strings.ToLower(args[0]) == "cancel":
+		sp, err := getScheduledPost(args[1])
+		if err == nil {
+			_, err = post_scheduler_.Cancel(mclient, sp.EventID)
+		}
+		if err != nil {
+			mxNotify(mxcli, "schedule", ev.Sender, fmt.Sprintf("error cancelling scheduled post: %s", err.Error()))
+		} else {
+			mxNotify(mxcli, "schedule", ev.Sender, "Ok, I cancelled that scheduled post")
+		}
+
+	case len(lines) == 1 && len(args) >= 3 && strings.ToLower(args[0]) == "reschedule":
+		sp, err := getScheduledPost(args[1])
+		var when time.Time
+		if err == nil {
+			when, err = parseScheduleTime(strings.Join(args[2:], " "), now)
+		}
+		if err == nil {
+			err = checkScheduleTime(when, now, len(sp.MastodonScheduledID) > 0)
+		}
+		if err == nil && len(sp.MastodonScheduledID) > 0 {
+			err = rescheduleMastodonScheduledStatus(mclient, sp.MastodonScheduledID, when)
+		}
+		if err != nil {
+			mxNotify(mxcli, "schedule", ev.Sender, fmt.Sprintf("error rescheduling post: %s", err.Error()))
+			return
+		}
+		post_scheduler_.Reschedule(sp.EventID, when)
+		mxNotify(mxcli, "schedule", ev.Sender, fmt.Sprintf("Ok, rescheduled post to %s", when.Format("Mon 2006-01-02 15:04 MST")))
+
+	default:
+		if len(lines) < 2 || len(strings.TrimSpace(lines[1])) == 0 {
+			mxNotify(mxcli, "schedule", ev.Sender, fmt.Sprintf("Please say %s <time>, followed by your post on the next line", c["matrix"]["schedule_prefix"]))
+			return
+		}
+		when, err := parseScheduleTime(lines[0], now)
+		if err == nil {
+			err = checkScheduleTime(when, now, c["server"]["mastodon"] == "true")
+		}
+		if err != nil {
+			mxNotify(mxcli, "schedule", ev.Sender, fmt.Sprintf("Not scheduling this! %s", err.Error()))
+			return
+		}
+		text, opts, err := parsePostOptionsWithSpoiler(strings.TrimSpace(lines[1]), spoiler_reason)
+		if err == nil {
+			opts.applyDefaultVisibility("", ev.Sender, ev.RoomID)
+			opts.applyDefaultLanguage(text, ev.Sender, ev.RoomID)
+			err = checkCharacterLimit(mclient, text, opts)
+		}
+		if err != nil {
+			mxNotify(mxcli, "schedule", ev.Sender, fmt.Sprintf("Not scheduling this! %s", err.Error()))
+			return
+		}
+
+		lock := getPerUserLock(ev.Sender)
+		lock.Lock()
+		defer lock.Unlock()
+
+		sp := &ScheduledPost{EventID: ev.ID, MatrixUser: ev.Sender, Text: text, ContentWarning: opts.ContentWarning, Visibility: opts.Visibility, Language: opts.Language, ScheduledAt: when}
+		if c["server"]["mastodon"] == "true" {
+			//mastodon keeps the staged media we upload now
+			opts.ScheduledAt = &when
+			if _, sp.MastodonScheduledID, err = sendToot(mclient, text, ev.Sender, opts, "", true); err != nil {
+				log.Println("MastodonTootERROR:", err)
+				mxNotify(mxcli, "schedule", ev.Sender, "ERROR while scheduling toot!")
+				return
+			}
+		}
+		if c["server"]["twitter"] == "true" && opts.isTweetable() {
+			sp.TweetPending = true
+			if c.GetValueDefault("images", "enabled", "false") == "true" {
+				if mediapaths, _ := getUserFileList(ev.Sender); len(mediapaths) > 0 {
+					if sp.MediaFiles, err = post_scheduler_.copyMediaFiles(ev.Sender, mediapaths); err != nil {
+						log.Println("BotCmdSchedule: could not keep media for tweet:", err)
+						mxNotify(mxcli, "schedule", ev.Sender, "Could not keep your images for the scheduled tweet")
+					}
+				}
+			}
+		}
+		if len(sp.MastodonScheduledID) == 0 && !sp.TweetPending {
+			mxNotify(mxcli, "schedule", ev.Sender, "Not scheduling this! There is no network to post it to")
+			return
+		}
+		post_scheduler_.Add(sp)
+
+		//redacting the message cancels the scheduled post
+		rums_store_chan <- RUMSStoreMsg{key: ev.ID, data: MsgStatusData{MatrixUser: ev.Sender, TootID: sp.MastodonScheduledID, Action: actionSchedule}}
+		mxNotify(mxcli, "schedule", ev.Sender, fmt.Sprintf("Ok, scheduled your post for %s", sp.describe(now)))
+
+		//remove saved image file if present. We only attach an image once.
+		if c.GetValueDefault("images", "enabled", "false") == "true" {
+			rmAllUserFiles(ev.Sender)
+		}
+	}
+}
+
+// delete the toots and tweets that resulted from a matrix message
+func redactPostedStatuses(mclient *mastodon.Client, tclient *anaconda.TwitterApi, mxcli *gomatrix.Client, ev *gomatrix.Event, rums_ptr *MsgStatusData) {
+	//delete further parts of a thread first, last one first
+	for idx := len(rums_ptr.ThreadTweetIDs) - 1; idx >= 0; idx-- {
+		if _, err := tclient.DeleteTweet(rums_ptr.ThreadTweetIDs[idx], true); err != nil {
+			log.Println("RedactTweetERROR:", err)
+			mxNotify(mxcli, "redaction", ev.Sender, "Could not redact part of your tweet thread")
+		}
+	}
+	for idx := len(rums_ptr.ThreadTootIDs) - 1; idx >= 0; idx-- {
+		if err := mclient.DeleteStatus(context.Background(), rums_ptr.ThreadTootIDs[idx]); err != nil {
+			log.Println("RedactTweetERROR", err)
+			mxNotify(mxcli, "redaction", ev.Sender, "Could not redact part of your toot thread")
+		}
+	}
+	if rums_ptr.TweetID > 0 {
+		if _, err := tclient.DeleteTweet(rums_ptr.TweetID, true); err == nil {
+			mxNotify(mxcli, "redaction", ev.Sender, "Ok, I deleted that tweet for you")
+		} else {
+			log.Println("RedactTweetERROR:", err)
+			mxNotify(mxcli, "redaction", ev.Sender, "Could not redact your tweet")
+		}
+	}
+	if len(rums_ptr.TootID) > 0 {
+		if err := mclient.DeleteStatus(context.Background(), rums_ptr.TootID); err == nil {
+			mxNotify(mxcli, "redaction", ev.Sender, "Ok, I deleted that toot for you")
+		} else {
+			log.Println("RedactTweetERROR", err)
+			mxNotify(mxcli, "redaction", ev.Sender, "Could not redact your toot")
+		}
+	}
+}
+
 func BotCmdRedactStuff(mclient *mastodon.Client, tclient *anaconda.TwitterApi, rums_store_chan chan<- RUMSStoreMsg, rums_retrieve_chan chan<- RUMSRetrieveMsg, mxcli *gomatrix.Client, ev *gomatrix.Event) {
 
 			future_chan := make(chan *MsgStatusData, 1)
 			rums_retrieve_chan <- RUMSRetrieveMsg{key: ev.Redacts, future: future_chan}
 			rums_ptr := <-future_chan
-			if rums_ptr == nil {
+			if rums_ptr == nil || rums_ptr.Action == actionFeedNotice {
+				// notices of the feed are nobody's status
 				return
 			}
 			if c.GetValueDefault("matrix", "admins_can_redact_user_status", "false") == "true" || rums_ptr.MatrixUser == ev.Sender {
 				switch rums_ptr.Action {
 				case actionPost:
-					if rums_ptr.TweetID > 0 {
-						if _, err := tclient.DeleteTweet(rums_ptr.TweetID, true); err == nil {
-							mxNotify(mxcli, "redaction", ev.Sender, "Ok, I deleted that tweet for you")
-						} else {
-							log.Println("RedactTweetERROR:", err)
-							mxNotify(mxcli, "redaction", ev.Sender, "Could not redact your tweet")
-						}
-					}
-					if len(rums_ptr.TootID) > 0 {
-						if err := mclient.DeleteStatus(context.Background(), rums_ptr.TootID); err == nil {
-							mxNotify(mxcli, "redaction", ev.Sender, "Ok, I deleted that toot for you")
+					redactPostedStatuses(mclient, tclient, mxcli, ev, rums_ptr)
+				case actionSchedule:
+					if sp, err := post_scheduler_.Cancel(mclient, ev.Redacts); sp == nil {
+						// it is being published right now, so delete it once we know where it went
+						if published := waitForPublishedScheduledPost(rums_retrieve_chan, ev.Redacts); published != nil {
+							redactPostedStatuses(mclient, tclient, mxcli, ev, published)
 						} else {
-							log.Println("RedactTweetERROR", err)
-							mxNotify(mxcli, "redaction", ev.Sender, "Could not redact your toot")
+							mxNotify(mxcli, "redaction", ev.Sender, "That scheduled post is not pending anymore")
 						}
+					} else if err != nil {
+						log.Println("RedactScheduleERROR", err)
+						mxNotify(mxcli, "redaction", ev.Sender, fmt.Sprintf("Could not cancel your scheduled toot: %s", err.Error()))
+					} else {
+						mxNotify(mxcli, "redaction", ev.Sender, "Ok, I cancelled that scheduled post for you")
 					}
 				case actionReblog:
 					if rums_ptr.TweetID > 0 {
@@ -260,6 +923,26 @@ func BotCmdRedactStuff(mclient *mastodon.Client, tclient *anaconda.TwitterApi, r
 							mxNotify(mxcli, "redaction", ev.Sender, "Could not redact your favour")
 						}
 					}
+				case actionBookmark, actionUnbookmark, actionPin, actionUnpin, actionMuteThread, actionUnmuteThread:
+					reverse := mastodon_status_actions_[rums_ptr.Action].Reverse
+					if err := doMastodonStatusAction(mclient, reverse, rums_ptr.TootID); err == nil {
+						mxNotify(mxcli, "redaction", ev.Sender, "Ok, I "+fmt.Sprintf(mastodon_status_actions_[reverse].Done, "that toot")+" again")
+					} else {
+						log.Println("RedactStatusActionERROR", err)
+						mxNotify(mxcli, "redaction", ev.Sender, fmt.Sprintf("Could not undo that: %s", err.Error()))
+					}
+				case actionFollow, actionUnfollow, actionMuteAccount, actionUnmuteAccount, actionBlock, actionUnblock, actionRemoveFollower:
+					accountaction := mastodon_account_actions_[rums_ptr.Action]
+					if accountaction.Irreversible {
+						mxNotify(mxcli, "redaction", ev.Sender, "Sorry, that can not be undone")
+						break
+					}
+					if relationship, err := doMastodonAccountAction(mclient, accountaction.Reverse, rums_ptr.AccountID, nil); err == nil {
+						mxNotify(mxcli, "redaction", ev.Sender, fmt.Sprintf("Ok, I %s again. Now %s", fmt.Sprintf(mastodon_account_actions_[accountaction.Reverse].Done, "them"), formatRelationship(relationship)))
+					} else {
+						log.Println("RedactAccountActionERROR", err)
+						mxNotify(mxcli, "redaction", ev.Sender, fmt.Sprintf("Could not undo that: %s", err.Error()))
+					}
 
 				}
 			} else {
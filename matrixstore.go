@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/matrix-org/gomatrix"
+)
+
+// A gomatrix.Storer that keeps sync token, filter and room state in state_dir,
+// together with our login session and the IDs of the events we already processed.
+//
+// gomatrix saves the next_batch token before it processes the batch. So we only persist a token
+// once the following batch was requested, i.e. once all events of the batch it follows were processed.
+// After a restart we therefore may see some events again, which we recognize by their remembered IDs.
+// An event counts as processed once all goroutines handling it finished, and a token is not persisted
+// while events are still being handled, so no event gets lost if we crash while handling it.
+//
+// The state file is saved periodically by flushPeriodically. The IDs of processed events are appended to a log right away,
+// which is emptied whenever the state file including them was saved.
+
+const (
+	matrix_state_filename_         = "matrixstate.json"
+	matrix_processed_log_filename_ = "matrixprocessed.log"
+	matrix_processed_events_max_   = 1000
+	matrix_state_save_interval_    = 10 * time.Second
+)
+
+type MatrixSession struct {
+	HomeserverURL string `json:"homeserver_url"`
+	LoginUser     string `json:"login_user"`
+	UserID        string `json:"user_id"`
+	AccessToken   string `json:"access_token"`
+	DeviceID      string `json:"device_id"`
+}
+
+type matrixFileStoreContents struct {
+	Session         MatrixSession             `json:"session"`
+	FilterIDs       map[string]string         `json:"filter_ids"`
+	NextBatch       map[string]string         `json:"next_batch"`
+	Rooms           map[string]*gomatrix.Room `json:"rooms"`
+	ProcessedEvents []string                  `json:"processed_events"`
+}
+
+type MatrixFileStore struct {
+	lock              sync.Mutex
+	filepath          string
+	contents          matrixFileStoreContents
+	pending_nextbatch map[string]string
+	processed_events  map[string]bool
+	events_in_flight  int
+	processed_log     *os.File
+	dirty             bool // contents changed since they were last saved
+}
+
+// the store of the running bot, nil if persistence is disabled
+var matrix_store_ *MatrixFileStore
+
+func loadMatrixFileStore(filepath string) *MatrixFileStore {
+	store := &MatrixFileStore{
+		filepath:          filepath,
+		pending_nextbatch: make(map[string]string),
+		processed_events:  make(map[string]bool),
+	}
+	if contents, err := ioutil.ReadFile(filepath); err == nil {
+		if err = json.Unmarshal(contents, &store.contents); err != nil {
+			log.Println("loadMatrixFileStore: ignoring corrupt file:", err)
+			store.contents = matrixFileStoreContents{}
+		}
+	} else if !os.IsNotExist(err) {
+		log.Println("loadMatrixFileStore:", err)
+	}
+	if store.contents.FilterIDs == nil {
+		store.contents.FilterIDs = make(map[string]string)
+	}
+	if store.contents.NextBatch == nil {
+		store.contents.NextBatch = make(map[string]string)
+	}
+	if store.contents.Rooms == nil {
+		store.contents.Rooms = make(map[string]*gomatrix.Room)
+	}
+	processed := store.contents.ProcessedEvents
+	store.contents.ProcessedEvents = nil
+	logpath := path.Join(path.Dir(filepath), matrix_processed_log_filename_)
+	if fh, err := os.Open(logpath); err == nil {
+		scanner := bufio.NewScanner(fh)
+		for scanner.Scan() {
+			processed = append(processed, scanner.Text())
+		}
+		fh.Close()
+	}
+	for _, eventid := range processed {
+		store.rememberProcessedEvent(eventid)
+	}
+	var err error
+	if store.processed_log, err = os.OpenFile(logpath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600); err != nil {
+		log.Println("loadMatrixFileStore: can not log processed events:", err)
+	}
+	return store
+}
+
+// saves the changed state file every matrix_state_save_interval_, never returns
+func (s *MatrixFileStore) flushPeriodically() {
+	for range time.Tick(matrix_state_save_interval_) {
+		s.Flush()
+	}
+}
+
+// must be called with lock held
+func (s *MatrixFileStore) rememberProcessedEvent(eventid string) {
+	if len(eventid) == 0 || s.processed_events[eventid] {
+		return
+	}
+	s.processed_events[eventid] = true
+	s.contents.ProcessedEvents = append(s.contents.ProcessedEvents, eventid)
+	if len(s.contents.ProcessedEvents) > matrix_processed_events_max_ {
+		num_forget := len(s.contents.ProcessedEvents) - matrix_processed_events_max_
+		for _, forget := range s.contents.ProcessedEvents[:num_forget] {
+			delete(s.processed_events, forget)
+		}
+		s.contents.ProcessedEvents = append([]string(nil), s.contents.ProcessedEvents[num_forget:]...)
+	}
+	s.dirty = true
+}
+
+// save the state file if it changed since it was last saved
+func (s *MatrixFileStore) Flush() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.dirty {
+		s.save()
+	}
+}
+
+// must be called with lock held
+func (s *MatrixFileStore) save() {
+	contents, err := json.Marshal(&s.contents)
+	if err != nil {
+		log.Println("MatrixFileStore.save:", err)
+		return
+	}
+	tmppath := s.filepath + ".tmp"
+	if err = ioutil.WriteFile(tmppath, contents, 0600); err == nil {
+		err = os.Rename(tmppath, s.filepath)
+	}
+	if err != nil {
+		log.Println("MatrixFileStore.save:", err)
+		return
+	}
+	s.dirty = false
+	// the state file now contains all processed events
+	if s.processed_log != nil {
+		if err = s.processed_log.Truncate(0); err != nil {
+			log.Println("MatrixFileStore.save:", err)
+		}
+	}
+}
+
+func (s *MatrixFileStore) SaveFilterID(userID, filterID string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.contents.FilterIDs[userID] = filterID
+	s.dirty = true
+}
+
+func (s *MatrixFileStore) LoadFilterID(userID string) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.contents.FilterIDs[userID]
+}
+
+// called by gomatrix right before the batch following nextBatchToken is processed.
+// Since gomatrix requests the next batch only after processing the previous one,
+// the token saved before is now safe to persist, unless events are still being handled
+func (s *MatrixFileStore) SaveNextBatch(userID, nextBatchToken string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if pending, ok := s.pending_nextbatch[userID]; ok && s.events_in_flight == 0 {
+		s.contents.NextBatch[userID] = pending
+		s.dirty = true
+	}
+	s.pending_nextbatch[userID] = nextBatchToken
+}
+
+func (s *MatrixFileStore) LoadNextBatch(userID string) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if pending, ok := s.pending_nextbatch[userID]; ok {
+		return pending
+	}
+	return s.contents.NextBatch[userID]
+}
+
+func (s *MatrixFileStore) SaveRoom(room *gomatrix.Room) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.contents.Rooms[room.ID] = room
+	s.dirty = true
+}
+
+func (s *MatrixFileStore) LoadRoom(roomID string) *gomatrix.Room {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.contents.Rooms[roomID]
+}
+
+func (s *MatrixFileStore) LoadSession() MatrixSession {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.contents.Session
+}
+
+func (s *MatrixFileStore) SaveSession(session MatrixSession) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.contents.Session = session
+	s.save()
+}
+
+func (s *MatrixFileStore) IsEventProcessed(eventid string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(eventid) > 0 && s.processed_events[eventid]
+}
+
+// an event is being handled, call MarkEventProcessed once that is done
+func (s *MatrixFileStore) StartProcessingEvent() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.events_in_flight++
+}
+
+// remember eventid as processed, after StartProcessingEvent
+func (s *MatrixFileStore) MarkEventProcessed(eventid string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.events_in_flight--
+	if len(eventid) == 0 || s.processed_events[eventid] {
+		return
+	}
+	s.rememberProcessedEvent(eventid)
+	if s.processed_log != nil {
+		if _, err := s.processed_log.WriteString(eventid + "\n"); err != nil {
+			log.Println("MatrixFileStore.MarkEventProcessed:", err)
+		}
+	}
+}
+
+// log into matrix, re-using the access token and device of our last run if possible
+func loginToMatrix(mxcli *gomatrix.Client, store *MatrixFileStore) error {
+	var session MatrixSession
+	if store != nil {
+		session = store.LoadSession()
+	}
+	if len(session.AccessToken) > 0 && session.HomeserverURL == c["matrix"]["url"] && session.LoginUser == c["matrix"]["user"] {
+		mxcli.SetCredentials(session.UserID, session.AccessToken)
+		var whoami struct {
+			UserID string `json:"user_id"`
+		}
+		if err := mxcli.MakeRequest("GET", mxcli.BuildURL("account", "whoami"), nil, &whoami); err == nil && whoami.UserID == session.UserID {
+			log.Println("loginToMatrix: re-using session of device", session.DeviceID)
+			return nil
+		} else {
+			log.Println("loginToMatrix: stored session is no longer valid:", err)
+		}
+		mxcli.ClearCredentials()
+	}
+
+	resp, err := mxcli.Login(&gomatrix.ReqLogin{
+		Type:                     "m.login.password",
+		User:                     c["matrix"]["user"],
+		Password:                 c["matrix"]["password"],
+		DeviceID:                 session.DeviceID,
+		InitialDeviceDisplayName: "mycete",
+	})
+	if err != nil {
+		return err
+	}
+	mxcli.SetCredentials(resp.UserID, resp.AccessToken)
+	if store != nil {
+		store.SaveSession(MatrixSession{
+			HomeserverURL: c["matrix"]["url"],
+			LoginUser:     c["matrix"]["user"],
+			UserID:        resp.UserID,
+			AccessToken:   resp.AccessToken,
+			DeviceID:      resp.DeviceID,
+		})
+	}
+	return nil
+}
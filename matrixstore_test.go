@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"testing"
+)
+
+func TestMatrixFileStorePersistsOnlyProcessedBatches(t *testing.T) {
+	storepath := path.Join(t.TempDir(), matrix_state_filename_)
+	store := loadMatrixFileStore(storepath)
+	store.SaveFilterID("@bot:example.org", "filter1")
+	store.SaveNextBatch("@bot:example.org", "batch1")
+	if got := store.LoadNextBatch("@bot:example.org"); got != "batch1" {
+		t.Errorf("running store returned next batch %q", got)
+	}
+	// batch following batch1 is about to be processed, so the one following the initial sync was done
+	store.SaveNextBatch("@bot:example.org", "batch2")
+	store.Flush()
+
+	store = loadMatrixFileStore(storepath)
+	if got := store.LoadNextBatch("@bot:example.org"); got != "batch1" {
+		t.Errorf("restarted store resumes at %q, want batch1", got)
+	}
+	if got := store.LoadFilterID("@bot:example.org"); got != "filter1" {
+		t.Errorf("filter id after restart is %q", got)
+	}
+}
+
+func TestMatrixFileStoreKeepsBatchOfEventsInFlight(t *testing.T) {
+	storepath := path.Join(t.TempDir(), matrix_state_filename_)
+	store := loadMatrixFileStore(storepath)
+	store.SaveNextBatch("@bot:example.org", "batch1")
+	store.StartProcessingEvent()
+	store.SaveNextBatch("@bot:example.org", "batch2")
+	store.Flush()
+	if got := loadMatrixFileStore(storepath).LoadNextBatch("@bot:example.org"); got != "" {
+		t.Errorf("persisted next batch %q while an event was being handled", got)
+	}
+	store.MarkEventProcessed("$event1")
+	store.SaveNextBatch("@bot:example.org", "batch3")
+	store.Flush()
+	if got := loadMatrixFileStore(storepath).LoadNextBatch("@bot:example.org"); got != "batch2" {
+		t.Errorf("restarted store resumes at %q, want batch2", got)
+	}
+}
+
+func TestMatrixFileStoreRemembersProcessedEvents(t *testing.T) {
+	storepath := path.Join(t.TempDir(), matrix_state_filename_)
+	store := loadMatrixFileStore(storepath)
+	if store.IsEventProcessed("$event1") {
+		t.Fatal("new event reported as processed")
+	}
+	store.StartProcessingEvent()
+	if store.IsEventProcessed("$event1") {
+		t.Error("event reported as processed while it is being handled")
+	}
+	store.MarkEventProcessed("$event1")
+	if !store.IsEventProcessed("$event1") {
+		t.Error("handled event not reported as processed")
+	}
+
+	// without the state file being saved, the processed event is found in the log
+	store = loadMatrixFileStore(storepath)
+	if !store.IsEventProcessed("$event1") {
+		t.Error("event not remembered after restart")
+	}
+	store.Flush()
+	store = loadMatrixFileStore(storepath)
+	if !store.IsEventProcessed("$event1") {
+		t.Error("event not remembered after saving the state file")
+	}
+	for i := 0; i < matrix_processed_events_max_; i++ {
+		store.StartProcessingEvent()
+		store.MarkEventProcessed(fmt.Sprintf("$filler%d", i))
+	}
+	if store.IsEventProcessed("$event1") {
+		t.Error("oldest processed event was not forgotten")
+	}
+}
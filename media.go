@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/btittelbach/anaconda"
+	"github.com/garyburd/go-oauth/oauth"
+	mastodon "github.com/mattn/go-mastodon"
+	"github.com/matrix-org/gomatrix"
+)
+
+/// Videos and audio files are staged like images, but have their own limits
+/// and need to be uploaded in chunks (Twitter) or processed before they can be posted (Mastodon)
+
+const (
+	videobytes_limit_twitter_    int64         = 512 * 1024 * 1024
+	videoduration_limit_twitter_ time.Duration = 140 * time.Second
+	max_video_bytes_             int64         = 200 * 1024 * 1024
+	twitter_upload_chunk_bytes_  int           = 4 * 1024 * 1024
+	mastodon_media_poll_every_   time.Duration = 2 * time.Second
+	mastodon_media_poll_timeout_ time.Duration = 10 * time.Minute
+	twitter_media_poll_timeout_  time.Duration = 10 * time.Minute
+)
+
+var (
+	twitter_video_mimetypes_  = []string{"video/mp4", "video/quicktime"}
+	twitter_media_upload_url_ = anaconda.UploadBaseUrl + "/media/upload.json"
+)
+
+type MastodonMediaLimits struct {
+	VideoSizeLimit     int64
+	ImageMatrixLimit   int64    // pixels, width * height
+	SupportedMimeTypes []string // nil if unknown
+}
+
+// limits of Mastodon itself, used if the instance does not tell us
+var mastodon_default_media_limits_ = MastodonMediaLimits{
+	VideoSizeLimit:   40 * 1024 * 1024,
+	ImageMatrixLimit: 33177600, // 7680x4320
+}
+
+var (
+	mastodon_media_limits_      *MastodonMediaLimits
+	mastodon_media_limits_lock_ sync.Mutex
+)
+
+// ask the instance once for its media limits. Falls back to Mastodon's defaults without caching them in case of error
+func getMastodonMediaLimits(client *mastodon.Client) MastodonMediaLimits {
+	mastodon_media_limits_lock_.Lock()
+	defer mastodon_media_limits_lock_.Unlock()
+	if mastodon_media_limits_ != nil {
+		return *mastodon_media_limits_
+	}
+	limits := mastodon_default_media_limits_
+	instance, err := client.GetInstance(context.Background())
+	if err != nil {
+		log.Println("getMastodonMediaLimits:", err)
+		return limits
+	}
+	if config := instance.GetConfig(); config != nil && config.MediaAttachments != nil {
+		if v, ok := config.MediaAttachments["video_size_limit"].(float64); ok && v > 0 {
+			limits.VideoSizeLimit = int64(v)
+		}
+		if v, ok := config.MediaAttachments["image_matrix_limit"].(float64); ok && v > 0 {
+			limits.ImageMatrixLimit = int64(v)
+		}
+		if types, ok := config.MediaAttachments["supported_mime_types"].([]interface{}); ok {
+			for _, t := range types {
+				if mimetype, ok := t.(string); ok {
+					limits.SupportedMimeTypes = append(limits.SupportedMimeTypes, mimetype)
+				}
+			}
+		}
+	}
+	mastodon_media_limits_ = &limits
+	return limits
+}
+
+func isVideoOrAudioMimetype(mimetype string) bool {
+	return strings.HasPrefix(mimetype, "video/") || strings.HasPrefix(mimetype, "audio/")
+}
+
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// returns why twitter will not take a video or audio file, or "" if it will
+func whyNotTweetable(mimetype string, size int64, duration time.Duration) string {
+	switch {
+	case strings.HasPrefix(mimetype, "audio/"):
+		return "Twitter does not support audio files"
+	case !containsString(twitter_video_mimetypes_, mimetype):
+		return fmt.Sprintf("Twitter does not support videos of type %s", mimetype)
+	case size > videobytes_limit_twitter_:
+		return fmt.Sprintf("video is larger than Twitter's limit of %d bytes", videobytes_limit_twitter_)
+	case duration > videoduration_limit_twitter_:
+		return fmt.Sprintf("video is longer than Twitter's limit of %s", videoduration_limit_twitter_)
+	}
+	return ""
+}
+
+// the limit we download video or audio files up to, whatever their type
+func checkVideoAudioBytesizeLimit(size int64) error {
+	if size > max_video_bytes_ {
+		return fmt.Errorf("File is too large. Please shrink to below %d bytes", max_video_bytes_)
+	}
+	return nil
+}
+
+// check a video or audio file against the limits of enabled networks.
+// If it can be tooted but not tweeted, it is only tooted and warning tells why
+func checkVideoAudioLimits(mclient *mastodon.Client, mimetype string, size int64, duration time.Duration) (warning string, err error) {
+	if !isVideoOrAudioMimetype(mimetype) {
+		return "", fmt.Errorf("unsupported type of media %s", mimetype)
+	}
+	if err := checkVideoAudioBytesizeLimit(size); err != nil {
+		return "", err
+	}
+	if c["server"]["mastodon"] == "true" {
+		limits := getMastodonMediaLimits(mclient)
+		if limits.SupportedMimeTypes != nil && !containsString(limits.SupportedMimeTypes, mimetype) {
+			return "", fmt.Errorf("Mastodon does not support media of type %s", mimetype)
+		}
+		if size > limits.VideoSizeLimit {
+			return "", fmt.Errorf("File too large for Mastodon. Please shrink to below %d bytes", limits.VideoSizeLimit)
+		}
+	}
+	if c["server"]["twitter"] == "true" {
+		if reason := whyNotTweetable(mimetype, size, duration); len(reason) > 0 {
+			if c["server"]["mastodon"] != "true" {
+				return "", fmt.Errorf("%s", reason)
+			}
+			warning = reason + ", so it will only be tooted"
+		}
+	}
+	return warning, nil
+}
+
+// a video or audio file needs to be posted on its own, apart from the media of event eventid itself
+func checkStagedMediaMix(nick, eventid, mimetype string) error {
+	manifest, err := loadStagingManifestFile(getStagingManifestPath(nick))
+	if err != nil {
+		return nil // nothing we can check
+	}
+	for _, entry := range manifest.Entries {
+		if entry.EventID == eventid {
+			continue
+		}
+		if isVideoOrAudioMimetype(mimetype) || isVideoOrAudioMimetype(entry.Mimetype) {
+			return fmt.Errorf("a video or audio file can not be posted together with other media. Post or redact the media you uploaded before first")
+		}
+	}
+	return nil
+}
+
+// size, mimetype and duration of media as given in the info of a matrix event. Zero if not given
+func getMatrixMediaInfo(ev *gomatrix.Event) (size int64, mimetype string, duration time.Duration) {
+	// json numbers are float64
+	if v, ok := getMapDeepValue(ev.Content, "info", "size").(float64); ok {
+		size = int64(v)
+	}
+	mimetype, _ = getMapDeepString(ev.Content, "info", "mimetype")
+	if v, ok := getMapDeepValue(ev.Content, "info", "duration").(float64); ok {
+		duration = time.Duration(v) * time.Millisecond
+	}
+	return
+}
+
+// mimetype and duration of a staged media file as noted in the manifest, else mimetype is guessed from the contents
+func getStagedMediaFileInfo(mediapath string) (mimetype string, duration time.Duration) {
+	// media files are in a subdirectory of the user's directory, next to the manifest
+	if manifest, err := loadStagingManifestFile(path.Join(path.Dir(path.Dir(mediapath)), staging_manifest_filename_)); err == nil {
+		if idx := manifest.findByMediaPath(mediapath); idx >= 0 && len(manifest.Entries[idx].Mimetype) > 0 {
+			return manifest.Entries[idx].Mimetype, manifest.Entries[idx].Duration
+		}
+	}
+	// copies of staged media, like those kept for scheduled posts, have their manifest right next to them
+	if manifest, err := loadStagingManifestFile(path.Join(path.Dir(mediapath), staging_manifest_filename_)); err == nil {
+		if idx := manifest.findByMediaFileName(path.Base(mediapath)); idx >= 0 && len(manifest.Entries[idx].Mimetype) > 0 {
+			return manifest.Entries[idx].Mimetype, manifest.Entries[idx].Duration
+		}
+	}
+	return detectMediaFileMimetype(mediapath), 0
+}
+
+// guess the mimetype of a media file from its contents
+func detectMediaFileMimetype(mediapath string) string {
+	f, err := os.Open(mediapath)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	return http.DetectContentType(head[:n])
+}
+
+// check a downloaded video or audio file whose event did not tell its mimetype, and note the mimetype we found in the manifest
+func checkDownloadedVideoAudio(mclient *mastodon.Client, nick, eventid string, duration time.Duration) (warning string, err error) {
+	_, mediapath := hashNickAndTypeAndEventIdToPath(nick, uploadfile_type_media_, eventid)
+	fi, err := os.Stat(mediapath)
+	if err != nil {
+		return "", err
+	}
+	// the manifest has the Content-Type of the download, which may be as vague as application/octet-stream
+	mimetype, _ := getStagedMediaFileInfo(mediapath)
+	if !isVideoOrAudioMimetype(mimetype) {
+		mimetype = detectMediaFileMimetype(mediapath)
+	}
+	if warning, err = checkVideoAudioLimits(mclient, mimetype, fi.Size(), duration); err != nil {
+		return "", err
+	}
+	if err = checkStagedMediaMix(nick, eventid, mimetype); err != nil {
+		return "", err
+	}
+	return warning, addStagedMediaToManifest(nick, eventid, mimetype, duration)
+}
+
+// wait until mastodon finished processing an uploaded video or audio file, which it does asynchronously
+func waitForMastodonMediaProcessing(client *mastodon.Client, attachment *mastodon.Attachment) error {
+	deadline := time.Now().Add(mastodon_media_poll_timeout_)
+	for len(attachment.URL) == 0 {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("mastodon took too long to process media %s", attachment.ID)
+		}
+		time.Sleep(mastodon_media_poll_every_)
+		// 206 Partial Content means still processing
+		statuscode, err := mastodonAPIRequestWithStatusCode(context.Background(), client, http.MethodGet, "/api/v1/media/"+url.PathEscape(string(attachment.ID)), nil, attachment)
+		if err != nil {
+			return err
+		}
+		if statuscode == http.StatusOK && len(attachment.URL) == 0 {
+			return fmt.Errorf("mastodon failed to process media %s", attachment.ID)
+		}
+	}
+	return nil
+}
+
+// state of a chunked upload, as twitter reports it on INIT, FINALIZE and STATUS
+type twitterChunkedUpload struct {
+	MediaIDString  string `json:"media_id_string"`
+	ProcessingInfo *struct {
+		State          string `json:"state"` // pending, in_progress, failed or succeeded
+		CheckAfterSecs int    `json:"check_after_secs"`
+		Error          *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	} `json:"processing_info"`
+}
+
+// send a command of twitter's chunked media upload. anaconda can neither set the media_category,
+// without which twitter limits videos to 15MB and 30s, nor ask for the STATUS, so we sign the requests ourselves
+func twitterMediaUploadRequest(client *anaconda.TwitterApi, method string, form url.Values, result *twitterChunkedUpload) error {
+	oauthclient := oauth.Client{Credentials: oauth.Credentials{Token: c["twitter"]["consumer_key"], Secret: c["twitter"]["consumer_secret"]}}
+	var resp *http.Response
+	var err error
+	if method == http.MethodGet {
+		resp, err = oauthclient.Get(client.HttpClient, client.Credentials, twitter_media_upload_url_, form)
+	} else {
+		resp, err = oauthclient.Post(client.HttpClient, client.Credentials, twitter_media_upload_url_, form)
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("twitter media upload %s failed with %s: %s", form.Get("command"), resp.Status, body)
+	}
+	if result == nil || len(body) == 0 {
+		return nil
+	}
+	return json.Unmarshal(body, result)
+}
+
+// upload a video file to twitter in chunks and wait until twitter processed it
+func uploadVideoForTweet(client *anaconda.TwitterApi, mediapath, mimetype string) (string, error) {
+	contents, err := os.ReadFile(mediapath)
+	if err != nil {
+		return "", err
+	}
+	var upload twitterChunkedUpload
+	err = twitterMediaUploadRequest(client, http.MethodPost, url.Values{
+		"command":        {"INIT"},
+		"media_type":     {mimetype},
+		"media_category": {"tweet_video"},
+		"total_bytes":    {strconv.Itoa(len(contents))},
+	}, &upload)
+	if err != nil {
+		return "", err
+	}
+	for segment := 0; segment*twitter_upload_chunk_bytes_ < len(contents); segment++ {
+		end := (segment + 1) * twitter_upload_chunk_bytes_
+		if end > len(contents) {
+			end = len(contents)
+		}
+		err = twitterMediaUploadRequest(client, http.MethodPost, url.Values{
+			"command":       {"APPEND"},
+			"media_id":      {upload.MediaIDString},
+			"media_data":    {base64.StdEncoding.EncodeToString(contents[segment*twitter_upload_chunk_bytes_ : end])},
+			"segment_index": {strconv.Itoa(segment)},
+		}, nil)
+		if err != nil {
+			return "", err
+		}
+	}
+	err = twitterMediaUploadRequest(client, http.MethodPost, url.Values{"command": {"FINALIZE"}, "media_id": {upload.MediaIDString}}, &upload)
+	if err != nil {
+		return "", err
+	}
+	if err = waitForTwitterMediaProcessing(client, &upload); err != nil {
+		return "", err
+	}
+	return upload.MediaIDString, nil
+}
+
+// wait until twitter finished processing an uploaded video, which it does asynchronously if FINALIZE reports processing_info
+func waitForTwitterMediaProcessing(client *anaconda.TwitterApi, upload *twitterChunkedUpload) error {
+	deadline := time.Now().Add(twitter_media_poll_timeout_)
+	for upload.ProcessingInfo != nil && upload.ProcessingInfo.State != "succeeded" {
+		if upload.ProcessingInfo.State == "failed" {
+			reason := "unknown error"
+			if upload.ProcessingInfo.Error != nil {
+				reason = upload.ProcessingInfo.Error.Message
+			}
+			return fmt.Errorf("twitter failed to process media %s: %s", upload.MediaIDString, reason)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("twitter took too long to process media %s", upload.MediaIDString)
+		}
+		wait := time.Duration(upload.ProcessingInfo.CheckAfterSecs) * time.Second
+		if wait < time.Second {
+			wait = time.Second
+		}
+		time.Sleep(wait)
+		var status twitterChunkedUpload
+		if err := twitterMediaUploadRequest(client, http.MethodGet, url.Values{"command": {"STATUS"}, "media_id": {upload.MediaIDString}}, &status); err != nil {
+			return err
+		}
+		upload.ProcessingInfo = status.ProcessingInfo
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btittelbach/anaconda"
+	"github.com/gokyle/goconfig"
+	"github.com/matrix-org/gomatrix"
+)
+
+func TestWhyNotTweetable(t *testing.T) {
+	if reason := whyNotTweetable("video/mp4", 1024, time.Minute); reason != "" {
+		t.Errorf("short mp4 video not tweetable: %s", reason)
+	}
+	for _, media := range []struct {
+		mimetype string
+		duration time.Duration
+	}{
+		{"audio/mpeg", time.Minute},
+		{"video/webm", time.Minute},
+		{"video/mp4", 3 * time.Minute},
+	} {
+		if reason := whyNotTweetable(media.mimetype, 1024, media.duration); reason == "" {
+			t.Errorf("%s of %s was deemed tweetable", media.mimetype, media.duration)
+		}
+	}
+}
+
+func TestVideoIsStagedOnItsOwn(t *testing.T) {
+	temp_image_files_dir_ = t.TempDir()
+	feed2matrx_image_count_limit_ = 4
+	nick := "@alice:example.org"
+
+	if err := checkStagedMediaMix(nick, "$new", "video/mp4"); err != nil {
+		t.Errorf("video rejected although nothing is staged: %s", err)
+	}
+	stageTestMediaFile(t, nick, "$image")
+	if err := checkStagedMediaMix(nick, "$new", "image/jpeg"); err != nil {
+		t.Errorf("second image rejected: %s", err)
+	}
+	if err := checkStagedMediaMix(nick, "$new", "video/mp4"); err == nil {
+		t.Error("video accepted together with an image")
+	}
+	rmAllUserFiles(nick)
+	_, mediapath := hashNickAndTypeAndEventIdToPath(nick, uploadfile_type_media_, "$video")
+	stageTestMediaFile(t, nick, "$video")
+	addStagedMediaToManifest(nick, "$video", "video/mp4", 30*time.Second)
+	if err := checkStagedMediaMix(nick, "$new", "image/jpeg"); err == nil {
+		t.Error("image accepted together with a video")
+	}
+	if mimetype, duration := getStagedMediaFileInfo(mediapath); mimetype != "video/mp4" || duration != 30*time.Second {
+		t.Errorf("unexpected info of staged video: %s %s", mimetype, duration)
+	}
+}
+
+func TestCheckDownloadedVideoWithoutMimetype(t *testing.T) {
+	temp_image_files_dir_ = t.TempDir()
+	feed2matrx_image_count_limit_ = 4
+	useTestConfig(t, goconfig.ConfigMap{})
+	nick := "@alice:example.org"
+
+	// as saved by saveMatrixFile if the media repository did not know the type either
+	mp4 := []byte("\x00\x00\x00\x18ftypmp42\x00\x00\x00\x00mp42isom")
+	mediapath := stageTestMediaFile(t, nick, "$video")
+	os.WriteFile(mediapath, mp4, 0600)
+	addStagedMediaToManifest(nick, "$video", "application/octet-stream", 0)
+	if _, err := checkDownloadedVideoAudio(nil, nick, "$video", 30*time.Second); err != nil {
+		t.Fatalf("mp4 video rejected: %s", err)
+	}
+	if mimetype, duration := getStagedMediaFileInfo(mediapath); mimetype != "video/mp4" || duration != 30*time.Second {
+		t.Errorf("unexpected info of staged video: %s %s", mimetype, duration)
+	}
+
+	stageTestMediaFile(t, nick, "$notavideo")
+	if _, err := checkDownloadedVideoAudio(nil, nick, "$notavideo", 0); err == nil {
+		t.Error("file that is no video was accepted")
+	}
+}
+
+func TestGetMatrixMediaInfo(t *testing.T) {
+	ev := &gomatrix.Event{Content: map[string]interface{}{
+		"msgtype": "m.video",
+		"info":    map[string]interface{}{"size": float64(123456), "mimetype": "video/mp4", "duration": float64(61500)},
+	}}
+	size, mimetype, duration := getMatrixMediaInfo(ev)
+	if size != 123456 || mimetype != "video/mp4" || duration != 61500*time.Millisecond {
+		t.Errorf("unexpected info %d %s %s", size, mimetype, duration)
+	}
+}
+
+// a twitter upload endpoint that processes videos for one STATUS request, or fails to process them
+func useTestTwitterUploadServer(t *testing.T, processing string) (commands *[]string) {
+	commands = new([]string)
+	var lock sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		lock.Lock()
+		*commands = append(*commands, r.Method+" "+r.Form.Get("command"))
+		lock.Unlock()
+		switch r.Form.Get("command") {
+		case "INIT":
+			if r.Form.Get("media_category") != "tweet_video" {
+				t.Errorf("video uploaded with media_category %q", r.Form.Get("media_category"))
+			}
+			w.Write([]byte(`{"media_id_string": "4711"}`))
+		case "APPEND":
+			w.WriteHeader(http.StatusNoContent)
+		case "FINALIZE":
+			w.Write([]byte(`{"media_id_string": "4711", "processing_info": {"state": "` + processing + `", "check_after_secs": 1}}`))
+		case "STATUS":
+			w.Write([]byte(`{"media_id_string": "4711", "processing_info": {"state": "succeeded", "progress_percent": 100}}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	saved := twitter_media_upload_url_
+	twitter_media_upload_url_ = server.URL
+	t.Cleanup(func() { twitter_media_upload_url_ = saved })
+	useTestConfig(t, goconfig.ConfigMap{"twitter": {"consumer_key": "key", "consumer_secret": "secret"}})
+	return
+}
+
+func TestUploadVideoForTweetWaitsForProcessing(t *testing.T) {
+	commands := useTestTwitterUploadServer(t, "pending")
+	videopath := path.Join(t.TempDir(), "video")
+	os.WriteFile(videopath, []byte("not really a video"), 0600)
+	mediaid, err := uploadVideoForTweet(anaconda.NewTwitterApiWithCredentials("token", "secret", "key", "secret"), videopath, "video/mp4")
+	if err != nil || mediaid != "4711" {
+		t.Fatalf("uploadVideoForTweet = %q, %v", mediaid, err)
+	}
+	if expected := []string{"POST INIT", "POST APPEND", "POST FINALIZE", "GET STATUS"}; fmt.Sprint(*commands) != fmt.Sprint(expected) {
+		t.Errorf("sent commands %v, expected %v", *commands, expected)
+	}
+}
+
+func TestUploadVideoForTweetFailsIfProcessingFails(t *testing.T) {
+	useTestTwitterUploadServer(t, "failed")
+	videopath := path.Join(t.TempDir(), "video")
+	os.WriteFile(videopath, []byte("not really a video"), 0600)
+	if _, err := uploadVideoForTweet(anaconda.NewTwitterApiWithCredentials("token", "secret", "key", "secret"), videopath, "video/mp4"); err == nil {
+		t.Error("video that twitter failed to process was accepted")
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/// Mastodon crops previews of images around their focal point.
+/// It is given as x,y between -1.0 and 1.0, with 0,0 being the center, 1,1 the top right corner.
+/// The focal point of a staged image is kept in a file next to its description.
+
+const uploadfile_type_focus_ = "focus"
+
+var media_focus_names_ = map[string][2]float64{
+	"center":       {0, 0},
+	"top":          {0, 1},
+	"bottom":       {0, -1},
+	"left":         {-1, 0},
+	"right":        {1, 0},
+	"top-left":     {-1, 1},
+	"top-right":    {1, 1},
+	"bottom-left":  {-1, -1},
+	"bottom-right": {1, -1},
+}
+
+// parse a focal point like "0.5,-0.3", "0.5 -0.3" or "top-left" into the "x,y" mastodon expects
+func parseMediaFocus(s string) (string, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if xy, inmap := media_focus_names_[s]; inmap {
+		return fmt.Sprintf("%.2f,%.2f", xy[0], xy[1]), nil
+	}
+	parts := strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' })
+	if len(parts) != 2 {
+		return "", fmt.Errorf("expected focal point as x,y or one of center, top, bottom, left, right, top-left, top-right, bottom-left, bottom-right")
+	}
+	var xy [2]float64
+	for idx, part := range parts {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil || v < -1.0 || v > 1.0 {
+			return "", fmt.Errorf("focal point coordinates must be numbers between -1.0 and 1.0, got '%s'", part)
+		}
+		xy[idx] = v
+	}
+	return fmt.Sprintf("%.2f,%.2f", xy[0], xy[1]), nil
+}
+
+func saveMediaFileFocus(nick, eventid_of_related_img, focus string) error {
+	_, imgfilepath := hashNickAndTypeAndEventIdToPath(nick, uploadfile_type_media_, eventid_of_related_img)
+	if _, err := os.Stat(imgfilepath); os.IsNotExist(err) {
+		return fmt.Errorf("corresponding media file does not exist")
+	}
+	if err := saveMediaFileFocusFile(nick, eventid_of_related_img, focus); err != nil {
+		return err
+	}
+	return modifyStagingManifest(nick, func(m *StagedMediaManifest) {
+		if idx := m.find(eventid_of_related_img); idx >= 0 {
+			m.Entries[idx].Focus = focus
+		}
+	})
+}
+
+// write focus file, without any checks
+func saveMediaFileFocusFile(nick, eventid_of_related_img, focus string) error {
+	filesdir, focusfilepath := hashNickAndTypeAndEventIdToPath(nick, uploadfile_type_focus_, eventid_of_related_img)
+	os.MkdirAll(filesdir, 0700)
+	return replaceFileContents(focusfilepath, []byte(focus))
+}
+
+// returns "" if no focal point was set
+func readFocusOfMediaFile(imgfilepath string) string {
+	focusfile, err := getRelatedFilenameOfMediaFilename(imgfilepath, uploadfile_type_focus_)
+	if err != nil {
+		return ""
+	}
+	focus, err := os.ReadFile(focusfile)
+	if err != nil {
+		return ""
+	}
+	return string(focus)
+}
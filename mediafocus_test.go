@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseMediaFocus(t *testing.T) {
+	for _, tc := range []struct {
+		in, out string
+		ok      bool
+	}{
+		{"0.5,-0.3", "0.50,-0.30", true},
+		{" -1 1 ", "-1.00,1.00", true},
+		{"Top-Left", "-1.00,1.00", true},
+		{"center", "0.00,0.00", true},
+		{"1.5,0", "", false},
+		{"0.5", "", false},
+		{"up", "", false},
+	} {
+		out, err := parseMediaFocus(tc.in)
+		if (err == nil) != tc.ok || out != tc.out {
+			t.Errorf("parseMediaFocus(%q) = %q, %v", tc.in, out, err)
+		}
+	}
+}
+
+func TestMediaFocusIsSavedAndRestored(t *testing.T) {
+	temp_image_files_dir_ = t.TempDir()
+	nick := "@alice:example.org"
+
+	if err := saveMediaFileFocus(nick, "$img", "0.10,0.20"); err == nil {
+		t.Error("focus of media that was not staged should not be saved")
+	}
+	mediapath := stageTestMediaFile(t, nick, "$img")
+	if focus := readFocusOfMediaFile(mediapath); focus != "" {
+		t.Errorf("expected no focus, got %q", focus)
+	}
+	if err := saveMediaFileFocus(nick, "$img", "0.10,0.20"); err != nil {
+		t.Fatal(err)
+	}
+	if focus := readFocusOfMediaFile(mediapath); focus != "0.10,0.20" {
+		t.Errorf("expected saved focus, got %q", focus)
+	}
+
+	// simulate focus file lost during restart
+	focuspath, _ := getRelatedFilenameOfMediaFilename(mediapath, uploadfile_type_focus_)
+	os.Remove(focuspath)
+	reloadAndCleanStagingDir(2 * time.Hour)
+	if focus := readFocusOfMediaFile(mediapath); focus != "0.10,0.20" {
+		t.Errorf("focus was not restored from manifest, got %q", focus)
+	}
+
+	rmFile(nick, "$img")
+	if _, err := os.Stat(focuspath); !os.IsNotExist(err) {
+		t.Error("focus file was not removed with its media")
+	}
+}
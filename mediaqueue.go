@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/// The media a user staged for the next post, in the order it will be attached.
+/// The order is that of the manifest: order of upload, unless the user reordered it.
+
+// entries of staged media of nick whose file still exists, in order of attachment
+func getStagedMedia(nick string) ([]StagedMediaEntry, error) {
+	manifest, err := loadStagingManifestFile(getStagingManifestPath(nick))
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]StagedMediaEntry, 0, len(manifest.Entries))
+	for _, entry := range manifest.Entries {
+		_, mediapath := hashNickAndTypeAndEventIdToPath(nick, uploadfile_type_media_, entry.EventID)
+		if _, err := os.Stat(mediapath); err == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// parse a 1-based position like "2" or "#2" in a list of n entries into an index
+func parseMediaPosition(s string, n int) (int, error) {
+	pos, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(s), "#"))
+	if err != nil || pos < 1 || pos > n {
+		if n == 0 {
+			return 0, fmt.Errorf("no media is staged")
+		}
+		return 0, fmt.Errorf("'%s' is not a position between 1 and %d", s, n)
+	}
+	return pos - 1, nil
+}
+
+func formatMediaAge(age time.Duration) string {
+	switch {
+	case age < time.Minute:
+		return "just now"
+	case age < time.Hour:
+		return fmt.Sprintf("%d min ago", int(age.Minutes()))
+	default:
+		return fmt.Sprintf("%dh %dmin ago", int(age.Hours()), int(age.Minutes())%60)
+	}
+}
+
+func formatStagedMediaList(entries []StagedMediaEntry, now time.Time) string {
+	if len(entries) == 0 {
+		return "no media staged"
+	}
+	lines := make([]string, len(entries))
+	for idx, entry := range entries {
+		description := "no description"
+		if len(entry.Description) > 0 {
+			description = "\"" + entry.Description + "\""
+		}
+		mimetype := entry.Mimetype
+		if len(mimetype) == 0 {
+			mimetype = "media"
+		}
+		lines[idx] = fmt.Sprintf("%d. %s, uploaded %s, %s", idx+1, mimetype, formatMediaAge(now.Sub(entry.Uploaded)), description)
+		if len(entry.Focus) > 0 {
+			lines[idx] += ", focal point " + entry.Focus
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// move staged media to the front in the order of positions (indices into entries), the rest keeps its order after them
+func reorderStagedMedia(nick string, entries []StagedMediaEntry, positions []int) error {
+	ordered := make([]string, 0, len(entries))
+	seen := make(map[int]bool)
+	for _, pos := range positions {
+		if seen[pos] {
+			return fmt.Errorf("position %d given twice", pos+1)
+		}
+		seen[pos] = true
+		ordered = append(ordered, entries[pos].EventID)
+	}
+	for pos, entry := range entries {
+		if !seen[pos] {
+			ordered = append(ordered, entry.EventID)
+		}
+	}
+	return modifyStagingManifest(nick, func(m *StagedMediaManifest) {
+		reordered := make([]StagedMediaEntry, 0, len(m.Entries))
+		for _, eventid := range ordered {
+			if idx := m.find(eventid); idx >= 0 {
+				reordered = append(reordered, m.Entries[idx])
+			}
+		}
+		// keep entries whose file vanished meanwhile, reloadAndCleanStagingDir will drop them
+		for _, entry := range m.Entries {
+			if !containsString(ordered, entry.EventID) {
+				reordered = append(reordered, entry)
+			}
+		}
+		m.Entries = reordered
+	})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMediaPosition(t *testing.T) {
+	for _, tc := range []struct {
+		in  string
+		n   int
+		idx int
+		ok  bool
+	}{
+		{"1", 3, 0, true},
+		{"#3", 3, 2, true},
+		{"4", 3, 0, false},
+		{"0", 3, 0, false},
+		{"first", 3, 0, false},
+		{"1", 0, 0, false},
+	} {
+		idx, err := parseMediaPosition(tc.in, tc.n)
+		if (err == nil) != tc.ok || idx != tc.idx {
+			t.Errorf("parseMediaPosition(%q, %d) = %d, %v", tc.in, tc.n, idx, err)
+		}
+	}
+}
+
+func TestStagedMediaOrder(t *testing.T) {
+	temp_image_files_dir_ = t.TempDir()
+	feed2matrx_image_count_limit_ = 4
+	nick := "@alice:example.org"
+
+	var paths []string
+	for _, eventid := range []string{"$a", "$b", "$c"} {
+		paths = append(paths, stageTestMediaFile(t, nick, eventid))
+	}
+	// a file that never made it into the manifest comes last
+	_, strayfile := hashNickAndTypeAndEventIdToPath(nick, uploadfile_type_media_, "$stray")
+	if err := os.WriteFile(strayfile, []byte("stray"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	checkOrder := func(expected []string) {
+		t.Helper()
+		filelist, err := getUserFileList(nick)
+		if err != nil || strings.Join(filelist, " ") != strings.Join(expected, " ") {
+			t.Errorf("expected files in order %v, got %v %v", expected, filelist, err)
+		}
+	}
+	checkOrder(append(paths, strayfile))
+
+	entries, err := getStagedMedia(nick)
+	if err != nil || len(entries) != 3 {
+		t.Fatal("expected 3 staged media, got", entries, err)
+	}
+	if err = reorderStagedMedia(nick, entries, []int{2, 0}); err != nil {
+		t.Fatal(err)
+	}
+	checkOrder([]string{paths[2], paths[0], paths[1], strayfile})
+	if err = reorderStagedMedia(nick, entries, []int{1, 1}); err == nil {
+		t.Error("expected error for position given twice")
+	}
+
+	rmFile(nick, "$a")
+	entries, _ = getStagedMedia(nick)
+	if list := formatStagedMediaList(entries, time.Now()); !strings.HasPrefix(list, "1. image/png, uploaded just now, no description\n2.") {
+		t.Errorf("unexpected list of staged media: %q", list)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/matrix-org/gomatrix"
+	mastodon "github.com/mattn/go-mastodon"
+)
+
+/// Results of our polls are shown in the control room once they closed, unless Mastodon's notifications are shown there anyway.
+/// Polls whose results are still to be shown are kept in state_dir, so the results are not lost on a restart.
+
+const (
+	poll_results_filename_ = "pollresults.json"
+	poll_results_delay_    = time.Minute
+	// after failing to get the results, e.g. because the instance was unreachable
+	poll_results_retry_delay_ = 5 * time.Minute
+)
+
+type PendingPollResult struct {
+	StatusID   mastodon.ID `json:"status_id"`
+	MatrixUser string      `json:"matrix_user"`
+	ReviewURL  string      `json:"review_url"`
+	DueAt      time.Time   `json:"due_at"`
+}
+
+type PollResultNotifier struct {
+	lock     sync.Mutex
+	filepath string
+	pending  []*PendingPollResult // sorted by DueAt
+	wakeup_c chan struct{}
+}
+
+var poll_result_notifier_ *PollResultNotifier
+
+// load polls whose results are pending from state_dir if persistence is enabled
+func loadPollResultNotifier() *PollResultNotifier {
+	pn := &PollResultNotifier{wakeup_c: make(chan struct{}, 1)}
+	if len(persistence_state_dir_) == 0 {
+		return pn
+	}
+	pn.filepath = path.Join(persistence_state_dir_, poll_results_filename_)
+	contents, err := ioutil.ReadFile(pn.filepath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("loadPollResultNotifier:", err)
+		}
+		return pn
+	}
+	if err = json.Unmarshal(contents, &pn.pending); err != nil {
+		log.Println("loadPollResultNotifier: ignoring corrupt file:", err)
+		pn.pending = nil
+	}
+	sort.SliceStable(pn.pending, func(i, j int) bool { return pn.pending[i].DueAt.Before(pn.pending[j].DueAt) })
+	return pn
+}
+
+// must be called with lock held
+func (pn *PollResultNotifier) save() {
+	sort.SliceStable(pn.pending, func(i, j int) bool { return pn.pending[i].DueAt.Before(pn.pending[j].DueAt) })
+	if len(pn.filepath) == 0 {
+		return
+	}
+	contents, err := json.Marshal(pn.pending)
+	if err != nil {
+		log.Println("PollResultNotifier.save:", err)
+		return
+	}
+	tmppath := pn.filepath + ".tmp"
+	if err = ioutil.WriteFile(tmppath, contents, 0600); err == nil {
+		err = os.Rename(tmppath, pn.filepath)
+	}
+	if err != nil {
+		log.Println("PollResultNotifier.save:", err)
+	}
+}
+
+func (pn *PollResultNotifier) wakeup() {
+	select {
+	case pn.wakeup_c <- struct{}{}:
+	default:
+	}
+}
+
+// show the results of the poll in status id to matrixuser once it closed at closesat
+func (pn *PollResultNotifier) Add(id mastodon.ID, matrixuser, reviewurl string, closesat time.Time) {
+	pn.requeue(&PendingPollResult{StatusID: id, MatrixUser: matrixuser, ReviewURL: reviewurl}, closesat.Add(poll_results_delay_))
+}
+
+// show the results of a poll taken from the queue at dueat instead
+func (pn *PollResultNotifier) requeue(pr *PendingPollResult, dueat time.Time) {
+	pr.DueAt = dueat
+	pn.lock.Lock()
+	pn.pending = append(pn.pending, pr)
+	pn.save()
+	pn.lock.Unlock()
+	pn.wakeup()
+}
+
+// remove and return due poll results, as well as how long to wait for the next one
+func (pn *PollResultNotifier) takeDue(now time.Time) (due []*PendingPollResult, wait time.Duration) {
+	pn.lock.Lock()
+	defer pn.lock.Unlock()
+	for len(pn.pending) > 0 && !pn.pending[0].DueAt.After(now) {
+		due = append(due, pn.pending[0])
+		pn.pending = pn.pending[1:]
+	}
+	if len(due) > 0 {
+		pn.save()
+	}
+	wait = scheduler_max_sleep_
+	if len(pn.pending) > 0 && pn.pending[0].DueAt.Sub(now) < wait {
+		wait = pn.pending[0].DueAt.Sub(now)
+	}
+	return
+}
+
+func (pn *PollResultNotifier) runPollResultNotifier(mclient *mastodon.Client, mxcli *gomatrix.Client) {
+	for {
+		due, wait := pn.takeDue(time.Now())
+		for _, pr := range due {
+			status, err := mclient.GetStatus(context.Background(), pr.StatusID)
+			if err != nil && !isMastodonNotFoundError(err) {
+				log.Println("runPollResultNotifier: could not get results of poll, trying again later", pr.StatusID, err)
+				pn.requeue(pr, time.Now().Add(poll_results_retry_delay_))
+				continue
+			}
+			if err != nil || status.Poll == nil {
+				// the poll was deleted, or edited into a status without poll
+				log.Println("runPollResultNotifier: poll is gone", pr.StatusID, err)
+				continue
+			}
+			if !status.Poll.Expired && status.Poll.ExpiresAt.After(time.Now()) {
+				// editing the poll restarted it
+				pn.requeue(pr, status.Poll.ExpiresAt.Add(poll_results_delay_))
+				continue
+			}
+			results, _ := formatPollResultsForMatrix(status.Poll)
+			mxNotify(mxcli, "poll", pr.MatrixUser, fmt.Sprintf("the result of your poll is in: %s%s", pr.ReviewURL, results))
+		}
+		select {
+		case <-time.After(wait):
+		case <-pn.wakeup_c:
+		}
+	}
+}
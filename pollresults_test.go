@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	mastodon "github.com/mattn/go-mastodon"
+)
+
+func TestPollResultNotifierSurvivesRestart(t *testing.T) {
+	persistence_state_dir_ = t.TempDir()
+	defer func() { persistence_state_dir_ = "" }()
+	now := time.Now()
+
+	pn := loadPollResultNotifier()
+	pn.Add("2", "@alice:example.org", "https://chaos.social/@qbit/2", now.Add(3*24*time.Hour))
+	pn.Add("1", "@bob:example.org", "https://chaos.social/@qbit/1", now.Add(time.Hour))
+
+	pn = loadPollResultNotifier()
+	due, wait := pn.takeDue(now)
+	if len(due) != 0 || wait != scheduler_max_sleep_ {
+		t.Errorf("got %d due results and wait %s before any poll closed", len(due), wait)
+	}
+	due, wait = pn.takeDue(now.Add(time.Hour + poll_results_delay_))
+	if len(due) != 1 || due[0].StatusID != "1" || due[0].MatrixUser != "@bob:example.org" {
+		t.Errorf("unexpected due results %+v", due)
+	}
+	if wait != scheduler_max_sleep_ {
+		t.Errorf("unexpected wait %s", wait)
+	}
+
+	pn = loadPollResultNotifier()
+	if due, _ := pn.takeDue(now.Add(4 * 24 * time.Hour)); len(due) != 1 || due[0].StatusID != "2" {
+		t.Errorf("shown result came back after restart: %+v", due)
+	}
+}
+
+func TestPollResultNotifierKeepsRequeuedResults(t *testing.T) {
+	persistence_state_dir_ = t.TempDir()
+	defer func() { persistence_state_dir_ = "" }()
+	now := time.Now()
+
+	pn := loadPollResultNotifier()
+	pn.Add("1", "@bob:example.org", "https://chaos.social/@qbit/1", now)
+	due, _ := pn.takeDue(now.Add(poll_results_delay_))
+	if len(due) != 1 {
+		t.Fatalf("unexpected due results %+v", due)
+	}
+	pn.requeue(due[0], now.Add(poll_results_retry_delay_))
+
+	pn = loadPollResultNotifier()
+	if due, wait := pn.takeDue(now); len(due) != 0 || wait != poll_results_retry_delay_ {
+		t.Errorf("got %d due results and wait %s before the retry", len(due), wait)
+	}
+	if due, _ := pn.takeDue(now.Add(poll_results_retry_delay_)); len(due) != 1 || due[0].StatusID != "1" {
+		t.Errorf("requeued result was lost: %+v", due)
+	}
+}
+
+func TestIsMastodonNotFoundError(t *testing.T) {
+	if !isMastodonNotFoundError(fmt.Errorf("getting status: %w", &mastodon.APIError{StatusCode: 404})) {
+		t.Error("404 not recognized")
+	}
+	if isMastodonNotFoundError(&mastodon.APIError{StatusCode: 502}) || isMastodonNotFoundError(errors.New("connection refused")) {
+		t.Error("transient error taken for 404")
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mastodon "github.com/mattn/go-mastodon"
+)
+
+/// Polls are written as
+///   poll> question
+///   - first option
+///   - second option
+///   duration: 3d
+///   multiple: yes
+///   hidetotals: no
+
+const poll_default_duration_ time.Duration = 24 * time.Hour
+
+type MastodonPollLimits struct {
+	MaxOptions             int
+	MaxCharactersPerOption int
+	MinExpiration          time.Duration
+	MaxExpiration          time.Duration
+}
+
+// limits of Mastodon itself, used if the instance does not tell us
+var mastodon_default_poll_limits_ = MastodonPollLimits{
+	MaxOptions:             4,
+	MaxCharactersPerOption: 50,
+	MinExpiration:          5 * time.Minute,
+	MaxExpiration:          30 * 24 * time.Hour,
+}
+
+var (
+	mastodon_poll_limits_      *MastodonPollLimits
+	mastodon_poll_limits_lock_ sync.Mutex
+)
+
+var (
+	poll_option_line_re_  = regexp.MustCompile(`^\s*[-*]\s+(.*?)\s*$`)
+	poll_setting_line_re_ = regexp.MustCompile(`^(?i)(duration|multiple|hidetotals|hide_totals)\s*:\s*(.*?)\s*$`)
+)
+
+// ask the instance once for its poll limits. Falls back to Mastodon's defaults without caching them in case of error
+func getMastodonPollLimits(client *mastodon.Client) MastodonPollLimits {
+	mastodon_poll_limits_lock_.Lock()
+	defer mastodon_poll_limits_lock_.Unlock()
+	if mastodon_poll_limits_ != nil {
+		return *mastodon_poll_limits_
+	}
+	limits := mastodon_default_poll_limits_
+	instance, err := client.GetInstance(context.Background())
+	if err != nil {
+		log.Println("getMastodonPollLimits:", err)
+		return limits
+	}
+	if config := instance.GetConfig(); config != nil && config.Polls != nil {
+		polls := *config.Polls
+		if v, inmap := polls["max_options"]; inmap {
+			limits.MaxOptions = v
+		}
+		if v, inmap := polls["max_characters_per_option"]; inmap {
+			limits.MaxCharactersPerOption = v
+		}
+		if v, inmap := polls["min_expiration"]; inmap {
+			limits.MinExpiration = time.Duration(v) * time.Second
+		}
+		if v, inmap := polls["max_expiration"]; inmap {
+			limits.MaxExpiration = time.Duration(v) * time.Second
+		}
+	}
+	mastodon_poll_limits_ = &limits
+	return limits
+}
+
+// like time.ParseDuration, but also accepts days, e.g. "3d" or "1d12h"
+func parsePollDuration(s string) (time.Duration, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	var days time.Duration
+	if didx := strings.Index(s, "d"); didx > 0 {
+		ndays, err := strconv.Atoi(s[:didx])
+		if err != nil {
+			return 0, fmt.Errorf("could not parse duration '%s'", s)
+		}
+		days = time.Duration(ndays) * 24 * time.Hour
+		s = s[didx+1:]
+		if len(s) == 0 {
+			return days, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse duration '%s'", s)
+	}
+	return days + d, nil
+}
+
+func parseYesNo(s string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "yes", "y", "true", "on", "1":
+		return true, nil
+	case "no", "n", "false", "off", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("expected yes or no, got '%s'", s)
+}
+
+// split text of a poll command into question and poll and check the poll against limits
+func parsePoll(text string, limits MastodonPollLimits) (question string, poll *mastodon.TootPoll, err error) {
+	poll = &mastodon.TootPoll{ExpiresInSeconds: int64(poll_default_duration_.Seconds())}
+	var questionlines []string
+	for _, line := range strings.Split(text, "\n") {
+		if m := poll_option_line_re_.FindStringSubmatch(line); m != nil {
+			poll.Options = append(poll.Options, m[1])
+		} else if m := poll_setting_line_re_.FindStringSubmatch(line); m != nil {
+			switch strings.ToLower(m[1]) {
+			case "duration":
+				var duration time.Duration
+				if duration, err = parsePollDuration(m[2]); err != nil {
+					return
+				}
+				if duration < limits.MinExpiration || duration > limits.MaxExpiration {
+					err = fmt.Errorf("poll duration must be between %s and %s", limits.MinExpiration, limits.MaxExpiration)
+					return
+				}
+				poll.ExpiresInSeconds = int64(duration.Seconds())
+			case "multiple":
+				if poll.Multiple, err = parseYesNo(m[2]); err != nil {
+					return
+				}
+			case "hidetotals", "hide_totals":
+				if poll.HideTotals, err = parseYesNo(m[2]); err != nil {
+					return
+				}
+			}
+		} else {
+			questionlines = append(questionlines, line)
+		}
+	}
+	question = strings.TrimSpace(strings.Join(questionlines, "\n"))
+
+	if len(question) == 0 {
+		err = fmt.Errorf("a poll needs a question")
+	} else if len(poll.Options) < 2 {
+		err = fmt.Errorf("a poll needs at least two options, each on a line starting with '- '")
+	} else if len(poll.Options) > limits.MaxOptions {
+		err = fmt.Errorf("a poll can have at most %d options", limits.MaxOptions)
+	} else {
+		for _, option := range poll.Options {
+			if len([]rune(option)) > limits.MaxCharactersPerOption {
+				err = fmt.Errorf("poll option '%s' is longer than %d characters", option, limits.MaxCharactersPerOption)
+				break
+			}
+		}
+	}
+	return
+}
+
+// true if Mastodon's poll notifications get written into the control room anyway
+func mastodonNotificationsShownInControlRoom() bool {
+	return c.SectionInConfig("feed2matrix") && c.GetValueDefault("feed2matrix", "show_mastodon_notifications", "true") == "true"
+}
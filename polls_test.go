@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePollDuration(t *testing.T) {
+	for input, want := range map[string]time.Duration{
+		"3d":    72 * time.Hour,
+		"1d12h": 36 * time.Hour,
+		"30m":   30 * time.Minute,
+	} {
+		if got, err := parsePollDuration(input); err != nil || got != want {
+			t.Errorf("parsePollDuration(%q) = %s, %v, want %s", input, got, err, want)
+		}
+	}
+	if _, err := parsePollDuration("soon"); err == nil {
+		t.Error("invalid duration was accepted")
+	}
+}
+
+func TestParsePoll(t *testing.T) {
+	question, poll, err := parsePoll("Which day?\n- Monday\n- Tuesday\nduration: 2d\nmultiple: yes", mastodon_default_poll_limits_)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if question != "Which day?" || len(poll.Options) != 2 || poll.Options[1] != "Tuesday" || !poll.Multiple || poll.HideTotals || poll.ExpiresInSeconds != 2*24*3600 {
+		t.Errorf("unexpected poll %q %+v", question, poll)
+	}
+
+	for _, invalid := range []string{
+		"Only one option?\n- yes",
+		"- no\n- question",
+		"Too many?\n- 1\n- 2\n- 3\n- 4\n- 5",
+		"Too short?\n- yes\n- no\nduration: 1m",
+		"Too long option?\n- yes\n- " + strings.Repeat("x", 51),
+	} {
+		if _, _, err := parsePoll(invalid, mastodon_default_poll_limits_); err == nil {
+			t.Errorf("invalid poll was accepted: %q", invalid)
+		}
+	}
+}
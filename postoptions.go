@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"time"
+
+	mastodon "github.com/mattn/go-mastodon"
+	"github.com/matrix-org/gomatrix"
+)
+
+/// Options of a post, given as header lines right after the prefix, e.g.
+///   t> cw: spoilers for season 3
+///   visibility: unlisted
+///   lang: de
+///   desc: description of the first attached image
+///   text of the post
+
+type PostOptions struct {
+	ContentWarning string
+	Visibility     string
+	Language       string // ISO 639 code, empty to let the instance decide
+	// descriptions of attached media in order, replacing those given by replies to the media
+	MediaDescriptions []string
+	Poll              *mastodon.TootPoll // set by the poll command only
+	ScheduledAt       *time.Time         // set by the schedule command only
+}
+
+const (
+	visibilityPublic   string = "public"
+	visibilityUnlisted string = "unlisted"
+	visibilityPrivate  string = "private" // followers only
+	visibilityDirect   string = "direct"
+)
+
+var (
+	default_visibility_      string            = visibilityPublic
+	user_default_visibility_ map[string]string = make(map[string]string)
+	room_default_visibility_ map[string]string = make(map[string]string)
+)
+
+var (
+	post_option_line_re_       = regexp.MustCompile(`^(?i)(cw|visibility|lang|language|desc)\s*:\s*(.*?)\s*$`)
+	matrix_spoiler_reason_re_  = regexp.MustCompile(`<span[^>]*\sdata-mx-spoiler(?:="([^"]*)")?[^>]*>`)
+	matrix_html_reply_re_      = regexp.MustCompile(`(?s)<mx-reply>.*?</mx-reply>`)
+	matrix_html_linebreaks_re_ = regexp.MustCompile(`(?i)<br\s*/?>|</p>|</li>|</blockquote>`)
+	matrix_html_tags_re_       = regexp.MustCompile(`<[^>]*>`)
+)
+
+// accepts the names used by Mastodon as well as those shown in its UI
+func parseVisibility(v string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "public":
+		return visibilityPublic, nil
+	case "unlisted":
+		return visibilityUnlisted, nil
+	case "private", "followers", "followersonly", "followers-only":
+		return visibilityPrivate, nil
+	case "direct":
+		return visibilityDirect, nil
+	}
+	return "", fmt.Errorf("unknown visibility '%s', use public, unlisted, followers or direct", v)
+}
+
+// parse default visibilities from [visibility] section. Per user and room defaults are given as
+// space separated lists like users=@alice:example.org=unlisted @bob:example.org=followers
+func loadVisibilityConfig() {
+	var err error
+	if default_visibility_, err = parseVisibility(c.GetValueDefault("visibility", "default", visibilityPublic)); err != nil {
+		panic(fmt.Sprintf("ERROR: config value [visibility]default: %s", err))
+	}
+	for confname, defaults := range map[string]map[string]string{"users": user_default_visibility_, "rooms": room_default_visibility_} {
+		for _, assignment := range strings.Fields(c.GetValueDefault("visibility", confname, "")) {
+			eqidx := strings.LastIndex(assignment, "=")
+			if eqidx <= 0 {
+				panic(fmt.Sprintf("ERROR: config value [visibility]%s: expected <id>=<visibility>, got '%s'", confname, assignment))
+			}
+			if defaults[assignment[:eqidx]], err = parseVisibility(assignment[eqidx+1:]); err != nil {
+				panic(fmt.Sprintf("ERROR: config value [visibility]%s: %s", confname, err))
+			}
+		}
+	}
+}
+
+// strip leading option lines from post and return them.
+// Stops at the first line that is not an option, so the text itself may well start with e.g. "Note: "
+func parsePostOptions(post string) (string, PostOptions, error) {
+	var opts PostOptions
+	var err error
+	lines := strings.Split(post, "\n")
+	for len(lines) > 0 {
+		m := post_option_line_re_.FindStringSubmatch(lines[0])
+		if m == nil {
+			break
+		}
+		switch strings.ToLower(m[1]) {
+		case "cw":
+			opts.ContentWarning = m[2]
+		case "visibility":
+			if opts.Visibility, err = parseVisibility(m[2]); err != nil {
+				return post, opts, err
+			}
+		case "lang", "language":
+			if opts.Language, err = parseLanguage(m[2]); err != nil {
+				return post, opts, err
+			}
+		case "desc":
+			opts.MediaDescriptions = append(opts.MediaDescriptions, m[2])
+		}
+		lines = lines[1:]
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n")), opts, nil
+}
+
+// Clients hide text marked as spoiler in formatted_body and may omit it from the plain body.
+// If ev contains spoilers, returns the text of formatted_body including the spoilers
+// and the first given spoiler reason, which we use as content warning.
+func getMatrixSpoilerTextAndReason(ev *gomatrix.Event) (text string, reason string, hasspoiler bool) {
+	formatted_body, ok := getMapDeepString(ev.Content, "formatted_body")
+	if !ok {
+		return
+	}
+	spoilers := matrix_spoiler_reason_re_.FindAllStringSubmatch(formatted_body, -1)
+	if len(spoilers) == 0 {
+		return
+	}
+	hasspoiler = true
+	for _, spoiler := range spoilers {
+		if len(strings.TrimSpace(spoiler[1])) > 0 {
+			reason = html.UnescapeString(strings.TrimSpace(spoiler[1]))
+			break
+		}
+	}
+	if len(reason) == 0 {
+		reason = "spoiler"
+	}
+	text = matrix_html_reply_re_.ReplaceAllString(formatted_body, "")
+	text = matrix_html_linebreaks_re_.ReplaceAllString(text, "\n")
+	text = matrix_html_tags_re_.ReplaceAllString(text, "")
+	text = strings.TrimSpace(html.UnescapeString(text))
+	return
+}
+
+// parse options from post, using the reason of a Matrix spoiler as content warning if none was given
+func parsePostOptionsWithSpoiler(post string, spoiler_reason string) (string, PostOptions, error) {
+	post, opts, err := parsePostOptions(post)
+	if len(opts.ContentWarning) == 0 {
+		opts.ContentWarning = spoiler_reason
+	}
+	return post, opts, err
+}
+
+// set visibility unless it was given in the post.
+// Order of precedence: visibility implied by prefix, default of user, default of room, global default
+func (opts *PostOptions) applyDefaultVisibility(prefix_visibility, sender, roomid string) {
+	if len(opts.Visibility) > 0 {
+		return
+	}
+	if len(prefix_visibility) > 0 {
+		opts.Visibility = prefix_visibility
+	} else if v, inmap := user_default_visibility_[sender]; inmap {
+		opts.Visibility = v
+	} else if v, inmap := room_default_visibility_[roomid]; inmap {
+		opts.Visibility = v
+	} else {
+		opts.Visibility = default_visibility_
+	}
+}
+
+// only public posts go to twitter, which knows no other visibilities
+func (opts PostOptions) isTweetable() bool {
+	return len(opts.Visibility) == 0 || opts.Visibility == visibilityPublic
+}
+
+// content warning as prefix for networks that do not support them
+func (opts PostOptions) contentWarningPrefix() string {
+	if len(opts.ContentWarning) == 0 {
+		return ""
+	}
+	return "CW: " + opts.ContentWarning + "\n\n"
+}
+
+// to be appended to confirmation messages
+func (opts PostOptions) describe() string {
+	var attributes []string
+	if opts.Visibility == visibilityPrivate {
+		attributes = append(attributes, "followers-only")
+	} else if len(opts.Visibility) > 0 {
+		attributes = append(attributes, opts.Visibility)
+	}
+	if len(opts.Language) > 0 {
+		attributes = append(attributes, "language: "+opts.Language)
+	}
+	if len(opts.ContentWarning) > 0 {
+		attributes = append(attributes, "CW: "+opts.ContentWarning)
+	}
+	if len(attributes) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(attributes, ", ") + ")"
+}
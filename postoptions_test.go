@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/matrix-org/gomatrix"
+)
+
+func TestParsePostOptions(t *testing.T) {
+	post, opts, err := parsePostOptions("CW: season 3 spoilers \nvisibility: Followers\nNote: this line is text\nmore text")
+	if err != nil || opts.ContentWarning != "season 3 spoilers" || opts.Visibility != visibilityPrivate {
+		t.Errorf("unexpected options %+v %v", opts, err)
+	}
+	if post != "Note: this line is text\nmore text" {
+		t.Errorf("unexpected post %q", post)
+	}
+	post, opts, err = parsePostOptions("just text")
+	if err != nil || post != "just text" || opts.ContentWarning != "" || opts.Visibility != "" {
+		t.Errorf("text without options was changed: %q %+v", post, opts)
+	}
+	_, opts, _ = parsePostOptions("desc: a cat\ndesc: another cat\ncats!")
+	if len(opts.MediaDescriptions) != 2 || opts.MediaDescriptions[1] != "another cat" {
+		t.Errorf("unexpected media descriptions %q", opts.MediaDescriptions)
+	}
+	if _, _, err = parsePostOptions("visibility: secret\ntext"); err == nil {
+		t.Error("unknown visibility was accepted")
+	}
+}
+
+func TestApplyDefaultVisibility(t *testing.T) {
+	default_visibility_ = visibilityUnlisted
+	user_default_visibility_ = map[string]string{"@alice:example.org": visibilityPrivate}
+	defer func() { default_visibility_, user_default_visibility_ = visibilityPublic, make(map[string]string) }()
+
+	for _, tc := range []struct {
+		opts              PostOptions
+		prefix_visibility string
+		sender            string
+		want              string
+	}{
+		{PostOptions{Visibility: visibilityDirect}, visibilityUnlisted, "@alice:example.org", visibilityDirect},
+		{PostOptions{}, visibilityPublic, "@alice:example.org", visibilityPublic},
+		{PostOptions{}, "", "@alice:example.org", visibilityPrivate},
+		{PostOptions{}, "", "@bob:example.org", visibilityUnlisted},
+	} {
+		tc.opts.applyDefaultVisibility(tc.prefix_visibility, tc.sender, "!room:example.org")
+		if tc.opts.Visibility != tc.want {
+			t.Errorf("got visibility %s for %s with prefix %q, want %s", tc.opts.Visibility, tc.sender, tc.prefix_visibility, tc.want)
+		}
+	}
+}
+
+func TestGetMatrixSpoilerTextAndReason(t *testing.T) {
+	ev := &gomatrix.Event{Content: map[string]interface{}{
+		"body":           "Ending: [Spoiler](movie)",
+		"formatted_body": `Ending: <span data-mx-spoiler="movie &amp; book">everybody dies</span><br/>sorry`,
+	}}
+	text, reason, hasspoiler := getMatrixSpoilerTextAndReason(ev)
+	if !hasspoiler || reason != "movie & book" || text != "Ending: everybody dies\nsorry" {
+		t.Errorf("unexpected result %q %q %v", text, reason, hasspoiler)
+	}
+	ev.Content["formatted_body"] = "<b>no spoiler</b>"
+	if _, _, hasspoiler = getMatrixSpoilerTextAndReason(ev); hasspoiler {
+		t.Error("found spoiler where there is none")
+	}
+}
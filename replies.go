@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"sync"
+
+	mastodon "github.com/mattn/go-mastodon"
+)
+
+/// Replies to statuses mirrored into matrix, made by replying to their notice
+
+var (
+	my_mastodon_account_      *mastodon.Account
+	my_mastodon_account_lock_ sync.Mutex
+)
+
+// ask the instance once who we are
+func getMyMastodonAccount(client *mastodon.Client) (*mastodon.Account, error) {
+	my_mastodon_account_lock_.Lock()
+	defer my_mastodon_account_lock_.Unlock()
+	if my_mastodon_account_ != nil {
+		return my_mastodon_account_, nil
+	}
+	account, err := client.GetAccountCurrentUser(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	my_mastodon_account_ = account
+	return account, nil
+}
+
+// returns "@author @mentioned ... " for a reply to parent, leaving out ourselves and everyone already mentioned in post
+func formatReplyMentions(parent *mastodon.Status, myaccountid mastodon.ID, post string) string {
+	var mentions []string
+	seen := make(map[string]bool)
+	add := func(id mastodon.ID, acct string) {
+		if id == myaccountid || len(acct) == 0 || seen[acct] {
+			return
+		}
+		seen[acct] = true
+		if regexp.MustCompile(`(?i)(?:^|[^\w@])@` + regexp.QuoteMeta(acct) + `(?:[^\w@.]|\.?$)`).MatchString(post) {
+			return
+		}
+		mentions = append(mentions, "@"+acct)
+	}
+	add(parent.Account.ID, parent.Account.Acct)
+	for _, mention := range parent.Mentions {
+		add(mention.ID, mention.Acct)
+	}
+	if len(mentions) == 0 {
+		return ""
+	}
+	return strings.Join(mentions, " ") + " "
+}
+
+// visibility of a reply, unless one was given. Replies inherit the visibility of their parent
+func replyVisibility(opts PostOptions, prefix_visibility string, parent *mastodon.Status) string {
+	switch {
+	case len(opts.Visibility) > 0:
+		return opts.Visibility
+	case len(prefix_visibility) > 0:
+		return prefix_visibility
+	case len(parent.Visibility) > 0:
+		return parent.Visibility
+	}
+	return default_visibility_
+}
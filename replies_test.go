@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	mastodon "github.com/mattn/go-mastodon"
+)
+
+func TestFormatReplyMentions(t *testing.T) {
+	parent := &mastodon.Status{
+		Account: mastodon.Account{ID: "1", Acct: "alice@example.org"},
+		Mentions: []mastodon.Mention{
+			{ID: "2", Acct: "me"},
+			{ID: "3", Acct: "bob"},
+			{ID: "4", Acct: "carol@example.com"},
+		},
+	}
+	if got := formatReplyMentions(parent, "2", "hi all"); got != "@alice@example.org @bob @carol@example.com " {
+		t.Errorf("unexpected mentions %q", got)
+	}
+	if got := formatReplyMentions(parent, "2", "@bob and @carol@example.com, look"); got != "@alice@example.org " {
+		t.Errorf("mentions already in post were repeated: %q", got)
+	}
+	if got := formatReplyMentions(&mastodon.Status{Account: mastodon.Account{ID: "2", Acct: "me"}}, "2", "note to self"); got != "" {
+		t.Errorf("mentioned ourselves: %q", got)
+	}
+}
+
+func TestReplyVisibility(t *testing.T) {
+	parent := &mastodon.Status{Visibility: visibilityPrivate}
+	if v := replyVisibility(PostOptions{}, "", parent); v != visibilityPrivate {
+		t.Errorf("reply did not inherit visibility, got %s", v)
+	}
+	if v := replyVisibility(PostOptions{}, visibilityDirect, parent); v != visibilityDirect {
+		t.Errorf("prefix visibility was ignored, got %s", v)
+	}
+	if v := replyVisibility(PostOptions{Visibility: visibilityUnlisted}, visibilityDirect, parent); v != visibilityUnlisted {
+		t.Errorf("given visibility was ignored, got %s", v)
+	}
+}
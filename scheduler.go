@@ -0,0 +1,480 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/btittelbach/anaconda"
+	"github.com/matrix-org/gomatrix"
+	mastodon "github.com/mattn/go-mastodon"
+)
+
+/// Posts scheduled for later.
+/// Mastodon publishes scheduled toots itself. Tweets we keep, together with copies of their media,
+/// until they are due. Both are remembered here, so they can be listed, cancelled and rescheduled.
+/// Once due, we look up the status a scheduled toot became, so redacting the scheduling message deletes it.
+
+const (
+	scheduled_posts_filename_    = "scheduled.json"
+	scheduled_media_dirname_     = "scheduled_media"
+	mastodon_min_schedule_delay_ = 5 * time.Minute
+	scheduler_max_sleep_         = 10 * time.Minute
+	// how often and how long to look for the status a scheduled toot became
+	scheduled_toot_lookup_attempts_ = 5
+	scheduled_toot_lookup_interval_ = time.Minute
+)
+
+type ScheduledPost struct {
+	EventID             string      `json:"event_id"`
+	MatrixUser          string      `json:"matrix_user"`
+	Text                string      `json:"text"`
+	ContentWarning      string      `json:"content_warning,omitempty"`
+	Visibility          string      `json:"visibility,omitempty"`
+	Language            string      `json:"language,omitempty"`
+	ScheduledAt         time.Time   `json:"scheduled_at"`
+	MastodonScheduledID mastodon.ID `json:"mastodon_scheduled_id,omitempty"`
+	TweetPending        bool        `json:"tweet_pending,omitempty"`
+	MediaFiles          []string    `json:"media_files,omitempty"`
+}
+
+func (sp *ScheduledPost) options() PostOptions {
+	return PostOptions{ContentWarning: sp.ContentWarning, Visibility: sp.Visibility, Language: sp.Language}
+}
+
+func (sp *ScheduledPost) describe(now time.Time) string {
+	var networks []string
+	if len(sp.MastodonScheduledID) > 0 {
+		networks = append(networks, mastodon_net)
+	}
+	if sp.TweetPending {
+		networks = append(networks, twitter_net)
+	}
+	text := sp.Text
+	if runes := []rune(text); len(runes) > 60 {
+		text = string(runes[:60]) + "…"
+	}
+	return fmt.Sprintf("%s (in %s) by %s to %s%s: %s", sp.ScheduledAt.Format("Mon 2006-01-02 15:04 MST"), sp.ScheduledAt.Sub(now).Round(time.Minute), sp.MatrixUser, strings.Join(networks, "+"), sp.options().describe(), strings.ReplaceAll(text, "\n", " "))
+}
+
+type PostScheduler struct {
+	lock     sync.Mutex
+	filepath string
+	mediadir string
+	posts    []*ScheduledPost // sorted by ScheduledAt
+	wakeup_c chan struct{}
+}
+
+var post_scheduler_ *PostScheduler
+
+// load scheduled posts from state_dir if persistence is enabled
+func loadPostScheduler() *PostScheduler {
+	ps := &PostScheduler{wakeup_c: make(chan struct{}, 1)}
+	if len(persistence_state_dir_) == 0 {
+		return ps
+	}
+	ps.filepath = path.Join(persistence_state_dir_, scheduled_posts_filename_)
+	ps.mediadir = path.Join(persistence_state_dir_, scheduled_media_dirname_)
+	contents, err := ioutil.ReadFile(ps.filepath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("loadPostScheduler:", err)
+		}
+		return ps
+	}
+	if err = json.Unmarshal(contents, &ps.posts); err != nil {
+		log.Println("loadPostScheduler: ignoring corrupt file:", err)
+		ps.posts = nil
+	}
+	sort.SliceStable(ps.posts, func(i, j int) bool { return ps.posts[i].ScheduledAt.Before(ps.posts[j].ScheduledAt) })
+	return ps
+}
+
+// must be called with lock held
+func (ps *PostScheduler) save() {
+	sort.SliceStable(ps.posts, func(i, j int) bool { return ps.posts[i].ScheduledAt.Before(ps.posts[j].ScheduledAt) })
+	if len(ps.filepath) == 0 {
+		return
+	}
+	contents, err := json.Marshal(ps.posts)
+	if err != nil {
+		log.Println("PostScheduler.save:", err)
+		return
+	}
+	tmppath := ps.filepath + ".tmp"
+	if err = ioutil.WriteFile(tmppath, contents, 0600); err == nil {
+		err = os.Rename(tmppath, ps.filepath)
+	}
+	if err != nil {
+		log.Println("PostScheduler.save:", err)
+	}
+}
+
+func (ps *PostScheduler) wakeup() {
+	select {
+	case ps.wakeup_c <- struct{}{}:
+	default:
+	}
+}
+
+// copy staged media of nick, which will be gone by the time the post is due.
+// Their manifest entries are copied into a manifest next to the copies, so their mimetype and duration stay known
+func (ps *PostScheduler) copyMediaFiles(nick string, mediapaths []string) ([]string, error) {
+	ps.lock.Lock()
+	if len(ps.mediadir) == 0 {
+		var err error
+		if ps.mediadir, err = ioutil.TempDir("", "mycete-scheduled"); err != nil {
+			ps.lock.Unlock()
+			return nil, err
+		}
+	}
+	mediadir := ps.mediadir
+	ps.lock.Unlock()
+	if err := os.MkdirAll(mediadir, 0700); err != nil {
+		return nil, err
+	}
+
+	staged, err := loadStagingManifestFile(getStagingManifestPath(nick))
+	if err != nil {
+		log.Println("PostScheduler.copyMediaFiles: unreadable manifest:", err)
+		staged = &StagedMediaManifest{}
+	}
+	var copies []string
+	var entries []StagedMediaEntry
+	for _, mediapath := range mediapaths {
+		// staged media files are already named by the hash of their unique event id
+		copypath := path.Join(mediadir, path.Base(mediapath))
+		if err := copyFile(mediapath, copypath); err != nil {
+			removeFiles(copies)
+			return nil, err
+		}
+		copies = append(copies, copypath)
+		if idx := staged.findByMediaPath(mediapath); idx >= 0 {
+			entries = append(entries, staged.Entries[idx])
+		}
+	}
+
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	err = modifyStagingManifestFile(path.Join(mediadir, staging_manifest_filename_), func(m *StagedMediaManifest) {
+		for _, entry := range entries {
+			if idx := m.find(entry.EventID); idx >= 0 {
+				m.Entries[idx] = entry
+			} else {
+				m.Entries = append(m.Entries, entry)
+			}
+		}
+	})
+	if err != nil {
+		removeFiles(copies)
+		return nil, err
+	}
+	return copies, nil
+}
+
+// remove copied media files together with their manifest entries
+func (ps *PostScheduler) removeMediaFiles(copies []string) {
+	if len(copies) == 0 {
+		return
+	}
+	removeFiles(copies)
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	err := modifyStagingManifestFile(path.Join(path.Dir(copies[0]), staging_manifest_filename_), func(m *StagedMediaManifest) {
+		for _, copypath := range copies {
+			if idx := m.findByMediaFileName(path.Base(copypath)); idx >= 0 {
+				m.Entries = append(m.Entries[:idx], m.Entries[idx+1:]...)
+			}
+		}
+	})
+	if err != nil {
+		log.Println("PostScheduler.removeMediaFiles:", err)
+	}
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func removeFiles(paths []string) {
+	for _, fpath := range paths {
+		os.Remove(fpath)
+	}
+}
+
+func (ps *PostScheduler) Add(sp *ScheduledPost) {
+	ps.lock.Lock()
+	ps.posts = append(ps.posts, sp)
+	ps.save()
+	ps.lock.Unlock()
+	ps.wakeup()
+}
+
+// returns copies of all pending posts, sorted by time
+func (ps *PostScheduler) List() []ScheduledPost {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	list := make([]ScheduledPost, len(ps.posts))
+	for idx, sp := range ps.posts {
+		list[idx] = *sp
+	}
+	return list
+}
+
+// remove post scheduled by the given matrix event, returns nil if there is none
+func (ps *PostScheduler) Remove(eventid string) *ScheduledPost {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	for idx, sp := range ps.posts {
+		if sp.EventID == eventid {
+			ps.posts = append(ps.posts[:idx], ps.posts[idx+1:]...)
+			ps.save()
+			return sp
+		}
+	}
+	return nil
+}
+
+func (ps *PostScheduler) Reschedule(eventid string, when time.Time) {
+	ps.lock.Lock()
+	for _, sp := range ps.posts {
+		if sp.EventID == eventid {
+			sp.ScheduledAt = when
+		}
+	}
+	ps.save()
+	ps.lock.Unlock()
+	ps.wakeup()
+}
+
+// remove and return due posts, as well as how long to wait for the next one
+func (ps *PostScheduler) takeDuePosts(now time.Time) (due []*ScheduledPost, wait time.Duration) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	for len(ps.posts) > 0 && !ps.posts[0].ScheduledAt.After(now) {
+		due = append(due, ps.posts[0])
+		ps.posts = ps.posts[1:]
+	}
+	if len(due) > 0 {
+		ps.save()
+	}
+	wait = scheduler_max_sleep_
+	if len(ps.posts) > 0 && ps.posts[0].ScheduledAt.Sub(now) < wait {
+		wait = ps.posts[0].ScheduledAt.Sub(now)
+	}
+	return
+}
+
+// publishes due posts
+func (ps *PostScheduler) runPostScheduler(mclient *mastodon.Client, tclient *anaconda.TwitterApi, mxcli *gomatrix.Client, rums_store_chan chan<- RUMSStoreMsg, rums_retrieve_chan chan<- RUMSRetrieveMsg) {
+	for {
+		due, wait := ps.takeDuePosts(time.Now())
+		for _, sp := range due {
+			go ps.publishDuePost(mclient, tclient, mxcli, rums_store_chan, rums_retrieve_chan, sp)
+		}
+		select {
+		case <-time.After(wait):
+		case <-ps.wakeup_c:
+		}
+	}
+}
+
+// tweets the due post and finds the status Mastodon published for it.
+// Both are merged into what we remember about the scheduling message, which from then on counts as a post
+func (ps *PostScheduler) publishDuePost(mclient *mastodon.Client, tclient *anaconda.TwitterApi, mxcli *gomatrix.Client, rums_store_chan chan<- RUMSStoreMsg, rums_retrieve_chan chan<- RUMSRetrieveMsg, sp *ScheduledPost) {
+	future_chan := make(chan *MsgStatusData, 1)
+	rums_retrieve_chan <- RUMSRetrieveMsg{key: sp.EventID, future: future_chan}
+	data := MsgStatusData{MatrixUser: sp.MatrixUser}
+	if remembered := <-future_chan; remembered != nil {
+		data = *remembered
+	}
+	// the ID of the scheduled status is of no use anymore
+	data.TootID = ""
+	data.Action = actionPost
+
+	if sp.TweetPending {
+		reviewurl, twitterid, err := sendTweetWithMediaFiles(tclient, sp.Text, sp.options(), 0, sp.MediaFiles)
+		if err != nil {
+			log.Println("TwitterTweetERROR:", err)
+			mxNotify(mxcli, "schedule", sp.MatrixUser, "ERROR while tweeting your scheduled post!")
+		} else {
+			mxNotify(mxcli, "schedule", sp.MatrixUser, fmt.Sprintf("sent scheduled tweet!%s %s", sp.options().describe(), reviewurl))
+			data.TweetID = twitterid
+		}
+	}
+	ps.removeMediaFiles(sp.MediaFiles)
+
+	if len(sp.MastodonScheduledID) > 0 {
+		mxNotify(mxcli, "schedule", sp.MatrixUser, "your scheduled toot is being published by mastodon now")
+		status, err := lookupPublishedScheduledToot(mclient, sp)
+		if err != nil {
+			log.Println("BotCmdSchedule: could not find published toot:", err)
+			mxNotify(mxcli, "schedule", sp.MatrixUser, "I could not find your published scheduled toot, so redacting your message will not delete it")
+		} else {
+			mxNotify(mxcli, "schedule", sp.MatrixUser, fmt.Sprintf("published scheduled toot!%s %s", sp.options().describe(), status.URL))
+			data.TootID = status.ID
+		}
+	}
+	//redacting the scheduling message now deletes the tweet and toot
+	rums_store_chan <- RUMSStoreMsg{key: sp.EventID, data: data}
+}
+
+// waits until the post scheduled by eventid, which is due, was published. Returns nil if that takes too long
+func waitForPublishedScheduledPost(rums_retrieve_chan chan<- RUMSRetrieveMsg, eventid string) *MsgStatusData {
+	for attempt := 0; attempt <= scheduled_toot_lookup_attempts_; attempt++ {
+		future_chan := make(chan *MsgStatusData, 1)
+		rums_retrieve_chan <- RUMSRetrieveMsg{key: eventid, future: future_chan}
+		if data := <-future_chan; data != nil && data.Action == actionPost {
+			return data
+		}
+		time.Sleep(scheduled_toot_lookup_interval_)
+	}
+	return nil
+}
+
+// Mastodon does not tell which status a scheduled one became, so we look for it among our latest statuses
+// for a while, as Mastodon may publish it a little late
+func lookupPublishedScheduledToot(client *mastodon.Client, sp *ScheduledPost) (*mastodon.Status, error) {
+	myaccount, err := getMyMastodonAccount(client)
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 0; attempt < scheduled_toot_lookup_attempts_; attempt++ {
+		time.Sleep(scheduled_toot_lookup_interval_)
+		var statuses []*mastodon.Status
+		statuses, err = client.GetAccountStatuses(context.Background(), myaccount.ID, &mastodon.Pagination{Limit: 40})
+		if err != nil {
+			continue
+		}
+		if status := findPublishedScheduledToot(statuses, sp); status != nil {
+			return status, nil
+		}
+	}
+	if err == nil {
+		err = fmt.Errorf("no status matches scheduled status %s", sp.MastodonScheduledID)
+	}
+	return nil, err
+}
+
+// the earliest of statuses, which Mastodon returns newest first, that was published for sp:
+// created when it was due or later, with its text, content warning and visibility
+func findPublishedScheduledToot(statuses []*mastodon.Status, sp *ScheduledPost) (found *mastodon.Status) {
+	visibility := sp.Visibility
+	if len(visibility) == 0 {
+		visibility = visibilityPublic
+	}
+	text := scheduledTootTextKey(sp.Text)
+	for _, status := range statuses {
+		if status.CreatedAt.Before(sp.ScheduledAt.Add(-time.Minute)) {
+			break
+		}
+		if status.Reblog != nil || status.InReplyToID != nil || status.SpoilerText != sp.ContentWarning || status.Visibility != visibility {
+			continue
+		}
+		if _, body, _ := sanitizeFormatStatusForMatrix(status); scheduledTootTextKey(body) != text {
+			continue
+		}
+		found = status
+	}
+	return
+}
+
+// beginning of a toot's text, disregarding whitespace which Mastodon turns into paragraphs
+func scheduledTootTextKey(text string) string {
+	key := []rune(strings.Join(strings.Fields(text), ""))
+	if len(key) > 100 {
+		key = key[:100]
+	}
+	return string(key)
+}
+
+// cancel post scheduled by the given matrix event everywhere. Returns nil if there is no such post
+func (ps *PostScheduler) Cancel(mclient *mastodon.Client, eventid string) (*ScheduledPost, error) {
+	sp := ps.Remove(eventid)
+	if sp == nil {
+		return nil, nil
+	}
+	ps.removeMediaFiles(sp.MediaFiles)
+	if len(sp.MastodonScheduledID) > 0 {
+		return sp, cancelMastodonScheduledStatus(mclient, sp.MastodonScheduledID)
+	}
+	return sp, nil
+}
+
+func cancelMastodonScheduledStatus(client *mastodon.Client, id mastodon.ID) error {
+	return mastodonAPIRequest(context.Background(), client, http.MethodDelete, "/api/v1/scheduled_statuses/"+url.PathEscape(string(id)), nil, nil)
+}
+
+func rescheduleMastodonScheduledStatus(client *mastodon.Client, id mastodon.ID, when time.Time) error {
+	params := url.Values{}
+	params.Set("scheduled_at", when.UTC().Format(time.RFC3339))
+	return mastodonAPIRequest(context.Background(), client, http.MethodPut, "/api/v1/scheduled_statuses/"+url.PathEscape(string(id)), params, nil)
+}
+
+// a post can be scheduled for when, if that is in the future, and far enough for mastodon if it is tooted
+func checkScheduleTime(when, now time.Time, tomastodon bool) error {
+	if !when.After(now) {
+		return fmt.Errorf("%s is in the past", when.Format("Mon 2006-01-02 15:04 MST"))
+	}
+	if tomastodon && when.Sub(now) < mastodon_min_schedule_delay_ {
+		return fmt.Errorf("mastodon needs scheduled posts to be at least %s in the future", mastodon_min_schedule_delay_)
+	}
+	return nil
+}
+
+// understands relative times like "in 2h", "+1d12h" or "90m", times of day like "15:04" or "tomorrow 9:00",
+// dates like "2006-01-02 15:04" and RFC3339 timestamps. Times are in local time of the bot
+func parseScheduleTime(spec string, now time.Time) (time.Time, error) {
+	spec = strings.TrimSpace(spec)
+	if t, err := time.Parse(time.RFC3339, spec); err == nil {
+		return t, nil
+	}
+	lowerspec := strings.ToLower(spec)
+	relspec := strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(lowerspec, "in "), "+"))
+	if d, err := parsePollDuration(relspec); err == nil && d > 0 {
+		return now.Add(d), nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04", "2006-01-02t15:04", "2006-01-02 15:04:05"} {
+		if t, err := time.ParseInLocation(layout, lowerspec, now.Location()); err == nil {
+			return t, nil
+		}
+	}
+	day := now
+	tomorrow := strings.HasPrefix(lowerspec, "tomorrow ")
+	if tomorrow {
+		day = now.AddDate(0, 0, 1)
+		lowerspec = strings.TrimSpace(lowerspec[len("tomorrow "):])
+	}
+	if clock, err := time.Parse("15:04", lowerspec); err == nil {
+		t := time.Date(day.Year(), day.Month(), day.Day(), clock.Hour(), clock.Minute(), 0, 0, now.Location())
+		if !tomorrow && !t.After(now) {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("could not understand time '%s'. Try e.g. 'in 2h', '15:30', 'tomorrow 9:00' or '2006-01-02 15:04'", spec)
+}
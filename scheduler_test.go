@@ -0,0 +1,121 @@
+package main
+
+import (
+	"path"
+	"testing"
+	"time"
+
+	mastodon "github.com/mattn/go-mastodon"
+)
+
+func TestParseScheduleTime(t *testing.T) {
+	now := time.Date(2024, 5, 10, 14, 0, 0, 0, time.Local)
+	for spec, want := range map[string]time.Time{
+		"in 2h":                now.Add(2 * time.Hour),
+		"+1d12h":               now.Add(36 * time.Hour),
+		"90m":                  now.Add(90 * time.Minute),
+		"15:30":                time.Date(2024, 5, 10, 15, 30, 0, 0, time.Local),
+		"9:00":                 time.Date(2024, 5, 11, 9, 0, 0, 0, time.Local),
+		"tomorrow 15:30":       time.Date(2024, 5, 11, 15, 30, 0, 0, time.Local),
+		"2024-06-01 08:15":     time.Date(2024, 6, 1, 8, 15, 0, 0, time.Local),
+		"2024-06-01T08:15:00Z": time.Date(2024, 6, 1, 8, 15, 0, 0, time.UTC),
+	} {
+		got, err := parseScheduleTime(spec, now)
+		if err != nil || !got.Equal(want) {
+			t.Errorf("parseScheduleTime(%q) = %s, %v, want %s", spec, got, err, want)
+		}
+	}
+	if _, err := parseScheduleTime("whenever", now); err == nil {
+		t.Error("invalid time was accepted")
+	}
+}
+
+func TestCheckScheduleTime(t *testing.T) {
+	now := time.Date(2024, 5, 10, 14, 0, 0, 0, time.Local)
+	if err := checkScheduleTime(now.Add(-time.Hour), now, false); err == nil {
+		t.Error("time in the past was accepted")
+	}
+	if err := checkScheduleTime(now, now, false); err == nil {
+		t.Error("now was accepted")
+	}
+	if err := checkScheduleTime(now.Add(time.Minute), now, true); err == nil {
+		t.Error("mastodon's minimum delay was ignored")
+	}
+	if err := checkScheduleTime(now.Add(time.Minute), now, false); err != nil {
+		t.Errorf("tweets may be scheduled a minute ahead: %v", err)
+	}
+}
+
+func TestPostSchedulerSurvivesRestartAndReleasesDuePosts(t *testing.T) {
+	persistence_state_dir_ = t.TempDir()
+	defer func() { persistence_state_dir_ = "" }()
+	now := time.Now()
+
+	ps := loadPostScheduler()
+	ps.Add(&ScheduledPost{EventID: "$later", ScheduledAt: now.Add(time.Hour), TweetPending: true})
+	ps.Add(&ScheduledPost{EventID: "$sooner", ScheduledAt: now.Add(time.Minute), TweetPending: true})
+
+	ps = loadPostScheduler()
+	if list := ps.List(); len(list) != 2 || list[0].EventID != "$sooner" {
+		t.Fatalf("unexpected scheduled posts after restart: %+v", list)
+	}
+	due, wait := ps.takeDuePosts(now)
+	if len(due) != 0 || wait != time.Minute {
+		t.Errorf("got %d due posts and wait %s before anything is due", len(due), wait)
+	}
+	due, _ = ps.takeDuePosts(now.Add(2 * time.Minute))
+	if len(due) != 1 || due[0].EventID != "$sooner" {
+		t.Errorf("unexpected due posts %+v", due)
+	}
+	if ps.Remove("$later") == nil || len(ps.List()) != 0 {
+		t.Error("could not remove scheduled post")
+	}
+}
+
+func TestFindPublishedScheduledToot(t *testing.T) {
+	due := time.Date(2024, 5, 10, 14, 0, 0, 0, time.UTC)
+	sp := &ScheduledPost{Text: "Good morning\n\neverybody", ContentWarning: "coffee", ScheduledAt: due}
+	status := func(id mastodon.ID, created time.Time, content, cw, visibility string) *mastodon.Status {
+		return &mastodon.Status{ID: id, CreatedAt: created, Content: content, SpoilerText: cw, Visibility: visibility}
+	}
+	// newest first, as Mastodon returns them
+	statuses := []*mastodon.Status{
+		status("5", due.Add(3*time.Minute), "<p>Good morning</p><p>everybody</p>", "coffee", "public"),
+		status("4", due.Add(2*time.Minute), "<p>Good morning</p><p>everybody</p>", "", "public"),
+		status("3", due.Add(time.Minute), "<p>Good morning</p><p>everybody</p>", "coffee", "public"),
+		status("2", due.Add(30*time.Second), "<p>Something else</p>", "coffee", "public"),
+		status("1", due.Add(-time.Hour), "<p>Good morning</p><p>everybody</p>", "coffee", "public"),
+	}
+	if found := findPublishedScheduledToot(statuses, sp); found == nil || found.ID != "3" {
+		t.Errorf("found %+v, want status 3", found)
+	}
+	sp.Visibility = "unlisted"
+	if found := findPublishedScheduledToot(statuses, sp); found != nil {
+		t.Errorf("found %+v with different visibility", found)
+	}
+}
+
+func TestPostSchedulerKeepsManifestOfMediaCopies(t *testing.T) {
+	temp_image_files_dir_ = t.TempDir()
+	persistence_state_dir_ = t.TempDir()
+	defer func() { persistence_state_dir_ = "" }()
+	nick := "@alice:example.org"
+	mediapath := stageTestMediaFile(t, nick, "$video")
+	if err := addStagedMediaToManifest(nick, "$video", "video/quicktime", 20*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	ps := loadPostScheduler()
+	copies, err := ps.copyMediaFiles(nick, []string{mediapath})
+	if err != nil || len(copies) != 1 {
+		t.Fatalf("copying failed: %v %v", copies, err)
+	}
+	if mimetype, duration := getStagedMediaFileInfo(copies[0]); mimetype != "video/quicktime" || duration != 20*time.Second {
+		t.Errorf("copy is %s of %s", mimetype, duration)
+	}
+	ps.removeMediaFiles(copies)
+	manifest, err := loadStagingManifestFile(path.Join(path.Dir(copies[0]), staging_manifest_filename_))
+	if err != nil || len(manifest.Entries) != 0 {
+		t.Errorf("manifest entry of removed copy is left: %+v %v", manifest, err)
+	}
+}
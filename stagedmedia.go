@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"time"
+)
+
+/// The manifest keeps track of what is in a user's staging directory,
+/// so that a persistent staging directory can be reloaded after a restart
+
+const staging_manifest_filename_ = "manifest.json"
+
+type StagedMediaEntry struct {
+	EventID     string        `json:"event_id"`
+	Owner       string        `json:"owner"`
+	Uploaded    time.Time     `json:"uploaded"`
+	Description string        `json:"description,omitempty"`
+	Focus       string        `json:"focus,omitempty"`
+	Mimetype    string        `json:"mimetype,omitempty"`
+	Duration    time.Duration `json:"duration,omitempty"` // of videos and audio
+}
+
+type StagedMediaManifest struct {
+	Entries []StagedMediaEntry `json:"entries"`
+}
+
+func getStagingManifestPath(nick string) string {
+	return path.Join(hashNickToUserDir(nick), staging_manifest_filename_)
+}
+
+// returns an empty manifest if none exists yet
+func loadStagingManifestFile(manifestpath string) (*StagedMediaManifest, error) {
+	manifest := &StagedMediaManifest{}
+	contents, err := ioutil.ReadFile(manifestpath)
+	if os.IsNotExist(err) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(contents, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+func saveStagingManifestFile(manifestpath string, manifest *StagedMediaManifest) error {
+	contents, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	os.MkdirAll(path.Dir(manifestpath), 0700)
+	tmppath := manifestpath + ".tmp"
+	if err = ioutil.WriteFile(tmppath, contents, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmppath, manifestpath)
+}
+
+func (m *StagedMediaManifest) find(eventid string) int {
+	for idx, entry := range m.Entries {
+		if entry.EventID == eventid {
+			return idx
+		}
+	}
+	return -1
+}
+
+// find the entry of a copy of the media file, which keeps its name
+func (m *StagedMediaManifest) findByMediaFileName(filename string) int {
+	for idx, entry := range m.Entries {
+		if _, entrypath := hashNickAndTypeAndEventIdToPath(entry.Owner, uploadfile_type_media_, entry.EventID); path.Base(entrypath) == filename {
+			return idx
+		}
+	}
+	return -1
+}
+
+func (m *StagedMediaManifest) findByMediaPath(mediapath string) int {
+	for idx, entry := range m.Entries {
+		if _, entrypath := hashNickAndTypeAndEventIdToPath(entry.Owner, uploadfile_type_media_, entry.EventID); entrypath == mediapath {
+			return idx
+		}
+	}
+	return -1
+}
+
+// load manifest of nick, let modify change it and save it again
+func modifyStagingManifest(nick string, modify func(*StagedMediaManifest)) error {
+	return modifyStagingManifestFile(getStagingManifestPath(nick), modify)
+}
+
+func modifyStagingManifestFile(manifestpath string, modify func(*StagedMediaManifest)) error {
+	manifest, err := loadStagingManifestFile(manifestpath)
+	if err != nil {
+		log.Println("modifyStagingManifest: discarding unreadable manifest:", err)
+		manifest = &StagedMediaManifest{}
+	}
+	modify(manifest)
+	return saveStagingManifestFile(manifestpath, manifest)
+}
+
+func addStagedMediaToManifest(nick, eventid, mimetype string, duration time.Duration) error {
+	return modifyStagingManifest(nick, func(m *StagedMediaManifest) {
+		entry := StagedMediaEntry{EventID: eventid, Owner: nick, Uploaded: time.Now(), Mimetype: mimetype, Duration: duration}
+		if idx := m.find(eventid); idx >= 0 {
+			m.Entries[idx] = entry
+		} else {
+			m.Entries = append(m.Entries, entry)
+		}
+	})
+}
+
+func setStagedMediaDescriptionInManifest(nick, eventid, description string) error {
+	return modifyStagingManifest(nick, func(m *StagedMediaManifest) {
+		if idx := m.find(eventid); idx >= 0 {
+			m.Entries[idx].Description = description
+		}
+	})
+}
+
+func removeStagedMediaFromManifest(nick, eventid string) error {
+	return modifyStagingManifest(nick, func(m *StagedMediaManifest) {
+		if idx := m.find(eventid); idx >= 0 {
+			m.Entries = append(m.Entries[:idx], m.Entries[idx+1:]...)
+		}
+	})
+}
+
+// Reload the manifests of all users in a persistent staging directory after a restart.
+// Entries whose media file vanished are dropped, media older than timeout is removed,
+// media files without manifest entry are removed once they are older than timeout and
+// description and focus files that got lost are restored from the manifest.
+func reloadAndCleanStagingDir(timeout time.Duration) {
+	userdirs, err := ioutil.ReadDir(temp_image_files_dir_)
+	if err != nil {
+		log.Println("reloadAndCleanStagingDir:", err)
+		return
+	}
+	now := time.Now()
+	for _, userdirinfo := range userdirs {
+		if !userdirinfo.IsDir() {
+			continue
+		}
+		userdir := path.Join(temp_image_files_dir_, userdirinfo.Name())
+		manifestpath := path.Join(userdir, staging_manifest_filename_)
+		manifest, err := loadStagingManifestFile(manifestpath)
+		if err != nil {
+			log.Println("reloadAndCleanStagingDir: discarding unreadable manifest", manifestpath, err)
+			manifest = &StagedMediaManifest{}
+		}
+
+		known_media_files := make(map[string]bool, len(manifest.Entries))
+		kept_entries := make([]StagedMediaEntry, 0, len(manifest.Entries))
+		for _, entry := range manifest.Entries {
+			_, mediapath := hashNickAndTypeAndEventIdToPath(entry.Owner, uploadfile_type_media_, entry.EventID)
+			if _, err := os.Stat(mediapath); err != nil {
+				continue
+			}
+			if now.Sub(entry.Uploaded) > timeout {
+				log.Println("reloadAndCleanStagingDir: removing outdated media of", entry.Owner)
+				rmFile(entry.Owner, entry.EventID)
+				continue
+			}
+			if len(entry.Description) > 0 {
+				if _, err := readDescriptionOfMediaFile(mediapath); err != nil {
+					if err = saveMediaFileDescriptionFile(entry.Owner, entry.EventID, entry.Description); err != nil {
+						log.Println("reloadAndCleanStagingDir: could not restore description:", err)
+					}
+				}
+			}
+			if len(entry.Focus) > 0 && len(readFocusOfMediaFile(mediapath)) == 0 {
+				if err := saveMediaFileFocusFile(entry.Owner, entry.EventID, entry.Focus); err != nil {
+					log.Println("reloadAndCleanStagingDir: could not restore focal point:", err)
+				}
+			}
+			known_media_files[path.Base(mediapath)] = true
+			kept_entries = append(kept_entries, entry)
+		}
+
+		// remove leftovers that were never entered into the manifest, e.g. .tmp files of interrupted downloads
+		for _, filetype := range []string{uploadfile_type_media_, uploadfile_type_desc_, uploadfile_type_focus_} {
+			typedir := path.Join(userdir, filetype)
+			files, _ := ioutil.ReadDir(typedir)
+			for _, fileinfo := range files {
+				if !known_media_files[fileinfo.Name()] && now.Sub(fileinfo.ModTime()) > timeout {
+					os.Remove(path.Join(typedir, fileinfo.Name()))
+				}
+			}
+		}
+
+		manifest.Entries = kept_entries
+		if err := saveStagingManifestFile(manifestpath, manifest); err != nil {
+			log.Println("reloadAndCleanStagingDir:", err)
+		}
+		if len(kept_entries) > 0 {
+			log.Printf("reloadAndCleanStagingDir: %d staged media files of %s survived the restart", len(kept_entries), kept_entries[0].Owner)
+		}
+	}
+}
+
+// Remove staged media older than timeout while running, as reloadAndCleanStagingDir only does at startup.
+// Returns the number of removed media files per owner.
+func removeOutdatedStagedMedia(timeout time.Duration) map[string]int {
+	removed := make(map[string]int)
+	userdirs, err := ioutil.ReadDir(temp_image_files_dir_)
+	if err != nil {
+		log.Println("removeOutdatedStagedMedia:", err)
+		return removed
+	}
+	now := time.Now()
+	for _, userdirinfo := range userdirs {
+		if !userdirinfo.IsDir() {
+			continue
+		}
+		manifest, err := loadStagingManifestFile(path.Join(temp_image_files_dir_, userdirinfo.Name(), staging_manifest_filename_))
+		if err != nil {
+			continue
+		}
+		for _, entry := range manifest.Entries {
+			if now.Sub(entry.Uploaded) <= timeout {
+				continue
+			}
+			lock := getPerUserLock(entry.Owner)
+			lock.Lock()
+			if err := rmFile(entry.Owner, entry.EventID); err == nil {
+				removed[entry.Owner]++
+			} else if os.IsNotExist(err) {
+				removeStagedMediaFromManifest(entry.Owner, entry.EventID)
+			}
+			lock.Unlock()
+		}
+	}
+	return removed
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func stageTestMediaFile(t *testing.T, nick, eventid string) string {
+	filesdir, mediapath := hashNickAndTypeAndEventIdToPath(nick, uploadfile_type_media_, eventid)
+	if err := os.MkdirAll(filesdir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(mediapath, []byte("not really an image"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := addStagedMediaToManifest(nick, eventid, "image/png", 0); err != nil {
+		t.Fatal(err)
+	}
+	return mediapath
+}
+
+func TestReloadAndCleanStagingDir(t *testing.T) {
+	temp_image_files_dir_ = t.TempDir()
+	feed2matrx_image_count_limit_ = 4
+	nick := "@alice:example.org"
+
+	freshpath := stageTestMediaFile(t, nick, "$fresh")
+	if err := saveMediaFileDescription(nick, "$fresh", "a fresh image"); err != nil {
+		t.Fatal(err)
+	}
+	oldpath := stageTestMediaFile(t, nick, "$old")
+	if err := modifyStagingManifest(nick, func(m *StagedMediaManifest) {
+		m.Entries[m.find("$old")].Uploaded = time.Now().Add(-3 * time.Hour)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// simulate description file lost during restart
+	descpath, _ := getDescriptionFilenameOfMediaFilename(freshpath)
+	os.Remove(descpath)
+
+	reloadAndCleanStagingDir(2 * time.Hour)
+
+	if _, err := os.Stat(oldpath); !os.IsNotExist(err) {
+		t.Error("outdated media file was not removed")
+	}
+	if desc, err := readDescriptionOfMediaFile(freshpath); err != nil || desc != "a fresh image" {
+		t.Errorf("description was not restored from manifest: %q %v", desc, err)
+	}
+	filelist, err := getUserFileList(nick)
+	if err != nil || len(filelist) != 1 || filelist[0] != freshpath {
+		t.Errorf("unexpected staged files after reload: %v %v", filelist, err)
+	}
+	manifest, err := loadStagingManifestFile(getStagingManifestPath(nick))
+	if err != nil || len(manifest.Entries) != 1 || manifest.Entries[0].EventID != "$fresh" || manifest.Entries[0].Owner != nick {
+		t.Errorf("unexpected manifest after reload: %+v %v", manifest, err)
+	}
+}
+
+func TestRemoveOutdatedStagedMedia(t *testing.T) {
+	temp_image_files_dir_ = t.TempDir()
+	feed2matrx_image_count_limit_ = 4
+	nick := "@alice:example.org"
+
+	freshpath := stageTestMediaFile(t, nick, "$fresh")
+	oldpath := stageTestMediaFile(t, nick, "$old")
+	if err := modifyStagingManifest(nick, func(m *StagedMediaManifest) {
+		m.Entries[m.find("$old")].Uploaded = time.Now().Add(-3 * time.Hour)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	removed := removeOutdatedStagedMedia(2 * time.Hour)
+	if removed[nick] != 1 || len(removed) != 1 {
+		t.Errorf("unexpected removals %v", removed)
+	}
+	if _, err := os.Stat(oldpath); !os.IsNotExist(err) {
+		t.Error("outdated media file was not removed")
+	}
+	if _, err := os.Stat(freshpath); err != nil {
+		t.Error("fresh media file was removed")
+	}
+	manifest, err := loadStagingManifestFile(getStagingManifestPath(nick))
+	if err != nil || len(manifest.Entries) != 1 || manifest.Entries[0].EventID != "$fresh" {
+		t.Errorf("unexpected manifest after cleanup: %+v %v", manifest, err)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	mastodon "github.com/mattn/go-mastodon"
+)
+
+/// Actions on a status besides boosting and favouriting, which only exist on Mastodon.
+/// Each has a reverse, which is done when the matrix message that caused it is redacted.
+
+type MastodonStatusAction struct {
+	PrefixConfName string // name of the prefix in config section [matrix]
+	Endpoint       string // below /api/v1/statuses/:id/
+	Done           string // what we did with %s, to tell the user
+	Reverse        MsgStatusDataAction
+}
+
+var mastodon_status_actions_ = map[MsgStatusDataAction]MastodonStatusAction{
+	actionBookmark:     {"bookmark_prefix", "bookmark", "bookmarked %s", actionUnbookmark},
+	actionUnbookmark:   {"unbookmark_prefix", "unbookmark", "removed your bookmark of %s", actionBookmark},
+	actionPin:          {"pin_prefix", "pin", "pinned %s to your profile", actionUnpin},
+	actionUnpin:        {"unpin_prefix", "unpin", "unpinned %s from your profile", actionPin},
+	actionMuteThread:   {"mutethread_prefix", "mute", "muted the conversation of %s", actionUnmuteThread},
+	actionUnmuteThread: {"unmutethread_prefix", "unmute", "unmuted the conversation of %s", actionMuteThread},
+}
+
+// the status action whose prefix post starts with
+func getMastodonStatusActionOfPost(post string) (MsgStatusDataAction, bool) {
+	for action, statusaction := range mastodon_status_actions_ {
+		if prefix := c["matrix"][statusaction.PrefixConfName]; len(prefix) > 0 && strings.HasPrefix(post, prefix) {
+			return action, true
+		}
+	}
+	return 0, false
+}
+
+func doMastodonStatusAction(client *mastodon.Client, action MsgStatusDataAction, statusid mastodon.ID) error {
+	statusaction, ok := mastodon_status_actions_[action]
+	if !ok {
+		return fmt.Errorf("unknown status action %d", action)
+	}
+	return mastodonAPIRequest(context.Background(), client, http.MethodPost, fmt.Sprintf("/api/v1/statuses/%s/%s", statusid, statusaction.Endpoint), nil, nil)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gokyle/goconfig"
+)
+
+func TestMastodonStatusActionsReverseEachOther(t *testing.T) {
+	for action, statusaction := range mastodon_status_actions_ {
+		reverse, ok := mastodon_status_actions_[statusaction.Reverse]
+		if !ok || reverse.Reverse != action || statusaction.Reverse == action {
+			t.Errorf("%s is not undone by its reverse %s", statusaction.Endpoint, reverse.Endpoint)
+		}
+	}
+}
+
+func TestGetMastodonStatusActionOfPost(t *testing.T) {
+	prefixes := make(map[string]string)
+	for _, statusaction := range mastodon_status_actions_ {
+		prefixes[statusaction.PrefixConfName] = statusaction.Endpoint + ">"
+	}
+	useTestConfig(t, goconfig.ConfigMap{"matrix": prefixes})
+	for _, tc := range []struct {
+		post   string
+		action MsgStatusDataAction
+	}{
+		{"bookmark> >last", actionBookmark},
+		{"unbookmark> >^", actionUnbookmark},
+		{"pin> https://chaos.social/@qbit/102133941111331502", actionPin},
+		{"unpin> >last", actionUnpin},
+		{"mute> >last mention", actionMuteThread},
+		{"unmute> >last mention", actionUnmuteThread},
+	} {
+		if action, ok := getMastodonStatusActionOfPost(tc.post); !ok || action != tc.action {
+			t.Errorf("%q should be action %d, got %d", tc.post, tc.action, action)
+		}
+	}
+	if _, ok := getMastodonStatusActionOfPost("t> pin this"); ok {
+		t.Error("a post is no status action")
+	}
+}
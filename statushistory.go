@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	mastodon "github.com/mattn/go-mastodon"
+)
+
+/// Statuses the feed wrote into matrix rooms, so that commands can refer to them as
+/// "last", "last 3", "last mention", "last from @user" or "^" for the newest one in the room of the command.
+/// Replies need a leading ">", as in ">last", which keeps replies that just start with a word like "last" from being taken for a reference.
+/// Commands like reblog> take nothing but the status, so there the ">" may be left out
+
+const mirrored_status_history_size_ int = 200
+
+type MirroredStatus struct {
+	TootID    mastodon.ID
+	RoomID    string
+	Accts     []string // of the author and, for boosts, of the author of the boosted status
+	IsMention bool
+}
+
+var (
+	mirrored_status_history_      []MirroredStatus // oldest first
+	mirrored_status_history_lock_ sync.Mutex
+)
+
+const status_reference_pattern_ = `(?:(\^)|last(?:\s+(mention)|\s+from\s+@?([\w.\-]+(?:@[\w.\-]+)?)|\s+(\d+))?)`
+
+var (
+	// at the start of a reply, followed by its text
+	status_reference_re_ = regexp.MustCompile(`(?i)^>` + status_reference_pattern_ + `(?:\s+|$)`)
+	// as the whole argument of a command
+	status_reference_arg_re_ = regexp.MustCompile(`(?i)^>?` + status_reference_pattern_ + `\s*$`)
+)
+
+func rememberMirroredStatus(roomid string, status *mastodon.Status, ismention bool) {
+	mirrored := MirroredStatus{TootID: status.ID, RoomID: roomid, Accts: []string{status.Account.Acct}, IsMention: ismention}
+	if status.Reblog != nil {
+		mirrored.Accts = append(mirrored.Accts, status.Reblog.Account.Acct)
+	}
+	mirrored_status_history_lock_.Lock()
+	defer mirrored_status_history_lock_.Unlock()
+	mirrored_status_history_ = append(mirrored_status_history_, mirrored)
+	if len(mirrored_status_history_) > mirrored_status_history_size_ {
+		mirrored_status_history_ = append([]MirroredStatus(nil), mirrored_status_history_[len(mirrored_status_history_)-mirrored_status_history_size_:]...)
+	}
+}
+
+// does acct like user@example.org belong to user, given as user@example.org or just user
+func acctMatches(acct, user string) bool {
+	if strings.EqualFold(acct, user) {
+		return true
+	}
+	if strings.Contains(user, "@") {
+		// local accounts have no domain in their acct
+		return !strings.Contains(acct, "@") && strings.EqualFold(acct, user[:strings.Index(user, "@")])
+	}
+	return strings.EqualFold(strings.SplitN(acct, "@", 2)[0], user)
+}
+
+// If args starts with a reference to a mirrored status, returns its ID and the rest of args.
+// isref is false if args does not start with a reference.
+func parseStatusReference(args, roomid string) (statusid mastodon.ID, rest string, isref bool, err error) {
+	m := status_reference_re_.FindStringSubmatch(args)
+	if m == nil {
+		return "", args, false, nil
+	}
+	statusid, err = findMirroredStatus(m, roomid)
+	return statusid, args[len(m[0]):], true, err
+}
+
+// If args, the argument of a command like reblog>, is a reference to a mirrored status, with or without leading ">", returns its ID.
+// isref is false if args is something else, e.g. a URL.
+func parseStatusReferenceArg(args, roomid string) (statusid mastodon.ID, isref bool, err error) {
+	m := status_reference_arg_re_.FindStringSubmatch(args)
+	if m == nil {
+		return "", false, nil
+	}
+	statusid, err = findMirroredStatus(m, roomid)
+	return statusid, true, err
+}
+
+// the mirrored status a reference, as matched by status_reference_pattern_, refers to
+func findMirroredStatus(m []string, roomid string) (statusid mastodon.ID, err error) {
+	nth := 1
+	if len(m[4]) > 0 {
+		if nth, err = strconv.Atoi(m[4]); err != nil || nth < 1 {
+			return "", fmt.Errorf("'last %s' needs a number of at least 1", m[4])
+		}
+	}
+	matches := func(mirrored MirroredStatus) bool {
+		switch {
+		case len(m[1]) > 0:
+			return mirrored.RoomID == roomid
+		case len(m[2]) > 0:
+			return mirrored.IsMention
+		case len(m[3]) > 0:
+			for _, acct := range mirrored.Accts {
+				if acctMatches(acct, m[3]) {
+					return true
+				}
+			}
+			return false
+		}
+		return true
+	}
+
+	mirrored_status_history_lock_.Lock()
+	defer mirrored_status_history_lock_.Unlock()
+	seen := make(map[mastodon.ID]bool) // the same status may have been written into several rooms
+	for idx := len(mirrored_status_history_) - 1; idx >= 0; idx-- {
+		mirrored := mirrored_status_history_[idx]
+		if seen[mirrored.TootID] || !matches(mirrored) {
+			continue
+		}
+		seen[mirrored.TootID] = true
+		if len(seen) == nth {
+			return mirrored.TootID, nil
+		}
+	}
+	return "", fmt.Errorf("could not find '%s' among the last %d statuses shown in matrix", strings.TrimSpace(m[0]), len(mirrored_status_history_))
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	mastodon "github.com/mattn/go-mastodon"
+)
+
+func mirrorTestStatus(roomid, id, acct string, ismention bool) {
+	rememberMirroredStatus(roomid, &mastodon.Status{ID: mastodon.ID(id), Account: mastodon.Account{Acct: acct}}, ismention)
+}
+
+func TestParseStatusReference(t *testing.T) {
+	mirrored_status_history_ = nil
+	defer func() { mirrored_status_history_ = nil }()
+	mirrorTestStatus("!a:example.org", "1", "alice", true)
+	mirrorTestStatus("!a:example.org", "2", "bob@example.org", false)
+	mirrorTestStatus("!b:example.org", "3", "carol", false)
+	mirrorTestStatus("!a:example.org", "3", "carol", false) // same status in another room
+	rememberMirroredStatus("!b:example.org", &mastodon.Status{ID: "4", Account: mastodon.Account{Acct: "dave"}, Reblog: &mastodon.Status{Account: mastodon.Account{Acct: "erin@example.org"}}}, false)
+
+	for _, tc := range []struct {
+		args   string
+		roomid string
+		id     mastodon.ID
+		rest   string
+	}{
+		{">last", "!a:example.org", "4", ""},
+		{">last nice one", "!a:example.org", "4", "nice one"},
+		{">last 2", "!a:example.org", "3", ""},
+		{">last 3 hi", "!a:example.org", "2", "hi"},
+		{">last mention", "!a:example.org", "1", ""},
+		{">last from @bob", "!a:example.org", "2", ""},
+		{">last from bob@example.org thanks", "!a:example.org", "2", "thanks"},
+		{">last from @alice@chaos.social", "!a:example.org", "1", ""},
+		{">last from erin", "!a:example.org", "4", ""},
+		{">^", "!a:example.org", "3", ""},
+		{">^ me too", "!b:example.org", "4", "me too"},
+		{">Last", "!a:example.org", "4", ""},
+		{">LAST Mention", "!a:example.org", "1", ""},
+	} {
+		id, rest, isref, err := parseStatusReference(tc.args, tc.roomid)
+		if !isref || err != nil || id != tc.id || rest != tc.rest {
+			t.Errorf("parseStatusReference(%q, %s) = %q, %q, %v, %v; want %q, %q", tc.args, tc.roomid, id, rest, isref, err, tc.id, tc.rest)
+		}
+	}
+
+	for _, args := range []string{"lastly I say", "hello ^", "https://chaos.social/@qbit/102133941111331502", "", "> last"} {
+		if _, rest, isref, _ := parseStatusReference(args, "!a:example.org"); isref || rest != args {
+			t.Errorf("%q is no reference", args)
+		}
+	}
+	for _, args := range []string{">last 5", ">last 0", ">last from @nobody", ">^"} {
+		if _, _, isref, err := parseStatusReference(args, "!c:example.org"); !isref || err == nil {
+			t.Errorf("%q should be a reference that can not be found", args)
+		}
+	}
+}
+
+func TestParseStatusReferenceLeavesProseAlone(t *testing.T) {
+	mirrored_status_history_ = nil
+	defer func() { mirrored_status_history_ = nil }()
+	mirrorTestStatus("!a:example.org", "1", "alice", true)
+	for _, args := range []string{
+		"Last night was great @alice",
+		"last night was great @alice",
+		"Last from @alice I heard nothing",
+		"last 2 days were busy",
+		"last mention of this was in May",
+		"^ this, so much",
+	} {
+		if _, rest, isref, err := parseStatusReference(args, "!a:example.org"); isref || err != nil || rest != args {
+			t.Errorf("%q was taken for a reference", args)
+		}
+	}
+}
+
+func TestParseStatusReferenceArg(t *testing.T) {
+	mirrored_status_history_ = nil
+	defer func() { mirrored_status_history_ = nil }()
+	mirrorTestStatus("!a:example.org", "1", "alice", true)
+	mirrorTestStatus("!b:example.org", "2", "bob", false)
+
+	for _, tc := range []struct {
+		args string
+		id   mastodon.ID
+	}{
+		{"last", "2"},
+		{"Last", "2"},
+		{"last 2", "1"},
+		{"last mention", "1"},
+		{"last from @bob ", "2"},
+		{"^", "1"},
+		{">last", "2"},
+		{">^", "1"},
+	} {
+		if id, isref, err := parseStatusReferenceArg(tc.args, "!a:example.org"); !isref || err != nil || id != tc.id {
+			t.Errorf("parseStatusReferenceArg(%q) = %q, %v, %v; want %q", tc.args, id, isref, err, tc.id)
+		}
+	}
+	for _, args := range []string{"https://chaos.social/@qbit/102133941111331502", "toot 1", "last night", "lastly", ""} {
+		if _, isref, _ := parseStatusReferenceArg(args, "!a:example.org"); isref {
+			t.Errorf("%q is no reference", args)
+		}
+	}
+	if _, isref, err := parseStatusReferenceArg("last 3", "!a:example.org"); !isref || err == nil {
+		t.Error("'last 3' should be a reference that can not be found")
+	}
+}
+
+func TestMirroredStatusHistoryIsBounded(t *testing.T) {
+	mirrored_status_history_ = nil
+	defer func() { mirrored_status_history_ = nil }()
+	for i := 0; i < mirrored_status_history_size_+10; i++ {
+		mirrorTestStatus("!a:example.org", fmt.Sprint(i), "alice", false)
+	}
+	if len(mirrored_status_history_) != mirrored_status_history_size_ {
+		t.Errorf("history has %d entries", len(mirrored_status_history_))
+	}
+	if id, _, _, err := parseStatusReference(">last", "!a:example.org"); err != nil || id != mastodon.ID(fmt.Sprint(mirrored_status_history_size_+9)) {
+		t.Errorf("last is %s %v", id, err)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	twittertextextract "github.com/kylemcc/twitter-text-go/extract"
+	mastodon "github.com/mattn/go-mastodon"
+)
+
+/// Length of statuses as counted by each network.
+/// Mastodon counts characters, every URL as a fixed number of characters and mentions of remote users without their domain.
+/// Twitter weighs characters: latin and similar scripts count 1, others like CJK and emoji count 2.
+
+const (
+	character_limit_twitter_ int = 280
+	character_penalty_urls_  int = 23 // twitter's length of any URL, also used by default on mastodon
+)
+
+type MastodonTextLimits struct {
+	MaxCharacters            int
+	CharactersReservedPerURL int
+}
+
+// limits of Mastodon itself, used if the instance does not tell us
+var mastodon_default_text_limits_ = MastodonTextLimits{
+	MaxCharacters:            500,
+	CharactersReservedPerURL: character_penalty_urls_,
+}
+
+var (
+	mastodon_text_limits_      *MastodonTextLimits
+	mastodon_text_limits_lock_ sync.Mutex
+)
+
+// domain of remote mentions like @user@example.org, which mastodon does not count
+var mastodon_mention_domain_re_ = regexp.MustCompile(`(?:^|[^\w/@])@\w+(@[\w.\-]+\w)`)
+
+// code points twitter weighs 1, all others weigh 2
+var twitter_light_ranges_ = [][2]rune{{0, 4351}, {8192, 8205}, {8208, 8223}, {8242, 8247}}
+
+// ask the instance once for its character limits. Falls back to Mastodon's defaults without caching them in case of error
+func getMastodonTextLimits(client *mastodon.Client) MastodonTextLimits {
+	mastodon_text_limits_lock_.Lock()
+	defer mastodon_text_limits_lock_.Unlock()
+	if mastodon_text_limits_ != nil {
+		return *mastodon_text_limits_
+	}
+	limits := mastodon_default_text_limits_
+	if client == nil {
+		return limits
+	}
+	var instance struct {
+		Configuration struct {
+			Statuses struct {
+				MaxCharacters            int `json:"max_characters"`
+				CharactersReservedPerURL int `json:"characters_reserved_per_url"`
+			} `json:"statuses"`
+		} `json:"configuration"`
+	}
+	if err := mastodonAPIRequest(context.Background(), client, http.MethodGet, "/api/v2/instance", nil, &instance); err != nil {
+		log.Println("getMastodonTextLimits:", err)
+		return limits
+	}
+	if v := instance.Configuration.Statuses.MaxCharacters; v > 0 {
+		limits.MaxCharacters = v
+	}
+	if v := instance.Configuration.Statuses.CharactersReservedPerURL; v > 0 {
+		limits.CharactersReservedPerURL = v
+	}
+	mastodon_text_limits_ = &limits
+	return limits
+}
+
+// length of status as counted by mastodon
+func (limits MastodonTextLimits) statusLength(status string) int {
+	urlsinstatus := twittertextextract.ExtractUrls(status)
+	for _, url := range urlsinstatus {
+		// a space keeps the words around the url apart, so it must not be counted
+		status = strings.Replace(status, url.Text, " ", 1)
+	}
+	statuslen := utf8.RuneCountInString(status) + len(urlsinstatus)*(limits.CharactersReservedPerURL-1)
+	for _, m := range mastodon_mention_domain_re_.FindAllStringSubmatch(status, -1) {
+		statuslen -= utf8.RuneCountInString(m[1])
+	}
+	return statuslen
+}
+
+func twitterRuneWeight(r rune) int {
+	for _, lightrange := range twitter_light_ranges_ {
+		if r >= lightrange[0] && r <= lightrange[1] {
+			return 1
+		}
+	}
+	return 2
+}
+
+// length of status as counted by twitter. An emoji sequence counts as one emoji
+func calcTweetLength(status string) int {
+	urlsinstatus := twittertextextract.ExtractUrls(status)
+	for _, url := range urlsinstatus {
+		status = strings.Replace(status, url.Text, " ", 1)
+	}
+	statuslen := len(urlsinstatus) * (character_penalty_urls_ - 1)
+	joined := false
+	for _, r := range status {
+		switch {
+		case r == '\u200d': // zero width joiner, the next emoji is part of this one
+			joined = true
+			continue
+		case r >= '\ufe00' && r <= '\ufe0f', r >= 0x1f3fb && r <= 0x1f3ff: // variation selectors and skin tones
+		case joined:
+		default:
+			statuslen += twitterRuneWeight(r)
+		}
+		joined = false
+	}
+	return statuslen
+}
+
+func formatCharacterCount(network string, length, limit int) string {
+	if length > limit {
+		return fmt.Sprintf("%d/%d characters on %s, %d too many", length, limit, network, length-limit)
+	}
+	return fmt.Sprintf("%d/%d characters on %s, %d left", length, limit, network, limit-length)
+}
+
+// check length of a toot together with its content warning
+func checkMastodonCharacterLimit(client *mastodon.Client, status, content_warning string) error {
+	limits := getMastodonTextLimits(client)
+	if statuslen := limits.statusLength(content_warning) + limits.statusLength(status); statuslen > limits.MaxCharacters {
+		return fmt.Errorf("too long: %s", formatCharacterCount("Mastodon", statuslen, limits.MaxCharacters))
+	}
+	return nil
+}
+
+// check length of status together with its content warning on each enabled network the post goes to
+func checkCharacterLimit(client *mastodon.Client, status string, opts PostOptions) error {
+	var counts []string
+	toolong := false
+	if c["server"]["mastodon"] == "true" {
+		limits := getMastodonTextLimits(client)
+		statuslen := limits.statusLength(opts.ContentWarning) + limits.statusLength(status)
+		toolong = toolong || statuslen > limits.MaxCharacters
+		counts = append(counts, formatCharacterCount("Mastodon", statuslen, limits.MaxCharacters))
+	}
+	if c["server"]["twitter"] == "true" && opts.isTweetable() {
+		statuslen := calcTweetLength(opts.contentWarningPrefix() + status)
+		toolong = toolong || statuslen > character_limit_twitter_
+		counts = append(counts, formatCharacterCount("Twitter", statuslen, character_limit_twitter_))
+	}
+	if toolong {
+		return fmt.Errorf("too long: %s", strings.Join(counts, ", "))
+	}
+	return nil
+}
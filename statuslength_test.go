@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gokyle/goconfig"
+)
+
+func TestMastodonStatusLength(t *testing.T) {
+	limits := MastodonTextLimits{MaxCharacters: 500, CharactersReservedPerURL: 23}
+	for _, tc := range []struct {
+		status string
+		length int
+	}{
+		{"hello", 5},
+		{strings.Repeat("ü", 500), 500},
+		{"look https://example.org/a/very/long/path/that/is/longer/than/23 here", 5 + 23 + 5},
+		{"@bob@example.org hi", 7},
+		{"hi @bob@example.org and @alice", 18},
+		{"mail me at me@example.org", 25},
+	} {
+		if length := limits.statusLength(tc.status); length != tc.length {
+			t.Errorf("statusLength(%q) = %d, want %d", tc.status, length, tc.length)
+		}
+	}
+}
+
+func TestCalcTweetLength(t *testing.T) {
+	for _, tc := range []struct {
+		status string
+		length int
+	}{
+		{"héllo", 5},
+		{"日本語", 6},
+		{"ok 👍", 5},
+		{"👍🏽", 2},
+		{"👨‍👩‍👧", 2},
+		{"see https://example.org/a/very/long/path/that/is/longer/than/23", 4 + 23},
+	} {
+		if length := calcTweetLength(tc.status); length != tc.length {
+			t.Errorf("calcTweetLength(%q) = %d, want %d", tc.status, length, tc.length)
+		}
+	}
+}
+
+func TestCheckCharacterLimitReportsEachNetwork(t *testing.T) {
+	useTestConfig(t, goconfig.ConfigMap{"server": {"mastodon": "true", "twitter": "true"}})
+	mastodon_text_limits_ = &MastodonTextLimits{MaxCharacters: 500, CharactersReservedPerURL: 23}
+	defer func() { mastodon_text_limits_ = nil }()
+	directmsg_re_before := directmsg_re_.String()
+
+	if err := checkCharacterLimit(nil, strings.Repeat("ä", 280), PostOptions{}); err != nil {
+		t.Error("umlauts should count once:", err)
+	}
+	err := checkCharacterLimit(nil, strings.Repeat("ä", 300), PostOptions{})
+	if err == nil || !strings.Contains(err.Error(), "300/500 characters on Mastodon, 200 left") || !strings.Contains(err.Error(), "300/280 characters on Twitter, 20 too many") {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := checkCharacterLimit(nil, strings.Repeat("a", 300)+" @bob@example.org", PostOptions{Visibility: "unlisted"}); err != nil {
+		t.Error("unlisted posts are not tweeted, so only mastodon's limit applies:", err)
+	}
+	if directmsg_re_.String() != directmsg_re_before {
+		t.Error("checking the length must not change directmsg_re_")
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/btittelbach/cachetable"
+	mastodon "github.com/mattn/go-mastodon"
+)
+
+/// URLs of statuses on any fediverse instance are resolved to the ID the status has on our instance,
+/// by searching for them with resolve=true, which makes our instance fetch remote statuses.
+
+// status URLs of Mastodon, GoToSocial, Pleroma, Akkoma, Misskey and Friendica
+var fediverse_status_uri_re_ = regexp.MustCompile(`^https?://[^/\s]+/(?:@[\w.\-]+(?:@[\w.\-]+)?/(?:statuses/)?[\w\-]+|web/statuses/\d+|users/[\w.\-]+/statuses/[\w\-]+|notice/[\w\-]+|objects/[\w\-]+|notes/\w+|display/[\w\-]+)/?$`)
+
+var (
+	resolved_status_ids_      *cachetable.CacheTable
+	resolved_status_ids_lock_ sync.Mutex
+)
+
+func isFediverseStatusURL(statusurl string) bool {
+	return fediverse_status_uri_re_.MatchString(statusurl) && !twitter_status_uri_re_.MatchString(statusurl)
+}
+
+// the ID of a status on our own instance can be taken from its URL
+func getLocalStatusIDFromURL(statusurl string) (mastodon.ID, bool) {
+	matchlist := mastodon_status_uri_re_.FindStringSubmatch(statusurl)
+	if len(matchlist) < 2 {
+		return "", false
+	}
+	u, err := url.Parse(statusurl)
+	server, err2 := url.Parse(c["mastodon"]["server"])
+	if err != nil || err2 != nil || !strings.EqualFold(u.Host, server.Host) {
+		return "", false
+	}
+	return mastodon.ID(matchlist[1]), true
+}
+
+// cache of status URLs resolved before, so we do not need to ask our instance again.
+// Must be called with resolved_status_ids_lock_ held
+func getResolvedStatusIDCache() *cachetable.CacheTable {
+	if resolved_status_ids_ == nil {
+		resolved_status_ids_, _ = cachetable.NewCacheTable(16, 8, false)
+	}
+	return resolved_status_ids_
+}
+
+// returns the ID the status at statusurl has on our instance
+func resolveMastodonStatusURL(client *mastodon.Client, statusurl string) (mastodon.ID, error) {
+	if id, islocal := getLocalStatusIDFromURL(statusurl); islocal {
+		return id, nil
+	}
+	resolved_status_ids_lock_.Lock()
+	if node, found := getResolvedStatusIDCache().Get(statusurl); found {
+		resolved_status_ids_lock_.Unlock()
+		return node.Value.(mastodon.ID), nil
+	}
+	resolved_status_ids_lock_.Unlock()
+
+	results, err := client.Search(context.Background(), statusurl, true)
+	if err != nil {
+		return "", err
+	}
+	if len(results.Statuses) == 0 {
+		return "", fmt.Errorf("your instance could not find the status %s", statusurl)
+	}
+	id := results.Statuses[0].ID
+	resolved_status_ids_lock_.Lock()
+	getResolvedStatusIDCache().Set(statusurl, id)
+	resolved_status_ids_lock_.Unlock()
+	return id, nil
+}
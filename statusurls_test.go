@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	mastodon "github.com/mattn/go-mastodon"
+)
+
+func TestIsFediverseStatusURL(t *testing.T) {
+	for _, statusurl := range []string{
+		"https://chaos.social/@qbit/102133941111331502",
+		"https://chaos.social/web/statuses/102140251110038222",
+		"https://mastodon.social/users/test/statuses/102133941111331502",
+		"https://gts.example.org/@someone/statuses/01H8ZXW9VQJ5R2C3E4FXKQ7N8M",
+		"https://pleroma.example.org/notice/AZbYcXdWeV",
+		"https://akkoma.example.org/objects/6f9c1a2b-3c4d-4e5f-8a9b-0c1d2e3f4a5b",
+		"https://misskey.io/notes/9h3kq2x0a1",
+		"https://friendica.example.org/display/abc-123",
+	} {
+		if !isFediverseStatusURL(statusurl) {
+			t.Errorf("%s should be a status url", statusurl)
+		}
+	}
+	for _, statusurl := range []string{
+		"https://twitter.com/someone/status/1131013299817111553",
+		"https://chaos.social/@qbit",
+		"https://example.org/blog/post",
+		"not a url",
+	} {
+		if isFediverseStatusURL(statusurl) {
+			t.Errorf("%s should not be a status url", statusurl)
+		}
+	}
+}
+
+func TestResolveMastodonStatusURL(t *testing.T) {
+	useTestMastodonServer(t)
+	if id, err := resolveMastodonStatusURL(nil, "https://chaos.social/@qbit/102133941111331502"); err != nil || id != "102133941111331502" {
+		t.Errorf("local status should not need resolving, got %s %v", id, err)
+	}
+	if _, islocal := getLocalStatusIDFromURL("https://mastodon.social/@qbit/102133941111331502"); islocal {
+		t.Error("status on another instance must not be taken as local")
+	}
+
+	remote := "https://pleroma.example.org/notice/AZbYcXdWeV"
+	resolved_status_ids_lock_.Lock()
+	getResolvedStatusIDCache().Set(remote, mastodon.ID("4711"))
+	resolved_status_ids_lock_.Unlock()
+	var acted_on string
+	err := parseReblogFavouriteArgs("+1>", "+1> "+remote, "!room:example.org", nil, nil, func(statusid string) error {
+		acted_on = statusid
+		return nil
+	}, func(string) error {
+		t.Error("not a tweet")
+		return nil
+	})
+	if err != nil || acted_on != "4711" {
+		t.Errorf("expected cached id of remote status, got %q %v", acted_on, err)
+	}
+}
@@ -1,15 +1,41 @@
 package main
 
-import mastodon "github.com/mattn/go-mastodon"
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	mastodon "github.com/mattn/go-mastodon"
+)
 
 type MsgStatusDataAction int
 
 const (
-	actionPost   	 MsgStatusDataAction = iota
-	actionReblog 	 MsgStatusDataAction = iota
-	actionFav    	 MsgStatusDataAction = iota
-	actionMedia  	 MsgStatusDataAction = iota
-	actionMediaDesc  MsgStatusDataAction = iota
+	actionPost           MsgStatusDataAction = iota
+	actionReblog         MsgStatusDataAction = iota
+	actionFav            MsgStatusDataAction = iota
+	actionMedia          MsgStatusDataAction = iota
+	actionMediaDesc      MsgStatusDataAction = iota
+	actionSchedule       MsgStatusDataAction = iota
+	actionFeedNotice     MsgStatusDataAction = iota // notice we wrote about TootID, MatrixUser is empty. Only found in old logs, feed notices are kept apart now
+	actionBookmark       MsgStatusDataAction = iota
+	actionUnbookmark     MsgStatusDataAction = iota
+	actionPin            MsgStatusDataAction = iota
+	actionUnpin          MsgStatusDataAction = iota
+	actionMuteThread     MsgStatusDataAction = iota
+	actionUnmuteThread   MsgStatusDataAction = iota
+	actionFollow         MsgStatusDataAction = iota
+	actionUnfollow       MsgStatusDataAction = iota
+	actionMuteAccount    MsgStatusDataAction = iota
+	actionUnmuteAccount  MsgStatusDataAction = iota
+	actionBlock          MsgStatusDataAction = iota
+	actionUnblock        MsgStatusDataAction = iota
+	actionRemoveFollower MsgStatusDataAction = iota
 )
 
 type MsgStatusData struct {
@@ -17,6 +43,27 @@ type MsgStatusData struct {
 	TootID     mastodon.ID
 	TweetID    int64
 	Action     MsgStatusDataAction
+	// further parts of a thread, TootID and TweetID being the first
+	ThreadTootIDs  []mastodon.ID `json:",omitempty"`
+	ThreadTweetIDs []int64       `json:",omitempty"`
+	// account followed, muted or blocked
+	AccountID mastodon.ID `json:",omitempty"`
+}
+
+// last part of the thread of toots, to which a continuation replies
+func (msd *MsgStatusData) lastTootID() mastodon.ID {
+	if len(msd.ThreadTootIDs) > 0 {
+		return msd.ThreadTootIDs[len(msd.ThreadTootIDs)-1]
+	}
+	return msd.TootID
+}
+
+// last part of the thread of tweets, to which a continuation replies
+func (msd *MsgStatusData) lastTweetID() int64 {
+	if len(msd.ThreadTweetIDs) > 0 {
+		return msd.ThreadTweetIDs[len(msd.ThreadTweetIDs)-1]
+	}
+	return msd.TweetID
 }
 
 type RUMSStoreMsg struct {
@@ -29,28 +76,200 @@ type RUMSRetrieveMsg struct {
 	future chan<- *MsgStatusData
 }
 
+// one line in the append-only log of the RUMS store
+type rumsLogEntry struct {
+	Key    string        `json:"k"`
+	Data   MsgStatusData `json:"d"`
+	Stored time.Time     `json:"t"`
+}
+
+const rums_log_filename_ = "rums.log"
+
+// returns path of the RUMS log file or "" if persistence is disabled
+func getRUMSLogPath() string {
+	if len(persistence_state_dir_) == 0 {
+		return ""
+	}
+	return path.Join(persistence_state_dir_, rums_log_filename_)
+}
+
 func runRememberUsersMessageToStatus() (rv_store_chan chan<- RUMSStoreMsg, rv_retrieve_chan chan<- RUMSRetrieveMsg) {
+	retention, err := time.ParseDuration(c.GetValueDefault("persistence", "rums_retention", "720h"))
+	if err != nil {
+		panic("ERROR: Could not parse duration from config value [persistence]rums_retention")
+	}
+	maxentries, err := strconv.Atoi(c.GetValueDefault("persistence", "rums_max_entries", "20000"))
+	if err != nil || maxentries < 1 {
+		panic("ERROR: config value [persistence]rums_max_entries must be a positive number")
+	}
+	return runRememberUsersMessageToStatusWithLog(getRUMSLogPath(), retention, maxentries)
+}
+
+// remembers which matrix event resulted in which status
+// if logpath is given, every stored entry is appended to that file and the file is read back in at startup.
+// entries older than retention are forgotten and at most maxentries are remembered, the oldest ones being evicted first.
+// the log is rewritten with only the remembered entries at startup and whenever it has grown to more than twice its necessary size.
+func runRememberUsersMessageToStatusWithLog(logpath string, retention time.Duration, maxentries int) (rv_store_chan chan<- RUMSStoreMsg, rv_retrieve_chan chan<- RUMSRetrieveMsg) {
 	store_chan := make(chan RUMSStoreMsg, 20)
 	retrieve_chan := make(chan RUMSRetrieveMsg, 20)
+
+	brain := make(map[string]rumsLogEntry, 100)
+	var logfh *os.File
+	lines_in_log := 0
+
+	evictOldEntries := func() {
+		now := time.Now()
+		for key, entry := range brain {
+			if now.Sub(entry.Stored) > retention {
+				delete(brain, key)
+			}
+		}
+		if len(brain) <= maxentries {
+			return
+		}
+		entries := make([]rumsLogEntry, 0, len(brain))
+		for _, entry := range brain {
+			entries = append(entries, entry)
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].Stored.Before(entries[j].Stored)
+		})
+		// evict a few more than necessary, so we don't have to sort on every store
+		keep := maxentries - maxentries/10
+		for _, entry := range entries[:len(entries)-keep] {
+			delete(brain, entry.Key)
+		}
+	}
+
+	compactLog := func() {
+		if len(logpath) == 0 {
+			return
+		}
+		if logfh != nil {
+			logfh.Close()
+			logfh = nil
+		}
+		tmplogpath := logpath + ".tmp"
+		fh, err := os.OpenFile(tmplogpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			log.Println("runRememberUsersMessageToStatus: could not compact log:", err)
+		} else {
+			enc := json.NewEncoder(fh)
+			for _, entry := range brain {
+				if err = enc.Encode(entry); err != nil {
+					break
+				}
+			}
+			if err == nil {
+				err = fh.Sync()
+			}
+			fh.Close()
+			if err == nil {
+				err = os.Rename(tmplogpath, logpath)
+			}
+			if err != nil {
+				log.Println("runRememberUsersMessageToStatus: could not compact log:", err)
+				os.Remove(tmplogpath)
+			} else {
+				lines_in_log = len(brain)
+			}
+		}
+		logfh, err = os.OpenFile(logpath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			log.Println("runRememberUsersMessageToStatus: can not append to log, continuing without persistence:", err)
+			logfh = nil
+		}
+	}
+
+	if len(logpath) > 0 {
+		if fh, err := os.Open(logpath); err == nil {
+			scanner := bufio.NewScanner(fh)
+			scanner.Buffer(make([]byte, 0, 4096), 1024*1024)
+			for scanner.Scan() {
+				var entry rumsLogEntry
+				if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+					log.Println("runRememberUsersMessageToStatus: skipping corrupt log line:", err)
+					continue
+				}
+				brain[entry.Key] = entry
+			}
+			if err := scanner.Err(); err != nil {
+				log.Println("runRememberUsersMessageToStatus: error reading log:", err)
+			}
+			fh.Close()
+		} else if !os.IsNotExist(err) {
+			log.Println("runRememberUsersMessageToStatus: could not read log:", err)
+		}
+		evictOldEntries()
+		compactLog()
+		log.Printf("runRememberUsersMessageToStatus: remembering %d entries from %s", len(brain), logpath)
+	}
+
+	storeEntry := func(storeme RUMSStoreMsg) {
+		entry := rumsLogEntry{Key: storeme.key, Data: storeme.data, Stored: time.Now()}
+		brain[storeme.key] = entry
+		if len(brain) > maxentries {
+			evictOldEntries()
+		}
+		if logfh != nil {
+			if line, err := json.Marshal(entry); err == nil {
+				if _, err = logfh.Write(append(line, '\n')); err != nil {
+					log.Println("runRememberUsersMessageToStatus: could not append to log:", err)
+				}
+				lines_in_log++
+			}
+			if lines_in_log > 2*len(brain)+100 {
+				compactLog()
+			}
+		}
+	}
+
 	go func() {
-		brain := make(map[string]MsgStatusData, 100)
+		evict_ticker := time.NewTicker(time.Hour)
+		defer evict_ticker.Stop()
+		defer func() {
+			if logfh != nil {
+				logfh.Close()
+			}
+		}()
 		for {
 			select {
 			case storeme, chanok := <-store_chan:
 				if !chanok {
 					return
 				}
-				brain[storeme.key] = storeme.data
+				storeEntry(storeme)
 			case retrieveme, chanok := <-retrieve_chan:
 				if !chanok {
 					return
 				}
-				rums, inmap := brain[retrieveme.key]
+				// process already queued stores first, so a retrieve never overtakes a store sent before it
+			DRAINFOR:
+				for {
+					select {
+					case storeme, chanok := <-store_chan:
+						if !chanok {
+							break DRAINFOR
+						}
+						storeEntry(storeme)
+					default:
+						break DRAINFOR
+					}
+				}
+				entry, inmap := brain[retrieveme.key]
+				if inmap && time.Since(entry.Stored) > retention {
+					// expired, but not yet evicted by the ticker
+					delete(brain, retrieveme.key)
+					inmap = false
+				}
 				if inmap {
-					retrieveme.future <- &rums //return pointer to copy produced by map retrieval
+					rums := entry.Data
+					retrieveme.future <- &rums //return pointer to copy
 				} else {
 					retrieveme.future <- nil
 				}
+			case <-evict_ticker.C:
+				evictOldEntries()
 			}
 		}
 	}()
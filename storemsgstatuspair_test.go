@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"testing"
+	"time"
+
+	mastodon "github.com/mattn/go-mastodon"
+)
+
+func rumsRetrieve(retrieve_chan chan<- RUMSRetrieveMsg, key string) *MsgStatusData {
+	future := make(chan *MsgStatusData, 1)
+	retrieve_chan <- RUMSRetrieveMsg{key: key, future: future}
+	return <-future
+}
+
+func TestRUMSStoreSurvivesRestart(t *testing.T) {
+	logpath := path.Join(t.TempDir(), rums_log_filename_)
+
+	store_chan, retrieve_chan := runRememberUsersMessageToStatusWithLog(logpath, time.Hour, 100)
+	store_chan <- RUMSStoreMsg{key: "$event1", data: MsgStatusData{MatrixUser: "@alice:example.org", TootID: mastodon.ID("1234"), TweetID: 42, Action: actionPost}}
+	store_chan <- RUMSStoreMsg{key: "$event2", data: MsgStatusData{MatrixUser: "@bob:example.org", TootID: mastodon.ID("5678"), Action: actionFav}}
+	if rumsRetrieve(retrieve_chan, "$event2") == nil {
+		t.Fatal("freshly stored entry not found")
+	}
+	close(store_chan)
+
+	_, retrieve_chan = runRememberUsersMessageToStatusWithLog(logpath, time.Hour, 100)
+	rums := rumsRetrieve(retrieve_chan, "$event1")
+	if rums == nil {
+		t.Fatal("entry did not survive restart")
+	}
+	if rums.MatrixUser != "@alice:example.org" || rums.TootID != "1234" || rums.TweetID != 42 || rums.Action != actionPost {
+		t.Errorf("entry changed during restart: %+v", rums)
+	}
+	if rums = rumsRetrieve(retrieve_chan, "$event2"); rums == nil || rums.Action != actionFav {
+		t.Errorf("second entry did not survive restart: %+v", rums)
+	}
+	if rumsRetrieve(retrieve_chan, "$unknown") != nil {
+		t.Error("unknown key returned an entry")
+	}
+}
+
+func TestRUMSStoreEvictsOldestEntries(t *testing.T) {
+	logpath := path.Join(t.TempDir(), rums_log_filename_)
+	store_chan, retrieve_chan := runRememberUsersMessageToStatusWithLog(logpath, time.Hour, 10)
+	for i := 0; i < 20; i++ {
+		store_chan <- RUMSStoreMsg{key: fmt.Sprintf("$event%d", i), data: MsgStatusData{Action: actionPost}}
+		time.Sleep(time.Millisecond)
+	}
+	if rumsRetrieve(retrieve_chan, "$event19") == nil {
+		t.Error("newest entry was evicted")
+	}
+	if rumsRetrieve(retrieve_chan, "$event0") != nil {
+		t.Error("oldest entry was not evicted")
+	}
+	close(store_chan)
+
+	_, retrieve_chan = runRememberUsersMessageToStatusWithLog(logpath, time.Hour, 10)
+	if rumsRetrieve(retrieve_chan, "$event0") != nil {
+		t.Error("evicted entry came back after restart")
+	}
+	if rumsRetrieve(retrieve_chan, "$event19") == nil {
+		t.Error("newest entry did not survive restart")
+	}
+}
+
+func TestRUMSStoreForgetsExpiredEntriesBeforeEviction(t *testing.T) {
+	store_chan, retrieve_chan := runRememberUsersMessageToStatusWithLog("", 20*time.Millisecond, 100)
+	defer close(store_chan)
+	store_chan <- RUMSStoreMsg{key: "$event1", data: MsgStatusData{Action: actionPost}}
+	if rumsRetrieve(retrieve_chan, "$event1") == nil {
+		t.Fatal("freshly stored entry not found")
+	}
+	time.Sleep(40 * time.Millisecond)
+	if rumsRetrieve(retrieve_chan, "$event1") != nil {
+		t.Error("entry older than retention was returned")
+	}
+}
+
+func TestMsgStatusDataLastIDsOfThread(t *testing.T) {
+	single := MsgStatusData{TootID: "1", TweetID: 10}
+	if single.lastTootID() != "1" || single.lastTweetID() != 10 {
+		t.Errorf("unexpected last ids %s %d of single post", single.lastTootID(), single.lastTweetID())
+	}
+	thread := MsgStatusData{TootID: "1", TweetID: 10, ThreadTootIDs: []mastodon.ID{"2", "3"}, ThreadTweetIDs: []int64{11}}
+	if thread.lastTootID() != "3" || thread.lastTweetID() != 11 {
+		t.Errorf("unexpected last ids %s %d of thread", thread.lastTootID(), thread.lastTweetID())
+	}
+}
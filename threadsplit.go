@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+/// Splitting of over-long posts into a numbered thread
+
+// boundaries we prefer to split at, best first
+var thread_split_boundaries_re_ = []*regexp.Regexp{
+	regexp.MustCompile(`\n\s*\n`),             // paragraphs
+	regexp.MustCompile(`\n`),                  // lines
+	regexp.MustCompile(`[.!?…]+["'»)\]]*\s+`), // sentences
+	regexp.MustCompile(`\s+`),                 // words
+}
+
+// parts of a thread need at least that many characters besides their " (n/m)" suffix
+const thread_min_part_length_ int = 20
+
+func threadPartSuffix(n, m int) string {
+	return fmt.Sprintf(" (%d/%d)", n, m)
+}
+
+// split post into parts of at most limit characters as counted by lengthfunc, each ending in " (n/m)".
+// A post that does not exceed limit is returned unchanged as only part.
+func splitPostIntoThread(post string, limit int, lengthfunc func(string) int) ([]string, error) {
+	post = strings.TrimSpace(post)
+	if lengthfunc(post) <= limit {
+		return []string{post}, nil
+	}
+	// reserve space for the longest suffix, assuming a number of parts, and try again if we needed more digits
+	numparts_guess := 9
+	for {
+		partlimit := limit - lengthfunc(threadPartSuffix(numparts_guess, numparts_guess))
+		if partlimit < thread_min_part_length_ {
+			return nil, fmt.Errorf("character limit of %d is too small to split post into a thread", limit)
+		}
+		parts := splitTextAtBoundaries(post, partlimit, lengthfunc, 0)
+		if len(strconv.Itoa(len(parts))) <= len(strconv.Itoa(numparts_guess)) {
+			for idx := range parts {
+				parts[idx] += threadPartSuffix(idx+1, len(parts))
+			}
+			return parts, nil
+		}
+		numparts_guess = numparts_guess*10 + 9
+	}
+}
+
+// greedily pack as many pieces of text between boundaries of the given level into each part as fit into limit.
+// pieces that are too long on their own are split at the next level of boundaries
+func splitTextAtBoundaries(text string, limit int, lengthfunc func(string) int, level int) []string {
+	if level >= len(thread_split_boundaries_re_) {
+		// no boundaries left, cut between characters
+		var parts []string
+		runes := []rune(text)
+		for len(runes) > 0 {
+			n := len(runes)
+			for n > 1 && lengthfunc(string(runes[:n])) > limit {
+				n--
+			}
+			parts = append(parts, string(runes[:n]))
+			runes = runes[n:]
+		}
+		return parts
+	}
+
+	// pieces keep their trailing boundary, so that joining them gives back text
+	var pieces []string
+	last := 0
+	for _, loc := range thread_split_boundaries_re_[level].FindAllStringIndex(text, -1) {
+		pieces = append(pieces, text[last:loc[1]])
+		last = loc[1]
+	}
+	pieces = append(pieces, text[last:])
+
+	var parts []string
+	current := ""
+	for _, piece := range pieces {
+		if lengthfunc(strings.TrimSpace(current+piece)) <= limit {
+			current += piece
+			continue
+		}
+		if len(strings.TrimSpace(current)) > 0 {
+			parts = append(parts, strings.TrimSpace(current))
+		}
+		current = piece
+		if lengthfunc(strings.TrimSpace(piece)) > limit {
+			subparts := splitTextAtBoundaries(strings.TrimSpace(piece), limit, lengthfunc, level+1)
+			parts = append(parts, subparts[:len(subparts)-1]...)
+			// continue with the remainder, keeping the boundary that followed it
+			current = subparts[len(subparts)-1] + piece[len(strings.TrimRightFunc(piece, unicode.IsSpace)):]
+		}
+	}
+	if len(strings.TrimSpace(current)) > 0 {
+		parts = append(parts, strings.TrimSpace(current))
+	}
+	return parts
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitPostIntoThreadKeepsShortPost(t *testing.T) {
+	parts, err := splitPostIntoThread("  short post ", 500, utf8.RuneCountInString)
+	if err != nil || len(parts) != 1 || parts[0] != "short post" {
+		t.Errorf("unexpected parts %q %v", parts, err)
+	}
+}
+
+func TestSplitPostIntoThreadPrefersParagraphsAndSentences(t *testing.T) {
+	post := "First paragraph is here.\n\nSecond one has two sentences. This is the second sentence of it."
+	parts, err := splitPostIntoThread(post, 60, utf8.RuneCountInString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"First paragraph is here. (1/3)",
+		"Second one has two sentences. (2/3)",
+		"This is the second sentence of it. (3/3)",
+	}
+	if strings.Join(parts, "|") != strings.Join(want, "|") {
+		t.Errorf("got %q, want %q", parts, want)
+	}
+}
+
+func TestSplitPostIntoThreadRespectsLimit(t *testing.T) {
+	post := strings.Repeat("word ", 300) + strings.Repeat("ü", 150)
+	parts, err := splitPostIntoThread(post, 50, utf8.RuneCountInString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) < 10 || !strings.HasSuffix(parts[len(parts)-1], threadPartSuffix(len(parts), len(parts))) {
+		t.Errorf("unexpected numbering: %q", parts[len(parts)-1])
+	}
+	for _, part := range parts {
+		if utf8.RuneCountInString(part) > 50 {
+			t.Errorf("part exceeds limit: %q", part)
+		}
+	}
+	if _, err := splitPostIntoThread(post, 10, utf8.RuneCountInString); err == nil {
+		t.Error("expected error for tiny limit")
+	}
+}
@@ -4,63 +4,21 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net/http"
 	"net/url"
 	"os"
-	"regexp"
 	"strconv"
 	"strings"
 
 	"github.com/btittelbach/anaconda"
-	twittertextextract "github.com/kylemcc/twitter-text-go/extract"
 	mastodon "github.com/mattn/go-mastodon"
 )
 
-const character_limit_twitter_ int = 280
-const character_limit_mastodon_ int = 500
-const character_penalty_urls_ int = 23 //currently the same for twitter and mastodon. supposed to check this via twitter api at startup (low priority TODO)
 const imgbytes_limit_twitter_ int64 = 5242880
 const imgbytes_limit_mastodon_ int64 = 4 * 1024 * 1024
 
 const webbaseformaturl_twitter_ string = "https://twitter.com/i/web/status/%s"
 
-func checkCharacterLimit(status string) error {
-	// get minimum character limit
-	climit := 10000
-	if c["server"]["mastodon"] == "true" && climit > character_limit_mastodon_ {
-		climit = character_limit_mastodon_
-	}
-	if c["server"]["twitter"] == "true" && climit > character_limit_twitter_ {
-		climit = character_limit_twitter_
-	}
-
-	//calc length as counted by twitter/mastodon
-	//any URL counts as ~23 runes
-	urlsinstatus := twittertextextract.ExtractUrls(status)
-	statuslen := len(status)
-	for _, url := range urlsinstatus {
-		//echo URL is counted as a fixed number of characters
-		statuslen -= len(url.Text)
-		statuslen += character_penalty_urls_
-	}
-
-	//mastodon does not count screen name domains
-	//find all directmsg_re_ and remove length of trailing domain
-	//but only for Mastodon (aka if twitter is disabled) (shorter anyway)
-	if c["server"]["mastodon"] == "true" && c["server"]["twitter"] != "true" {
-		directmsg_re_ = regexp.MustCompile(`(?:^|\s)@\w+(@[a-zA-Z0-9.]+)(?:\W|$)`)
-		for _, m := range directmsg_re_.FindAllStringSubmatch(status, 20) {
-			statuslen -= len(m[1])
-		}
-	}
-
-	// get number of characters ... this is not entirely accurate, but close enough. (read twitters API page on character counting)
-	if statuslen <= climit {
-		return nil
-	} else {
-		return fmt.Errorf("status/tweet of %d characters exceeds limit of %d", len(status), climit)
-	}
-}
-
 /////////////
 /// Twitter
 /////////////
@@ -73,11 +31,25 @@ func initTwitterClient() *anaconda.TwitterApi {
 		c["twitter"]["consumer_secret"])
 }
 
-func sendTweet(client *anaconda.TwitterApi, post, matrixnick string) (weburl string, statusid int64, err error) {
+func sendTweet(client *anaconda.TwitterApi, post, matrixnick string, opts PostOptions, inreplyto int64, attachmedia bool) (weburl string, statusid int64, err error) {
+	var mediapaths []string
+	if attachmedia && c.GetValueDefault("images", "enabled", "false") == "true" {
+		mediapaths, _ = getUserFileList(matrixnick)
+	}
+	return sendTweetWithMediaFiles(client, post, opts, inreplyto, mediapaths)
+}
+
+func sendTweetWithMediaFiles(client *anaconda.TwitterApi, post string, opts PostOptions, inreplyto int64, mediapaths []string) (weburl string, statusid int64, err error) {
+	//twitter has no content warnings, so we prepend them
+	post = opts.contentWarningPrefix() + post
 	v := url.Values{}
 	v.Set("status", post)
-	if c.GetValueDefault("images", "enabled", "false") == "true" {
-		if media_ids, _ := getImagesForTweet(client, matrixnick); media_ids != nil {
+	if inreplyto > 0 {
+		v.Set("in_reply_to_status_id", strconv.FormatInt(inreplyto, 10))
+		v.Set("auto_populate_reply_metadata", "true")
+	}
+	if len(mediapaths) > 0 {
+		if media_ids, _ := uploadMediaFilesForTweet(client, mediapaths); media_ids != nil {
 			v.Set("media_ids", strings.Join(media_ids, ","))
 		}
 	}
@@ -91,21 +63,44 @@ func sendTweet(client *anaconda.TwitterApi, post, matrixnick string) (weburl str
 	return
 }
 
+// tweet parts as a thread, each part replying to the previous one and the first one to inreplyto, if > 0.
+// Media is attached to the first part only. returns the IDs of all parts that could be posted, even in case of error
+func sendTweetThread(client *anaconda.TwitterApi, parts []string, matrixnick string, opts PostOptions, inreplyto int64) (weburl string, statusids []int64, err error) {
+	for idx, part := range parts {
+		var partweburl string
+		partweburl, inreplyto, err = sendTweet(client, part, matrixnick, opts, inreplyto, idx == 0)
+		if err != nil {
+			return
+		}
+		if idx == 0 {
+			weburl = partweburl
+		}
+		statusids = append(statusids, inreplyto)
+	}
+	return
+}
+
 func sendTwitterDirectMessage(client *anaconda.TwitterApi, post, twitterhandle string) error {
 	_, err := client.PostDMToScreenName(post, twitterhandle)
 	return err
 }
 
-func getImagesForTweet(client *anaconda.TwitterApi, nick string) ([]string, error) {
-	imagepaths, err := getUserFileList(nick)
-	if err != nil {
-		return nil, err
-	}
-	if len(imagepaths) == 0 {
-		return nil, fmt.Errorf("No stored image for nick")
-	}
+func uploadMediaFilesForTweet(client *anaconda.TwitterApi, imagepaths []string) ([]string, error) {
 	media_ids := make([]string, len(imagepaths))
 	for idx, imagepath := range imagepaths {
+		if mimetype, duration := getStagedMediaFileInfo(imagepath); isVideoOrAudioMimetype(mimetype) {
+			// videos are uploaded in chunks, audio is only tooted
+			var err error
+			if reason := whyNotTweetable(mimetype, 0, duration); len(reason) > 0 {
+				// it is posted on its own, so the tweet goes without media
+				log.Println("uploadMediaFilesForTweet: not tweeting media:", reason)
+				return nil, nil
+			}
+			if media_ids[idx], err = uploadVideoForTweet(client, imagepath, mimetype); err != nil {
+				return nil, err
+			}
+			continue
+		}
 		if b64data, err := readFileIntoBase64(imagepath); err != nil {
 			return nil, err
 		} else {
@@ -134,11 +129,16 @@ func initMastodonClient() *mastodon.Client {
 	})
 }
 
-func sendToot(client *mastodon.Client, post, matrixnick string, directmsg bool, inreplyto string) (weburl string, statusid mastodon.ID, err error) {
+// if opts.ScheduledAt is set, statusid will be the ID of the scheduled status
+func sendToot(client *mastodon.Client, post, matrixnick string, opts PostOptions, inreplyto string, attachmedia bool) (weburl string, statusid mastodon.ID, err error) {
 	var mids []mastodon.ID
 	usertoot := &mastodon.Toot{Status: post}
-	if c.GetValueDefault("images", "enabled", "false") == "true" {
-		if mids, err = getImagesForToot(client, matrixnick); err == nil {
+	if len(opts.ContentWarning) > 0 {
+		usertoot.SpoilerText = opts.ContentWarning
+		usertoot.Sensitive = true // also hides attached media
+	}
+	if attachmedia && c.GetValueDefault("images", "enabled", "false") == "true" {
+		if mids, err = getImagesForToot(client, matrixnick, opts.MediaDescriptions); err == nil {
 			if mids != nil {
 				usertoot.MediaIDs = mids
 			}
@@ -146,15 +146,18 @@ func sendToot(client *mastodon.Client, post, matrixnick string, directmsg bool,
 			log.Println("sendToot::getImagesForToot Error:", err)
 		}
 	}
-	if directmsg {
-		usertoot.Visibility = "direct"
+	if len(opts.Visibility) > 0 {
+		usertoot.Visibility = opts.Visibility
 		// usertoot.InReplyToID = TODO get last directmsg-ID IFF sender equals recipient in this post
 	} else {
-		usertoot.Visibility = "public"
+		usertoot.Visibility = visibilityPublic
 	}
 	if len(inreplyto) > 0 {
 		usertoot.InReplyToID = mastodon.ID(inreplyto)
 	}
+	usertoot.Language = opts.Language
+	usertoot.Poll = opts.Poll
+	usertoot.ScheduledAt = opts.ScheduledAt
 	// log.Println("sendToot", usertoot)
 	var mstatus *mastodon.Status
 	mstatus, err = client.PostStatus(context.Background(), usertoot)
@@ -165,17 +168,75 @@ func sendToot(client *mastodon.Client, post, matrixnick string, directmsg bool,
 	return
 }
 
-func uploadMediaToMastodonWithDescription(client *mastodon.Client, ctx context.Context, file string, description string) (*mastodon.Attachment, error) {
+// toot parts as a thread, each part replying to the previous one and the first one to inreplyto, if set.
+// Media is attached to the first part only. returns the IDs of all parts that could be posted, even in case of error
+func sendTootThread(client *mastodon.Client, parts []string, matrixnick string, opts PostOptions, inreplyto mastodon.ID) (weburl string, statusids []mastodon.ID, err error) {
+	for idx, part := range parts {
+		var partweburl string
+		partweburl, inreplyto, err = sendToot(client, part, matrixnick, opts, string(inreplyto), idx == 0)
+		if err != nil {
+			return
+		}
+		if idx == 0 {
+			weburl = partweburl
+		}
+		statusids = append(statusids, inreplyto)
+	}
+	return
+}
+
+// edit toot id to show post instead. Attached media is kept, descriptions given in opts replace the current ones.
+// As Mastodon removes polls missing from an edit, opts.Poll must be set to keep one
+func updateToot(client *mastodon.Client, id mastodon.ID, post string, opts PostOptions) (weburl string, err error) {
+	ctx := context.Background()
+	var current *mastodon.Status
+	if current, err = client.GetStatus(ctx, id); err != nil {
+		return
+	}
+	params := url.Values{}
+	params.Set("status", post)
+	params.Set("spoiler_text", opts.ContentWarning)
+	params.Set("sensitive", strconv.FormatBool(len(opts.ContentWarning) > 0)) // as in sendToot
+	if len(opts.Language) > 0 {
+		params.Set("language", opts.Language)
+	}
+	for idx, attachment := range current.MediaAttachments {
+		description := attachment.Description
+		if idx < len(opts.MediaDescriptions) {
+			description = opts.MediaDescriptions[idx]
+		}
+		params.Add("media_ids[]", string(attachment.ID))
+		params.Add("media_attributes[][id]", string(attachment.ID))
+		params.Add("media_attributes[][description]", description)
+	}
+	if opts.Poll != nil {
+		for _, option := range opts.Poll.Options {
+			params.Add("poll[options][]", option)
+		}
+		params.Set("poll[expires_in]", strconv.FormatInt(opts.Poll.ExpiresInSeconds, 10))
+		params.Set("poll[multiple]", strconv.FormatBool(opts.Poll.Multiple))
+		params.Set("poll[hide_totals]", strconv.FormatBool(opts.Poll.HideTotals))
+	}
+	var updated mastodon.Status
+	if err = mastodonAPIRequest(ctx, client, http.MethodPut, "/api/v1/statuses/"+url.PathEscape(string(id)), params, &updated); err == nil {
+		weburl = updated.URL
+	}
+	return
+}
+
+// focus is the focal point as "x,y", or "" for none
+func uploadMediaToMastodonWithDescription(client *mastodon.Client, ctx context.Context, file string, description string, focus string) (*mastodon.Attachment, error) {
 	f, err := os.Open(file)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
 
-	return client.UploadMediaFromMedia(ctx, &mastodon.Media{File: f, Description: description})
+	return client.UploadMediaFromMedia(ctx, &mastodon.Media{File: f, Description: description, Focus: focus})
 }
 
-func getImagesForToot(client *mastodon.Client, matrixnick string) ([]mastodon.ID, error) {
+// upload staged media of matrixnick. descriptions given in the post replace those of the first len(descriptions) media
+func getImagesForToot(client *mastodon.Client, matrixnick string, descriptions []string) ([]mastodon.ID, error) {
 	imagepaths, err := getUserFileList(matrixnick)
 	if err != nil {
 		return nil, err
@@ -189,7 +250,12 @@ func getImagesForToot(client *mastodon.Client, matrixnick string) ([]mastodon.ID
 		if imgdescerr != nil {
 			log.Println("readDescriptionOfMediaFile Error:", imgdescerr)
 		}
-		if attachment, err := uploadMediaToMastodonWithDescription(client, context.Background(), imagepath, imagedesc); err != nil {
+		if idx < len(descriptions) {
+			imagedesc = descriptions[idx]
+		}
+		if attachment, err := uploadMediaToMastodonWithDescription(client, context.Background(), imagepath, imagedesc, readFocusOfMediaFile(imagepath)); err != nil {
+			return nil, err
+		} else if err = waitForMastodonMediaProcessing(client, attachment); err != nil {
 			return nil, err
 		} else {
 			mastodon_ids[idx] = attachment.ID